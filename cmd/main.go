@@ -1,16 +1,39 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/tebrizetayi/messaging-integration-service/internal/alert"
 	"github.com/tebrizetayi/messaging-integration-service/internal/api"
+	"github.com/tebrizetayi/messaging-integration-service/internal/billing"
+	"github.com/tebrizetayi/messaging-integration-service/internal/campaign"
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/handoff"
+	"github.com/tebrizetayi/messaging-integration-service/internal/idempotency"
+	"github.com/tebrizetayi/messaging-integration-service/internal/intent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/messenger"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/sms"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/telegram"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/viber"
 	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+	"github.com/tebrizetayi/messaging-integration-service/internal/schedule"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
 )
 
 func main() {
@@ -25,15 +48,134 @@ func main() {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	db, err := storage.Open(config.App.DatabasePath)
+	if err != nil {
+		log.Fatalf("main : Error opening database: %+v", err)
+	}
+	defer db.Close()
+
+	sessions, err := conversation.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating conversation sessions: %+v", err)
+	}
+
+	contactStore, err := contacts.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating contacts: %+v", err)
+	}
+
+	consents, err := consent.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating consents: %+v", err)
+	}
+
+	handoffs, err := handoff.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating handoffs: %+v", err)
+	}
+
+	messageLog, err := messagelog.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating message log: %+v", err)
+	}
+
+	conversations, err := billing.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating billing: %+v", err)
+	}
+
+	idempotencyKeys, err := idempotency.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating idempotency keys: %+v", err)
+	}
+
+	campaigns, err := campaign.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating campaigns: %+v", err)
+	}
+
+	scheduled, err := schedule.NewRepository(db)
+	if err != nil {
+		log.Fatalf("main : Error migrating scheduled messages: %+v", err)
+	}
+
 	messengerClient := whatsapp.NewClient(
 		"4917635163191",
 		config.App.WhatsappAccessToken,
 		"https://graph.facebook.com/v16.0/",
 		config.App.WhatsappAccessToken,
 	)
+	messengerClient.SetRecorder(api.NewSentRecorder(messageLog))
+
+	intents, err := newIntentRouter(config.App)
+	if err != nil {
+		log.Fatalf("main : Error loading intent rules: %+v", err)
+	}
+
+	messages, err := newCatalog(config.App)
+	if err != nil {
+		log.Fatalf("main : Error loading message catalog: %+v", err)
+	}
+
+	resultLookup, err := newResultLookup(config.App)
+	if err != nil {
+		log.Fatalf("main : Error configuring result lookup: %+v", err)
+	}
+
+	throttled := newNotifier(config)
+	stopAlerts := make(chan struct{})
+	defer close(stopAlerts)
+	go throttled.Run(time.Minute, stopAlerts)
+
+	notifier := alert.NewAsync(throttled, alertQueueSize)
+	defer notifier.Close()
 
 	// Services
-	controller := api.NewController(&messengerClient)
+	opts := []api.Option{
+		api.WithResultLookup(resultLookup),
+		api.WithIntentRouter(intents),
+		api.WithCatalog(messages),
+		api.WithConversationStore(sessions),
+		api.WithContactStore(contactStore),
+		api.WithConsentLedger(consents),
+		api.WithHandoffStore(handoffs),
+		api.WithAPIKeys(config.App.APIKeys...),
+		api.WithMessageLog(messageLog),
+		api.WithBilling(conversations),
+		api.WithNotifier(notifier),
+		api.WithWindowTemplate(config.App.WindowTemplate, config.App.WindowTemplateLanguage),
+		api.WithIdempotencyStore(idempotencyKeys),
+		api.WithBusinessNumbers(config.App.BusinessNumbers...),
+		api.WithCampaigns(campaigns, config.App.CampaignRate),
+		api.WithScheduler(scheduled),
+	}
+	providers, err := newChannels(config)
+	if err != nil {
+		log.Fatalf("main : Error configuring channels: %+v", err)
+	}
+	for _, provider := range providers {
+		opts = append(opts, api.WithChannel(provider))
+	}
+	if config.SMS.GatewayURL != "" {
+		gateway := sms.NewGateway(config.SMS.GatewayURL, config.SMS.AuthHeader, config.SMS.AuthValue, config.SMS.BodyTemplate)
+		opts = append(opts, api.WithFallback(gateway, config.SMS.FallbackTimeout))
+	}
+	if config.SMTP.Addr != "" {
+		opts = append(opts, api.WithEmail(email.NewSender(config.SMTP.Addr, config.SMTP.Username, config.SMTP.Password, config.SMTP.From, config.SMTP.TLS)))
+	}
+	controller := api.NewController(&messengerClient, opts...)
+
+	stopFallback := make(chan struct{})
+	defer close(stopFallback)
+	go controller.RunFallback(time.Minute, stopFallback)
+
+	stopCampaigns := make(chan struct{})
+	defer close(stopCampaigns)
+	go controller.RunCampaigns(10*time.Second, stopCampaigns)
+
+	stopScheduler := make(chan struct{})
+	defer close(stopScheduler)
+	go controller.RunScheduler(30*time.Second, stopScheduler)
 
 	// Start the HTTP service listening for requests.
 	api := http.Server{
@@ -56,26 +198,235 @@ func main() {
 
 	case sig := <-shutdown:
 		log.Printf("main : %v : Start shutdown..", sig)
+		controller.WaitEmails()
 	}
 }
 
 type Config struct {
-	App AppConfig
+	App      AppConfig
+	SMTP     SMTPConfig
+	Alert    AlertConfig
+	Telegram TelegramConfig
+	Viber    ViberConfig
+	Meta     MetaConfig
+	SMS      SMSConfig
+}
+
+type MetaConfig struct {
+	// The Messenger and Instagram conversations are answered with the page access tokens
+	MessengerPageToken string
+	InstagramPageToken string
+	AppSecret          string
+}
+
+type ViberConfig struct {
+	AuthToken  string
+	SenderName string
+}
+
+type SMSConfig struct {
+	// GatewayURL and BodyTemplate may contain the {to} and {text} placeholders
+	GatewayURL      string
+	AuthHeader      string
+	AuthValue       string
+	BodyTemplate    string
+	FallbackTimeout time.Duration
+}
+
+type TelegramConfig struct {
+	BotToken    string
+	SecretToken string
+}
+
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	// TLS is either "starttls", "tls" or "none"
+	TLS string
+}
+
+type AlertConfig struct {
+	WebhookURL string
+	EmailTo    []string
+	// Window is how long the same alert isn't raised again
+	Window time.Duration
 }
 type AppConfig struct {
 	Port                string
 	WhatsappAccessToken string
 	VerifyToken         string
+
+	// ResultLookup is either "local" or "http"
+	ResultLookup          string
+	ResultLookupURL       string
+	ResultLookupAuthName  string
+	ResultLookupAuthValue string
+	DocumentURLTemplate   string
+	IntentRulesFile       string
+	CatalogDir            string
+	DefaultLanguage       string
+	DatabasePath          string
+
+	// WindowTemplate is sent instead of the messages to contacts who haven't written in 24 hours
+	WindowTemplate         string
+	WindowTemplateLanguage string
+
+	// APIKeys authenticate the staff and the internal systems sending messages
+	APIKeys []string
+	// BusinessNumbers are the numbers the messages of the API may be sent from,
+	// the first one when the request doesn't name one
+	BusinessNumbers []string
+	// CampaignRate is the number of campaign messages sent per second,
+	// the throughput tier of the business number
+	CampaignRate int
 }
 
 func initConfig() Config {
 	viper.AutomaticEnv()
+	viper.SetDefault("RESULT_LOOKUP", "local")
+	viper.SetDefault("DEFAULT_LANGUAGE", "az")
+	viper.SetDefault("DATABASE_PATH", "messages.db")
+	viper.SetDefault("SMS_FALLBACK_TIMEOUT", "15m")
+	viper.SetDefault("SMTP_TLS", "starttls")
+	viper.SetDefault("ALERT_WINDOW", "15m")
+	viper.SetDefault("VIBER_SENDER_NAME", "Lab")
+	viper.SetDefault("WHATSAPP_BUSINESS_NUMBER", "15550909792")
+	viper.SetDefault("CAMPAIGN_RATE", campaign.DefaultRate)
 
 	return Config{
+		SMTP: SMTPConfig{
+			Addr:     viper.GetString("SMTP_ADDR"),
+			Username: viper.GetString("SMTP_USERNAME"),
+			Password: viper.GetString("SMTP_PASSWORD"),
+			From:     viper.GetString("SMTP_FROM"),
+			TLS:      viper.GetString("SMTP_TLS"),
+		},
+		Alert: AlertConfig{
+			WebhookURL: viper.GetString("ALERT_WEBHOOK_URL"),
+			EmailTo:    strings.FieldsFunc(viper.GetString("ALERT_EMAIL_TO"), func(r rune) bool { return r == ',' }),
+			Window:     viper.GetDuration("ALERT_WINDOW"),
+		},
+		Telegram: TelegramConfig{
+			BotToken:    viper.GetString("TELEGRAM_BOT_TOKEN"),
+			SecretToken: viper.GetString("TELEGRAM_SECRET_TOKEN"),
+		},
+		Viber: ViberConfig{
+			AuthToken:  viper.GetString("VIBER_AUTH_TOKEN"),
+			SenderName: viper.GetString("VIBER_SENDER_NAME"),
+		},
+		Meta: MetaConfig{
+			MessengerPageToken: viper.GetString("MESSENGER_PAGE_TOKEN"),
+			InstagramPageToken: viper.GetString("INSTAGRAM_PAGE_TOKEN"),
+			AppSecret:          viper.GetString("META_APP_SECRET"),
+		},
+		SMS: SMSConfig{
+			GatewayURL:      viper.GetString("SMS_GATEWAY_URL"),
+			AuthHeader:      viper.GetString("SMS_GATEWAY_AUTH_HEADER"),
+			AuthValue:       viper.GetString("SMS_GATEWAY_AUTH_VALUE"),
+			BodyTemplate:    viper.GetString("SMS_GATEWAY_BODY"),
+			FallbackTimeout: viper.GetDuration("SMS_FALLBACK_TIMEOUT"),
+		},
 		App: AppConfig{
-			Port:                viper.GetString("PORT"),
-			WhatsappAccessToken: viper.GetString("WHATSAPP_ACCESS_TOKEN"),
-			VerifyToken:         viper.GetString("VERIFY_TOKEN"),
+			Port:                   viper.GetString("PORT"),
+			WhatsappAccessToken:    viper.GetString("WHATSAPP_ACCESS_TOKEN"),
+			VerifyToken:            viper.GetString("VERIFY_TOKEN"),
+			ResultLookup:           viper.GetString("RESULT_LOOKUP"),
+			ResultLookupURL:        viper.GetString("RESULT_LOOKUP_URL"),
+			ResultLookupAuthName:   viper.GetString("RESULT_LOOKUP_AUTH_HEADER"),
+			ResultLookupAuthValue:  viper.GetString("RESULT_LOOKUP_AUTH_VALUE"),
+			DocumentURLTemplate:    viper.GetString("DOCUMENT_URL_TEMPLATE"),
+			IntentRulesFile:        viper.GetString("INTENT_RULES_FILE"),
+			CatalogDir:             viper.GetString("CATALOG_DIR"),
+			DefaultLanguage:        viper.GetString("DEFAULT_LANGUAGE"),
+			DatabasePath:           viper.GetString("DATABASE_PATH"),
+			WindowTemplate:         viper.GetString("WINDOW_TEMPLATE"),
+			WindowTemplateLanguage: viper.GetString("WINDOW_TEMPLATE_LANGUAGE"),
+			APIKeys:                strings.FieldsFunc(viper.GetString("API_KEYS"), func(r rune) bool { return r == ',' }),
+			BusinessNumbers:        strings.FieldsFunc(viper.GetString("WHATSAPP_BUSINESS_NUMBER"), func(r rune) bool { return r == ',' }),
+			CampaignRate:           viper.GetInt("CAMPAIGN_RATE"),
 		},
 	}
 }
+
+// newResultLookup returns the lookup of the results. The documents are sent
+// by their public URL, so DOCUMENT_URL_TEMPLATE is required.
+func newResultLookup(config AppConfig) (api.ResultLookup, error) {
+	if config.DocumentURLTemplate == "" {
+		return nil, errors.New("DOCUMENT_URL_TEMPLATE is required, e.g. https://host/api/v1/%s/document")
+	}
+
+	switch config.ResultLookup {
+	case "http":
+		return results.NewHTTPLookup(config.ResultLookupURL, config.DocumentURLTemplate, config.ResultLookupAuthName, config.ResultLookupAuthValue), nil
+	default:
+		return results.NewLocalStore("", config.DocumentURLTemplate), nil
+	}
+}
+
+func newIntentRouter(config AppConfig) (*intent.Router, error) {
+	rules := intent.DefaultRules()
+	if config.IntentRulesFile != "" {
+		var err error
+		rules, err = intent.LoadRules(config.IntentRulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return intent.NewRouter(rules)
+}
+
+func newCatalog(config AppConfig) (*catalog.Catalog, error) {
+	if config.CatalogDir != "" {
+		return catalog.Load(config.CatalogDir, config.DefaultLanguage)
+	}
+
+	return catalog.Default(config.DefaultLanguage)
+}
+
+// alertQueueSize is how many alerts may wait to be raised
+const alertQueueSize = 100
+
+// newNotifier returns a notifier logging the alerts and sending them
+// to the configured webhook and email addresses, the same alert once per window.
+func newNotifier(config Config) *alert.Throttled {
+	notifiers := alert.Multi{alert.LogNotifier{}}
+	if config.Alert.WebhookURL != "" {
+		notifiers = append(notifiers, alert.NewWebhookNotifier(config.Alert.WebhookURL))
+	}
+	if len(config.Alert.EmailTo) > 0 && config.SMTP.Addr != "" {
+		sender := email.NewSender(config.SMTP.Addr, config.SMTP.Username, config.SMTP.Password, config.SMTP.From, config.SMTP.TLS)
+		notifiers = append(notifiers, alert.NewEmailNotifier(sender, config.Alert.EmailTo))
+	}
+
+	return alert.NewThrottled(notifiers, config.Alert.Window)
+}
+
+// newChannels returns the providers of the configured channels besides WhatsApp.
+// The webhooks of Telegram and Meta are only verified with their secret, a
+// channel configured without it would accept forged updates and is refused.
+func newChannels(config Config) ([]channel.Provider, error) {
+	var providers []channel.Provider
+	if config.Telegram.BotToken != "" {
+		if config.Telegram.SecretToken == "" {
+			return nil, errors.New("TELEGRAM_SECRET_TOKEN is required with TELEGRAM_BOT_TOKEN")
+		}
+		providers = append(providers, telegram.NewClient(config.Telegram.BotToken, config.Telegram.SecretToken, telegram.Endpoint))
+	}
+	if config.Viber.AuthToken != "" {
+		providers = append(providers, viber.NewClient(config.Viber.AuthToken, config.Viber.SenderName, viber.Endpoint))
+	}
+	if (config.Meta.MessengerPageToken != "" || config.Meta.InstagramPageToken != "") && config.Meta.AppSecret == "" {
+		return nil, errors.New("META_APP_SECRET is required with MESSENGER_PAGE_TOKEN and INSTAGRAM_PAGE_TOKEN")
+	}
+	if config.Meta.MessengerPageToken != "" {
+		providers = append(providers, messenger.NewClient(messenger.ChannelMessenger, config.Meta.MessengerPageToken, config.Meta.AppSecret, messenger.Endpoint))
+	}
+	if config.Meta.InstagramPageToken != "" {
+		providers = append(providers, messenger.NewClient(messenger.ChannelInstagram, config.Meta.InstagramPageToken, config.Meta.AppSecret, messenger.Endpoint))
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,349 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+)
+
+var (
+	// ErrQueueFull is returned by Async when the alerts come faster than they are raised
+	ErrQueueFull = errors.New("alert queue full")
+	// ErrClosed is returned by Async once it is closed
+	ErrClosed = errors.New("alert queue closed")
+)
+
+// Alert tells ops that something went wrong, e.g. results could not be delivered
+type Alert struct {
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	At      time.Time         `json:"at"`
+}
+
+// Notifier raises the alerts
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// LogNotifier writes the alerts to the log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(alert Alert) error {
+	log.Printf("ALERT %s: %s %s", alert.Title, alert.Message, formatFields(alert.Fields))
+	return nil
+}
+
+// WebhookNotifier posts the alerts as JSON to a URL
+type WebhookNotifier struct {
+	URL    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) WebhookNotifier {
+	return WebhookNotifier{
+		URL:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n WebhookNotifier) Notify(alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("alert webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Mailer sends the emails, e.g. the email.Sender of the results
+type Mailer interface {
+	Send(message email.Message) error
+}
+
+// EmailNotifier sends the alerts by email to the addresses
+type EmailNotifier struct {
+	Mailer Mailer
+	To     []string
+}
+
+func NewEmailNotifier(mailer Mailer, to []string) EmailNotifier {
+	return EmailNotifier{
+		Mailer: mailer,
+		To:     to,
+	}
+}
+
+func (n EmailNotifier) Notify(alert Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", alert.Message)
+	for _, key := range sortedKeys(alert.Fields) {
+		fmt.Fprintf(&body, "%s: %s\n", key, alert.Fields[key])
+	}
+	fmt.Fprintf(&body, "\n%s\n", alert.At.Format(time.RFC3339))
+
+	return n.Mailer.Send(email.Message{
+		To:      n.To,
+		Subject: "[alert] " + alert.Title,
+		Body:    body.String(),
+	})
+}
+
+// Multi raises the alerts through all the notifiers
+type Multi []Notifier
+
+func (m Multi) Notify(alert Alert) error {
+	var errs []string
+	for _, notifier := range m {
+		err := notifier.Notify(alert)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error raising alert: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Throttled raises an alert once per window, e.g. a broken webhook failing
+// every message raises one alert instead of thousands. The alerts are the
+// same when their title and message are. The number of alerts held back is
+// added as the "suppressed" field to the next alert raised, or to a summary
+// of the last one held back once the window expires.
+type Throttled struct {
+	Notifier Notifier
+	Window   time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*throttle
+	now  func() time.Time
+}
+
+type throttle struct {
+	raised     time.Time
+	suppressed int
+	last       Alert
+}
+
+func NewThrottled(notifier Notifier, window time.Duration) *Throttled {
+	return &Throttled{
+		Notifier: notifier,
+		Window:   window,
+		seen:     map[string]*throttle{},
+		now:      time.Now,
+	}
+}
+
+func (t *Throttled) Notify(alert Alert) error {
+	key := alert.Title + "\x00" + alert.Message
+	now := t.now()
+
+	t.mu.Lock()
+	seen, ok := t.seen[key]
+	if ok && now.Sub(seen.raised) < t.Window {
+		seen.suppressed++
+		seen.last = alert
+		t.mu.Unlock()
+		return nil
+	}
+
+	var suppressed int
+	if ok {
+		suppressed = seen.suppressed
+	}
+	t.seen[key] = &throttle{raised: now}
+	summaries := t.expire(now)
+	t.mu.Unlock()
+
+	var errs []string
+	for _, summary := range summaries {
+		err := t.Notifier.Notify(summary)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	err := t.Notifier.Notify(withSuppressed(alert, suppressed))
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Flush raises a summary of the alerts held back in the windows expired at now
+func (t *Throttled) Flush(now time.Time) error {
+	t.mu.Lock()
+	summaries := t.expire(now)
+	t.mu.Unlock()
+
+	var errs []string
+	for _, summary := range summaries {
+		err := t.Notifier.Notify(summary)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error raising alert summaries: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Run flushes the expired windows every interval until stop is closed
+func (t *Throttled) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := t.Flush(now)
+			if err != nil {
+				log.Printf("Error flushing alerts: %v", err)
+			}
+		}
+	}
+}
+
+// expire drops the windows expired at now and returns a summary of the
+// alerts they held back. It must be called with the lock held.
+func (t *Throttled) expire(now time.Time) []Alert {
+	var summaries []Alert
+	for key, seen := range t.seen {
+		if now.Sub(seen.raised) < t.Window {
+			continue
+		}
+		if seen.suppressed > 0 {
+			summary := withSuppressed(seen.last, seen.suppressed)
+			summary.At = now
+			summaries = append(summaries, summary)
+		}
+		delete(t.seen, key)
+	}
+
+	return summaries
+}
+
+// withSuppressed adds the number of alerts held back to the fields of the alert
+func withSuppressed(alert Alert, suppressed int) Alert {
+	if suppressed == 0 {
+		return alert
+	}
+
+	fields := map[string]string{"suppressed": strconv.Itoa(suppressed)}
+	for k, v := range alert.Fields {
+		fields[k] = v
+	}
+	alert.Fields = fields
+
+	return alert
+}
+
+// Async raises the alerts in the background so the caller, e.g. a webhook,
+// doesn't wait for the SMTP server or the alert webhook. The alerts beyond
+// the size of the queue are dropped with ErrQueueFull.
+type Async struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Alert
+	done   chan struct{}
+}
+
+// NewAsync starts raising the alerts through the notifier until Close is called
+func NewAsync(notifier Notifier, size int) *Async {
+	a := &Async{
+		queue: make(chan Alert, size),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(a.done)
+		for alert := range a.queue {
+			err := notifier.Notify(alert)
+			if err != nil {
+				log.Printf("Error raising alert %q: %v", alert.Title, err)
+			}
+		}
+	}()
+
+	return a
+}
+
+func (a *Async) Notify(alert Alert) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return fmt.Errorf("%w: %s", ErrClosed, alert.Title)
+	}
+
+	select {
+	case a.queue <- alert:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrQueueFull, alert.Title)
+	}
+}
+
+// Close raises the queued alerts and stops, the alerts notified afterwards are dropped
+func (a *Async) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+}
+
+func formatFields(fields map[string]string) string {
+	var pairs []string
+	for _, key := range sortedKeys(fields) {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, fields[key]))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,209 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+)
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	alert := Alert{
+		Title:   "Message failed",
+		Message: "131026: Message undeliverable",
+		Fields:  map[string]string{"recipient": "994503981865"},
+		At:      time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC),
+	}
+
+	err := NewWebhookNotifier(server.URL).Notify(alert)
+	if err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+	if received.Title != alert.Title || received.Fields["recipient"] != "994503981865" || !received.At.Equal(alert.At) {
+		t.Errorf("unexpected alert: %+v", received)
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(alert Alert) error {
+	return errors.New("unreachable")
+}
+
+func TestMulti_Notify(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	// A failing notifier doesn't prevent the others from raising the alert
+	err := Multi{failingNotifier{}, LogNotifier{}, NewWebhookNotifier(server.URL)}.Notify(Alert{Title: "test"})
+	if err == nil {
+		t.Errorf("expected the error of the failing notifier")
+	}
+	if calls != 1 {
+		t.Errorf("expected the webhook to be called once, got %d", calls)
+	}
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recordingNotifier) Notify(alert Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+type fakeMailer struct {
+	messages []email.Message
+}
+
+func (f *fakeMailer) Send(message email.Message) error {
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func TestEmailNotifier_Notify(t *testing.T) {
+	mailer := &fakeMailer{}
+	alert := Alert{
+		Title:   "Message failed",
+		Message: "131026: Message undeliverable",
+		Fields:  map[string]string{"recipient": "994503981865"},
+		At:      time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC),
+	}
+
+	err := NewEmailNotifier(mailer, []string{"ops@example.com"}).Notify(alert)
+	if err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+	if len(mailer.messages) != 1 {
+		t.Fatalf("expected one email, got %+v", mailer.messages)
+	}
+	message := mailer.messages[0]
+	if message.Subject != "[alert] Message failed" || message.To[0] != "ops@example.com" || !strings.Contains(message.Body, "recipient: 994503981865") {
+		t.Errorf("unexpected email: %+v", message)
+	}
+}
+
+func TestThrottled_Notify(t *testing.T) {
+	recorder := &recordingNotifier{}
+	throttled := NewThrottled(recorder, 15*time.Minute)
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	throttled.now = func() time.Time { return now }
+
+	failed := Alert{Title: "WhatsApp message failed", Message: "131026: Message undeliverable"}
+	for i := 0; i < 3; i++ {
+		throttled.Notify(failed)
+	}
+	throttled.Notify(Alert{Title: "WhatsApp webhook error", Message: "131000: Something went wrong"})
+	if len(recorder.alerts) != 2 {
+		t.Fatalf("expected each alert once, got %+v", recorder.alerts)
+	}
+
+	now = now.Add(15 * time.Minute)
+	throttled.Notify(failed)
+	if len(recorder.alerts) != 3 || recorder.alerts[2].Fields["suppressed"] != "2" {
+		t.Errorf("expected the alert again with the suppressed count, got %+v", recorder.alerts)
+	}
+}
+
+func TestThrottled_Flush(t *testing.T) {
+	recorder := &recordingNotifier{}
+	throttled := NewThrottled(recorder, 15*time.Minute)
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	throttled.now = func() time.Time { return now }
+
+	failed := Alert{Title: "WhatsApp message failed", Message: "131026: Message undeliverable"}
+	throttled.Notify(failed)
+	throttled.Notify(Alert{Title: failed.Title, Message: failed.Message, Fields: map[string]string{"recipient": "994503981865"}})
+	throttled.Notify(Alert{Title: "WhatsApp webhook error", Message: "131000: Something went wrong"})
+
+	err := throttled.Flush(now.Add(10 * time.Minute))
+	if err != nil || len(recorder.alerts) != 2 {
+		t.Fatalf("expected no summary within the window, got %v %+v", err, recorder.alerts)
+	}
+
+	err = throttled.Flush(now.Add(15 * time.Minute))
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	if len(recorder.alerts) != 3 {
+		t.Fatalf("expected a summary of the suppressed alert, got %+v", recorder.alerts)
+	}
+	summary := recorder.alerts[2]
+	if summary.Title != failed.Title || summary.Fields["suppressed"] != "1" || summary.Fields["recipient"] != "994503981865" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if len(throttled.seen) != 0 {
+		t.Errorf("expected the expired windows to be dropped, got %d", len(throttled.seen))
+	}
+
+	now = now.Add(20 * time.Minute)
+	throttled.Notify(failed)
+	if len(recorder.alerts) != 4 || recorder.alerts[3].Fields["suppressed"] != "" {
+		t.Errorf("expected the alert without a suppressed count after the summary, got %+v", recorder.alerts)
+	}
+}
+
+// blockingNotifier raises the alerts once released
+type blockingNotifier struct {
+	recordingNotifier
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Notify(alert Alert) error {
+	<-b.release
+	return b.recordingNotifier.Notify(alert)
+}
+
+func TestAsync_Notify(t *testing.T) {
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	async := NewAsync(notifier, 1)
+
+	// The first alert is taken by the notifier, the second waits in the queue
+	err := async.Notify(Alert{Title: "first"})
+	if err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+	for {
+		err = async.Notify(Alert{Title: "queued"})
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err = async.Notify(Alert{Title: "dropped"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	close(notifier.release)
+	async.Close()
+
+	if len(notifier.alerts) != 2 || notifier.alerts[1].Title != "queued" {
+		t.Errorf("expected the queued alerts to be raised on close, got %+v", notifier.alerts)
+	}
+	if err := async.Notify(Alert{Title: "late"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+}
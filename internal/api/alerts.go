@@ -0,0 +1,69 @@
+package api
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/alert"
+)
+
+// reportWebhookErrors raises the errors Meta reports for the whole webhook,
+// e.g. when the webhook configuration or the account has a problem
+func (c *Controller) reportWebhookErrors(md *MessengerData) {
+	businessNumber, _ := md.GetBusinessNumber()
+	for _, webhookError := range md.GetErrors() {
+		log.Printf("Webhook error; business number:%s error:%v message:%s", businessNumber, webhookError, webhookError.Message)
+		c.notify(alert.Alert{
+			Title:   "WhatsApp webhook error",
+			Message: webhookError.Error(),
+			Fields:  errorFields(webhookError, map[string]string{"business_number": businessNumber}),
+			At:      time.Now(),
+		})
+	}
+}
+
+// reportStatusErrors raises the errors of a message which could not be delivered
+func (c *Controller) reportStatusErrors(businessNumber string, status Status) {
+	for _, webhookError := range status.Errors {
+		log.Printf("Message %s to %s failed; error:%v message:%s", status.ID, status.RecipientID, webhookError, webhookError.Message)
+		c.notify(alert.Alert{
+			Title:   "WhatsApp message failed",
+			Message: webhookError.Error(),
+			Fields: errorFields(webhookError, map[string]string{
+				"business_number": businessNumber,
+				"recipient":       status.RecipientID,
+				"message_id":      status.ID,
+				"status":          status.Status,
+			}),
+			At: status.Timestamp,
+		})
+	}
+}
+
+func (c *Controller) notify(a alert.Alert) {
+	err := c.notifier.Notify(a)
+	if err != nil {
+		log.Printf("Error raising alert %q: %v", a.Title, err)
+	}
+}
+
+func errorFields(webhookError WebhookError, fields map[string]string) map[string]string {
+	fields["code"] = strconv.Itoa(webhookError.Code)
+	if webhookError.Details != "" {
+		fields["details"] = webhookError.Details
+	}
+
+	return fields
+}
+
+// joinErrors formats the errors to be stored along the message
+func joinErrors(webhookErrors []WebhookError) string {
+	var errs []string
+	for _, webhookError := range webhookErrors {
+		errs = append(errs, webhookError.Error())
+	}
+
+	return strings.Join(errs, "; ")
+}
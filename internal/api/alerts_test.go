@@ -0,0 +1,66 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/alert"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+)
+
+type fakeNotifier struct {
+	alerts []alert.Alert
+}
+
+func (f *fakeNotifier) Notify(a alert.Alert) error {
+	f.alerts = append(f.alerts, a)
+	return nil
+}
+
+func TestParseMessage_FailedStatus(t *testing.T) {
+	ml := newTestMessageLog(t)
+	notifier := &fakeNotifier{}
+	c := NewController(nil, WithMessageLog(ml), WithNotifier(notifier))
+
+	err := ml.SaveOutbound(messagelog.OutboundRecord{ProviderID: "wamid.4", To: "994503981865", Type: "document", Status: messagelog.StatusSent, CreatedAt: time.Unix(1681899800, 0)})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	data := []byte(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"statuses":[{"id":"wamid.4","status":"failed","timestamp":"1681899900","recipient_id":"994503981865","errors":[{"code":131026,"title":"Message undeliverable","message":"Message undeliverable","error_data":{"details":"Receiver is incapable of receiving this message"}}]}]},"field":"messages"}]}]}`)
+	err = c.parsingMessage(data)
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	record, err := ml.Outbound("wamid.4")
+	if err != nil {
+		t.Fatalf("error loading outbound message: %v", err)
+	}
+	if record.Status != messagelog.StatusFailed || record.Error != "131026: Message undeliverable (Receiver is incapable of receiving this message)" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+
+	if len(notifier.alerts) != 1 {
+		t.Fatalf("expected one alert, got %+v", notifier.alerts)
+	}
+	fields := notifier.alerts[0].Fields
+	if fields["code"] != "131026" || fields["recipient"] != "994503981865" || fields["message_id"] != "wamid.4" {
+		t.Errorf("unexpected alert fields: %+v", fields)
+	}
+}
+
+func TestParseMessage_WebhookErrors(t *testing.T) {
+	notifier := &fakeNotifier{}
+	c := NewController(nil, WithNotifier(notifier))
+
+	data := []byte(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"errors":[{"code":131051,"title":"Unsupported message type","message":"Unsupported message type","error_data":{"details":"Message type is not currently supported"}}]},"field":"messages"}]}]}`)
+	err := c.parsingMessage(data)
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	if len(notifier.alerts) != 1 || notifier.alerts[0].Fields["code"] != "131051" || notifier.alerts[0].Fields["business_number"] != "15550909792" {
+		t.Errorf("unexpected alerts: %+v", notifier.alerts)
+	}
+}
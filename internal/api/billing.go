@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/billing"
+)
+
+const reportDateLayout = "2006-01-02"
+
+// GetBillingReport returns the number of conversations per period, business number and category.
+// The query parameters are period (day or month, default month), from and to (YYYY-MM-DD, to exclusive).
+// The current month is reported by default.
+func (c *Controller) GetBillingReport(w http.ResponseWriter, r *http.Request) {
+	if c.billing == nil {
+		http.Error(w, "billing is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now().UTC()
+	query := billing.ReportQuery{
+		From:   time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		Period: billing.PeriodMonth,
+	}
+	query.To = query.From.AddDate(0, 1, 0)
+
+	var err error
+	if period := r.URL.Query().Get("period"); period != "" {
+		query.Period = period
+	}
+	if from := r.URL.Query().Get("from"); from != "" {
+		query.From, err = time.Parse(reportDateLayout, from)
+		if err != nil {
+			http.Error(w, "invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		query.To, err = time.Parse(reportDateLayout, to)
+		if err != nil {
+			http.Error(w, "invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Period != billing.PeriodDay && query.Period != billing.PeriodMonth {
+		http.Error(w, "period must be day or month", http.StatusBadRequest)
+		return
+	}
+
+	report, err := c.billing.Report(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/billing"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func reportRequest(api http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", "Bearer finance-key")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestGetBillingReport_Success(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "billing.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	conversations, err := billing.NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	c := NewController(nil, WithAPIKeys("finance-key"), WithBilling(conversations))
+	api := NewAPI(c)
+
+	data := []byte(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"statuses":[{"id":"wamid.HBgNNDkxNzYzNTE2MzE5MRUCABEYEjY3OENERDM4RTY2Mzc3RkE4MgA=","status":"delivered","timestamp":"1681903556","recipient_id":"4917635163191","conversation":{"id":"6f1a08afbb622fbac2235469f724a890","origin":{"type":"user_initiated"}},"pricing":{"billable":true,"pricing_model":"CBP","category":"user_initiated"}}]},"field":"messages"}]}]}`)
+	err = c.parsingMessage(data)
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/reports/billing?period=day", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without an API key, got %d", rr.Code)
+	}
+
+	rr = reportRequest(api, "/api/v1/reports/billing?period=day&from=2023-04-01&to=2023-05-01")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var report []billing.ReportRow
+	err = json.NewDecoder(rr.Body).Decode(&report)
+	if err != nil {
+		t.Fatalf("Unable to decode report: %v", err)
+	}
+	expected := billing.ReportRow{Period: "2023-04-19", BusinessNumber: "15550909792", Category: "user_initiated", Conversations: 1, Billable: 1}
+	if len(report) != 1 || report[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+
+	rr = reportRequest(api, "/api/v1/reports/billing?period=week")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
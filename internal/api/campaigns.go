@@ -0,0 +1,377 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/campaign"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+const (
+	// errorCodeRateLimit is the error of the messages over the throughput of the business number
+	errorCodeRateLimit = 130429
+
+	// campaignBatchSize is the number of recipients sent between two checks of the campaign status,
+	// so a paused or cancelled campaign stops within a batch
+	campaignBatchSize = 20
+
+	// rateLimitBackoff is how long the campaigns wait after hitting the rate limit
+	rateLimitBackoff = 5 * time.Second
+
+	// campaignClaimTimeout is how long a recipient may stay sending before its
+	// outcome is given up on, the send of a template takes seconds
+	campaignClaimTimeout = 10 * time.Minute
+)
+
+// CampaignRequest is the JSON body of CreateCampaign. The campaign is sent
+// at ScheduledAt, right away if it is empty.
+type CampaignRequest struct {
+	Name        string             `json:"name"`
+	From        string             `json:"from"`
+	Template    OutboundTemplate   `json:"template"`
+	ScheduledAt *time.Time         `json:"scheduled_at"`
+	Recipients  []CampaignAudience `json:"recipients"`
+}
+
+// CampaignAudience is a recipient of a campaign, the parameters fill the
+// {{1}}, {{2}}, ... placeholders of the template body
+type CampaignAudience struct {
+	To         string   `json:"to"`
+	Parameters []string `json:"parameters"`
+}
+
+// CampaignRecipient is a recipient with the delivery status of the message sent to it
+type CampaignRecipient struct {
+	campaign.Recipient
+	Delivery string `json:"delivery,omitempty"`
+}
+
+func (r CampaignRequest) validate() error {
+	if r.Template.Name == "" {
+		return errors.New("template name is required")
+	}
+	if len(r.Template.Parameters) > 0 {
+		return errors.New("template parameters are set per recipient on campaigns")
+	}
+	if len(r.Recipients) == 0 {
+		return errors.New("recipients are required")
+	}
+	for _, recipient := range r.Recipients {
+		if recipient.To == "" || strings.Contains(recipient.To, ":") {
+			return fmt.Errorf("invalid recipient %q", recipient.To)
+		}
+	}
+
+	return nil
+}
+
+// CreateCampaign schedules a template to the recipients of the list
+func (c *Controller) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	if c.campaigns == nil {
+		http.Error(w, "campaigns are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request CampaignRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = request.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scheduled := campaign.Campaign{
+		Name:             request.Name,
+		From:             request.From,
+		Template:         request.Template.Name,
+		TemplateLanguage: request.Template.Language,
+		ScheduledAt:      time.Now(),
+	}
+	scheduled.From, err = c.senderNumber(scheduled.From)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if scheduled.TemplateLanguage == "" {
+		scheduled.TemplateLanguage = c.catalog.Language("")
+	}
+	if request.ScheduledAt != nil {
+		scheduled.ScheduledAt = *request.ScheduledAt
+	}
+
+	recipients := make([]campaign.Recipient, 0, len(request.Recipients))
+	for _, recipient := range request.Recipients {
+		recipients = append(recipients, campaign.Recipient{To: recipient.To, Parameters: recipient.Parameters})
+	}
+
+	created, err := c.campaigns.Create(scheduled, recipients)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err = c.campaigns.Get(created.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// GetCampaign returns the campaign with the number of recipients per status
+func (c *Controller) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	if c.campaigns == nil {
+		http.Error(w, "campaigns are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	found, err := c.campaigns.Get(mux.Vars(r)["id"])
+	if errors.Is(err, campaign.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, found)
+}
+
+// ListCampaignRecipients returns the status of each recipient of the campaign,
+// with the delivery status of the sent messages when the message log is configured
+func (c *Controller) ListCampaignRecipients(w http.ResponseWriter, r *http.Request) {
+	if c.campaigns == nil {
+		http.Error(w, "campaigns are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	_, err := c.campaigns.Get(id)
+	if errors.Is(err, campaign.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recipients, err := c.campaigns.Recipients(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]CampaignRecipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		item := CampaignRecipient{Recipient: recipient}
+		if c.messageLog != nil && recipient.MessageID != "" {
+			record, err := c.messageLog.Outbound(recipient.MessageID)
+			if err == nil {
+				item.Delivery = record.Status
+			}
+		}
+		list = append(list, item)
+	}
+
+	respondJSON(w, http.StatusOK, list)
+}
+
+// PauseCampaign stops sending the campaign until it is resumed
+func (c *Controller) PauseCampaign(w http.ResponseWriter, r *http.Request) {
+	c.transitionCampaign(w, r, campaign.ActionPause)
+}
+
+// ResumeCampaign continues sending a paused campaign to the remaining recipients
+func (c *Controller) ResumeCampaign(w http.ResponseWriter, r *http.Request) {
+	c.transitionCampaign(w, r, campaign.ActionResume)
+}
+
+// CancelCampaign stops the campaign for good, the remaining recipients are cancelled
+func (c *Controller) CancelCampaign(w http.ResponseWriter, r *http.Request) {
+	c.transitionCampaign(w, r, campaign.ActionCancel)
+}
+
+func (c *Controller) transitionCampaign(w http.ResponseWriter, r *http.Request, action string) {
+	if c.campaigns == nil {
+		http.Error(w, "campaigns are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	updated, err := c.campaigns.Transition(mux.Vars(r)["id"], action)
+	if errors.Is(err, campaign.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, campaign.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, updated)
+}
+
+// RunCampaigns sends the due campaigns every interval until stop is closed
+func (c *Controller) RunCampaigns(interval time.Duration, stop <-chan struct{}) {
+	if c.campaigns == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := c.SendCampaigns(now, stop)
+			if err != nil {
+				log.Printf("Error sending campaigns: %v", err)
+			}
+		}
+	}
+}
+
+// SendCampaigns sends the campaigns due at now to their pending recipients,
+// through the limiter so the business number stays within its throughput.
+// The recipients left sending by a crash or a failed update are expired first,
+// so their campaigns can complete.
+func (c *Controller) SendCampaigns(now time.Time, stop <-chan struct{}) error {
+	if c.campaigns == nil {
+		return nil
+	}
+
+	expired, err := c.campaigns.ExpireClaims(now.Add(-campaignClaimTimeout))
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("Expired %d campaign recipients left sending, their templates may have been sent", expired)
+	}
+
+	due, err := c.campaigns.Due(now)
+	if err != nil {
+		return err
+	}
+
+	for _, scheduled := range due {
+		err = c.sendCampaign(scheduled, stop)
+		if err != nil {
+			return fmt.Errorf("campaign %s: %w", scheduled.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// sendCampaign sends the campaign batch by batch, until it is done, paused or cancelled
+func (c *Controller) sendCampaign(running campaign.Campaign, stop <-chan struct{}) error {
+	if running.Status == campaign.StatusScheduled {
+		_, err := c.campaigns.Transition(running.ID, campaign.ActionStart)
+		if err != nil {
+			return err
+		}
+		log.Printf("Started campaign %s %q", running.ID, running.Name)
+	}
+
+	for {
+		current, err := c.campaigns.Get(running.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != campaign.StatusRunning {
+			log.Printf("Campaign %s is %s, stopped sending", current.ID, current.Status)
+			return nil
+		}
+
+		pending, err := c.campaigns.Pending(running.ID, campaignBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 && current.Counts[campaign.RecipientSending] > 0 {
+			log.Printf("Campaign %s waits for %d recipients left sending to expire", current.ID, current.Counts[campaign.RecipientSending])
+			return nil
+		}
+		if len(pending) == 0 {
+			_, err = c.campaigns.Transition(running.ID, campaign.ActionComplete)
+			if err == nil {
+				log.Printf("Completed campaign %s %q", running.ID, running.Name)
+			}
+			return err
+		}
+
+		for _, recipient := range pending {
+			if !c.campaignLimiter.Wait(stop) {
+				return nil
+			}
+
+			// The campaign may be paused or cancelled while the batch is sent
+			claimed, err := c.campaigns.Claim(recipient)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				break
+			}
+
+			err = c.sendCampaignRecipient(current, recipient)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendCampaignRecipient sends the template of the campaign to the claimed recipient
+// and stores the outcome. A recipient hitting the rate limit is pending again for a retry.
+func (c *Controller) sendCampaignRecipient(running campaign.Campaign, recipient campaign.Recipient) error {
+	err := c.checkConsent(recipient.To, OutboundTypeTemplate)
+	if errors.Is(err, ErrOptedOut) {
+		recipient.Status = campaign.RecipientSkipped
+		recipient.Error = ErrOptedOut.Error()
+		return c.campaigns.UpdateRecipient(recipient)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.messagingClientManager.SendMessage(running.From, recipient.To, running.Template, running.TemplateLanguage, whatsapp.TemplateParameters(recipient.Parameters...))
+	var rejected *whatsapp.ResponseError
+	if errors.As(err, &rejected) && rejected.Code == errorCodeRateLimit {
+		log.Printf("Campaign %s hit the rate limit, backing off %v", running.ID, rateLimitBackoff)
+		c.campaignLimiter.Backoff(rateLimitBackoff)
+		recipient.Status = campaign.RecipientPending
+		return c.campaigns.UpdateRecipient(recipient)
+	}
+
+	now := time.Now()
+	recipient.SentAt = &now
+	recipient.Status = campaign.RecipientSent
+	if err == nil {
+		recipient.MessageID, err = providerMessageID(resp)
+	}
+	if err != nil {
+		recipient.Status = campaign.RecipientFailed
+		recipient.Error = err.Error()
+	}
+
+	return c.campaigns.UpdateRecipient(recipient)
+}
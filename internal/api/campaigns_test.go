@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/campaign"
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func newTestCampaigns(t *testing.T) *campaign.Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "campaigns.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := campaign.NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating campaign repository: %v", err)
+	}
+
+	return repository
+}
+
+func campaignRequest(api http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lab-key")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestCampaign_Send(t *testing.T) {
+	ledger := consent.NewMemoryLedger()
+	ledger.Record(consent.Entry{WaID: "994503981867", OptIn: contacts.OptInRevoked, At: time.Now()})
+	mc := &fakeMessagingClient{templateErrors: map[string]*whatsapp.ResponseError{
+		"994503981868": {Code: 131026, Message: "Message undeliverable"},
+	}}
+	campaigns := newTestCampaigns(t)
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithConsentLedger(ledger), WithCampaigns(campaigns, 1000))
+	api := NewAPI(c)
+
+	body := `{"name":"April results","template":{"name":"results_ready","language":"en"},"recipients":[
+		{"to":"994503981865","parameters":["T.A"]},
+		{"to":"994503981866","parameters":["R.M"]},
+		{"to":"994503981867","parameters":["S.K"]},
+		{"to":"994503981868","parameters":["N.H"]}]}`
+	rr := campaignRequest(api, http.MethodPost, "/api/v1/campaigns", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var created campaign.Campaign
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.Status != campaign.StatusScheduled || created.From != "15550909792" || created.Counts[campaign.RecipientPending] != 4 {
+		t.Fatalf("unexpected campaign %+v", created)
+	}
+
+	err := c.SendCampaigns(time.Now(), nil)
+	if err != nil {
+		t.Fatalf("error sending campaigns: %v", err)
+	}
+
+	if len(mc.templates) != 2 || mc.templates[0].to != "994503981865" || mc.templates[0].parameters[0] != "T.A" || mc.templates[1].parameters[0] != "R.M" {
+		t.Errorf("expected the template with the parameters of each recipient, got %+v", mc.templates)
+	}
+
+	rr = campaignRequest(api, http.MethodGet, "/api/v1/campaigns/"+created.ID, "")
+	var sent campaign.Campaign
+	json.NewDecoder(rr.Body).Decode(&sent)
+	if sent.Status != campaign.StatusCompleted || sent.Counts[campaign.RecipientSent] != 2 || sent.Counts[campaign.RecipientSkipped] != 1 || sent.Counts[campaign.RecipientFailed] != 1 {
+		t.Errorf("unexpected campaign after sending %+v", sent)
+	}
+
+	rr = campaignRequest(api, http.MethodGet, "/api/v1/campaigns/"+created.ID+"/recipients", "")
+	var recipients []CampaignRecipient
+	json.NewDecoder(rr.Body).Decode(&recipients)
+	if len(recipients) != 4 || recipients[0].MessageID != "wamid.template.1" || !strings.Contains(recipients[3].Error, "131026") {
+		t.Errorf("unexpected recipients %+v", recipients)
+	}
+}
+
+func TestCampaign_Control(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithCampaigns(newTestCampaigns(t), 1000))
+	api := NewAPI(c)
+
+	rr := campaignRequest(api, http.MethodPost, "/api/v1/campaigns", `{"name":"April results","template":{"name":"results_ready"},"recipients":[{"to":"994503981865"}]}`)
+	var created campaign.Campaign
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	rr = campaignRequest(api, http.MethodPost, "/api/v1/campaigns/"+created.ID+"/pause", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	c.SendCampaigns(time.Now(), nil)
+	if len(mc.templates) != 0 {
+		t.Errorf("expected a paused campaign not to be sent, got %+v", mc.templates)
+	}
+
+	rr = campaignRequest(api, http.MethodPost, "/api/v1/campaigns/"+created.ID+"/resume", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	c.SendCampaigns(time.Now(), nil)
+	if len(mc.templates) != 1 || mc.templates[0].text != "az" {
+		t.Errorf("expected the resumed campaign in the default language, got %+v", mc.templates)
+	}
+
+	rr = campaignRequest(api, http.MethodPost, "/api/v1/campaigns/"+created.ID+"/cancel", "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 cancelling a completed campaign, got %d", rr.Code)
+	}
+	rr = campaignRequest(api, http.MethodPost, "/api/v1/campaigns/unknown/cancel", "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+
+	rr = campaignRequest(api, http.MethodPost, "/api/v1/campaigns", `{"name":"May results","template":{"name":"results_ready","parameters":["T.A"]},"recipients":[{"to":"994503981865"}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for the parameters of the template, got %d", rr.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/campaigns/"+created.ID, nil)
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without an API key, got %d", rr.Code)
+	}
+}
+
+func TestCampaign_CancelMidBatch(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	campaigns := newTestCampaigns(t)
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithCampaigns(campaigns, 1000))
+	api := NewAPI(c)
+
+	rr := campaignRequest(api, http.MethodPost, "/api/v1/campaigns", `{"name":"April results","template":{"name":"results_ready"},"recipients":[
+		{"to":"994503981865"},{"to":"994503981866"},{"to":"994503981867"}]}`)
+	var created campaign.Campaign
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	// Staff cancels the campaign while the first batch is being sent
+	mc.templateSent = func(to string) {
+		if to == "994503981866" {
+			rr := campaignRequest(api, http.MethodPost, "/api/v1/campaigns/"+created.ID+"/cancel", "")
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected 200, got %d %s", rr.Code, rr.Body.String())
+			}
+		}
+	}
+
+	err := c.SendCampaigns(time.Now(), nil)
+	if err != nil {
+		t.Fatalf("error sending campaigns: %v", err)
+	}
+	if len(mc.templates) != 2 {
+		t.Errorf("expected no template after the cancel, got %+v", mc.templates)
+	}
+
+	cancelled, err := campaigns.Get(created.ID)
+	if err != nil {
+		t.Fatalf("error loading campaign: %v", err)
+	}
+	expected := map[string]int{campaign.RecipientSent: 2, campaign.RecipientCancelled: 1}
+	if cancelled.Status != campaign.StatusCancelled || !reflect.DeepEqual(cancelled.Counts, expected) {
+		t.Errorf("expected the sent recipients to stay sent, got %+v", cancelled)
+	}
+}
+
+func TestCampaign_ExpiredClaim(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	campaigns := newTestCampaigns(t)
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithCampaigns(campaigns, 1000))
+	api := NewAPI(c)
+
+	rr := campaignRequest(api, http.MethodPost, "/api/v1/campaigns", `{"name":"April results","template":{"name":"results_ready"},"recipients":[
+		{"to":"994503981865"},{"to":"994503981866"}]}`)
+	var created campaign.Campaign
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	// The process crashed while sending to the first recipient
+	_, err := campaigns.Transition(created.ID, campaign.ActionStart)
+	if err != nil {
+		t.Fatalf("error starting campaign: %v", err)
+	}
+	_, err = campaigns.Claim(campaign.Recipient{CampaignID: created.ID, To: "994503981865"})
+	if err != nil {
+		t.Fatalf("error claiming recipient: %v", err)
+	}
+
+	now := time.Now()
+	err = c.SendCampaigns(now, nil)
+	if err != nil {
+		t.Fatalf("error sending campaigns: %v", err)
+	}
+	running, _ := campaigns.Get(created.ID)
+	if running.Status != campaign.StatusRunning || running.Counts[campaign.RecipientSending] != 1 {
+		t.Errorf("expected the campaign to wait for the claim, got %+v", running)
+	}
+
+	err = c.SendCampaigns(now.Add(campaignClaimTimeout+time.Minute), nil)
+	if err != nil {
+		t.Fatalf("error sending campaigns: %v", err)
+	}
+	completed, _ := campaigns.Get(created.ID)
+	expected := map[string]int{campaign.RecipientSent: 1, campaign.RecipientUnknown: 1}
+	if completed.Status != campaign.StatusCompleted || !reflect.DeepEqual(completed.Counts, expected) {
+		t.Errorf("expected the claim to expire and the campaign to complete, got %+v", completed)
+	}
+	if len(mc.templates) != 1 || mc.templates[0].to != "994503981866" {
+		t.Errorf("expected the template to be sent to the other recipient only, got %+v", mc.templates)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+)
+
+// ReceiveUpdate receives the webhook requests of the channels added with WithChannel.
+// The contacts of the channel are answered by the same flows as the WhatsApp contacts.
+func (c *Controller) ReceiveUpdate(w http.ResponseWriter, r *http.Request) {
+	c.receiveUpdate(w, r, mux.Vars(r)["channel"])
+}
+
+// receiveUpdate parses the webhook request with the provider of the channel
+func (c *Controller) receiveUpdate(w http.ResponseWriter, r *http.Request, name string) {
+	provider, err := c.channels.Get(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	messages, err := provider.ParseUpdate(r)
+	if errors.Is(err, channel.ErrUnauthorized) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The providers retry failed requests, the messages which can't be
+	// answered are only logged so they aren't answered twice
+	for _, message := range messages {
+		err = c.receive(message, c.dispatchEvent)
+		if err != nil {
+			log.Printf("Error handling %s message %s: %v", provider.Name(), message.MessageID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// receive logs the inbound message of any channel, updates the contact of
+// the sender and passes the event of the message to the handler
+func (c *Controller) receive(message channel.InboundMessage, handle func(event conversation.Event) error) error {
+	event := inboundEvent(message)
+	log.Printf("New %s Message; sender:%s name:%s type:%s", message.Channel, event.ContactID, message.ContactName, message.Type)
+
+	c.logInbound(messagelog.InboundRecord{
+		WaMID:     inboundID(message),
+		From:      event.ContactID,
+		To:        event.Recipient,
+		Type:      message.Type,
+		Body:      message.Text,
+		MediaRef:  message.MediaRef(),
+		Timestamp: event.Time,
+	})
+
+	_, err := c.contacts.Touch(event.ContactID, message.ContactName, event.Time)
+	if err != nil {
+		log.Printf("Error updating contact %s: %v", event.ContactID, err)
+	}
+
+	if message.Phone != "" {
+		err = c.contacts.SetPhone(event.ContactID, message.Phone)
+		if err != nil {
+			return err
+		}
+		log.Printf("Contact %s shared phone number %s", event.ContactID, message.Phone)
+	}
+
+	err = handle(event)
+	if errors.Is(err, ErrOptedOut) {
+		// The contact opted out, the answer is dropped on purpose
+		log.Printf("Not answering message %s: %v", event.MessageID, err)
+		return nil
+	}
+
+	return err
+}
+
+// dispatchEvent passes the event to the conversation of the sender
+func (c *Controller) dispatchEvent(event conversation.Event) error {
+	_, err := c.conversations.Dispatch(event)
+	return err
+}
+
+// inboundEvent returns the event of the message for the conversation flows.
+// The flows answer the WhatsApp contacts from the business number the
+// message was sent to, the contacts of the other channels from the channel.
+func inboundEvent(message channel.InboundMessage) conversation.Event {
+	recipient := message.Recipient
+	if message.Channel != channel.WhatsApp {
+		recipient = message.Channel
+	}
+
+	timestamp := message.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return conversation.Event{
+		ContactID: message.ContactID(),
+		Name:      message.ContactName,
+		Recipient: recipient,
+		MessageID: message.MessageID,
+		Type:      message.Type,
+		Text:      message.Text,
+		Payload:   message.Payload,
+		ReplyTo:   message.ReplyTo,
+		Phone:     message.Phone,
+		Time:      timestamp,
+	}
+}
+
+// inboundID returns the ID the message is logged by, the message IDs of
+// the other channels are only unique in the chat
+func inboundID(message channel.InboundMessage) string {
+	if message.Channel == channel.WhatsApp {
+		return message.MessageID
+	}
+
+	return message.ContactID() + ":" + message.MessageID
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/messenger"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/telegram"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/viber"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+)
+
+// metaSignature returns the signature header of a Meta webhook body
+func metaSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestReceiveUpdate_Telegram(t *testing.T) {
+	var sent []telegram.SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload telegram.SendMessageRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload)
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":43}}`)
+	}))
+	defer server.Close()
+
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}), WithChannel(telegram.NewClient("123:abc", "secret", server.URL+"/")))
+	api := NewAPI(c)
+
+	update := `{"update_id":1,"message":{"message_id":42,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"text":"help"}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(update))
+	r.Header.Set(telegram.SecretTokenHeader, "secret")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if len(sent) != 1 || sent[0].ChatID != "987654321" || sent[0].ReplyToMessageID != 42 || !strings.Contains(sent[0].Text, "Tabriz") {
+		t.Errorf("expected the help text on telegram, got %+v", sent)
+	}
+	if len(mc.texts) != 0 {
+		t.Errorf("expected nothing on whatsapp, got %+v", mc.texts)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(update))
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the secret token, got %d", rr.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/channels/fax/hook", strings.NewReader(update))
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown channel, got %d", rr.Code)
+	}
+}
+
+func TestReceiveUpdate_ViberKeyboard(t *testing.T) {
+	var sent []viber.SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload viber.SendMessageRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload)
+		fmt.Fprint(w, `{"status":0,"status_message":"ok"}`)
+	}))
+	defer server.Close()
+
+	store := contacts.NewMemoryStore()
+	c := NewController(&fakeMessagingClient{}, WithContactStore(store), WithChannel(viber.NewClient("token", "Lab", server.URL+"/")))
+	api := NewAPI(c)
+
+	post := func(body string) {
+		mac := hmac.New(sha256.New, []byte("token"))
+		mac.Write([]byte(body))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/viber/hook", strings.NewReader(body))
+		r.Header.Set(viber.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	post(`{"event":"message","timestamp":1681899808000,"message_token":1,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"text","text":"language"}}`)
+	if len(sent) != 1 || sent[0].Keyboard == nil || len(sent[0].Keyboard.Buttons) != 4 {
+		t.Fatalf("expected the languages as a keyboard, got %+v", sent)
+	}
+
+	post(`{"event":"message","timestamp":1681899809000,"message_token":2,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"text","text":"language:ru","tracking_data":"keyboard"}}`)
+	contact, _, _ := store.Get("viber:01234567890A=")
+	if contact.Language != "ru" {
+		t.Errorf("expected the language of the viber contact to be ru, got %+v", contact)
+	}
+}
+
+func TestReceiveMessage_Messenger(t *testing.T) {
+	var sent []messenger.SendRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload messenger.SendRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload)
+		fmt.Fprint(w, `{"recipient_id":"6054128371239","message_id":"m_2"}`)
+	}))
+	defer server.Close()
+
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}), WithChannel(messenger.NewClient(messenger.ChannelMessenger, "token", "secret", server.URL+"/")))
+	api := NewAPI(c)
+
+	webhook := fmt.Sprintf(`{"object":"page","entry":[{"id":"1029384756","time":%[1]d000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":%[1]d000,"message":{"mid":"m_1","text":"help"}}]}]}`, testTimestamp)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader(webhook))
+	r.Header.Set(messenger.SignatureHeader, metaSignature("secret", webhook))
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if len(sent) != 1 || sent[0].Recipient.ID != "6054128371239" || sent[0].Message.Text == "" {
+		t.Errorf("expected the help text on messenger, got %+v", sent)
+	}
+	if len(mc.texts) != 0 {
+		t.Errorf("expected nothing on whatsapp, got %+v", mc.texts)
+	}
+	if _, ok, _ := c.contacts.Get("messenger:6054128371239"); !ok {
+		t.Errorf("expected the messenger contact")
+	}
+
+	webhook = strings.Replace(webhook, `"page"`, `"instagram"`, 1)
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader(webhook))
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without an instagram channel, got %d", rr.Code)
+	}
+}
+
+func TestReceiveUpdate_MessageLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":43}}`)
+	}))
+	defer server.Close()
+
+	ml := newTestMessageLog(t)
+	c := NewController(&fakeMessagingClient{}, WithResultLookup(results.Stub{}), WithMessageLog(ml), WithChannel(telegram.NewClient("123:abc", "secret", server.URL+"/")))
+	api := NewAPI(c)
+
+	update := fmt.Sprintf(`{"update_id":1,"message":{"message_id":42,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":%d,"photo":[{"file_id":"f1"}],"caption":"my result"}}`, testTimestamp)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(update))
+	r.Header.Set(telegram.SecretTokenHeader, "secret")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	records, err := ml.Inbound("telegram:987654321")
+	if err != nil {
+		t.Fatalf("error loading inbound messages: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected the message in the log, got %+v", records)
+	}
+	record := records[0]
+	if record.WaMID != "telegram:987654321:42" || record.To != "telegram" || record.Type != "image" || record.Body != "my result" || record.MediaRef != "f1" {
+		t.Errorf("unexpected inbound record %+v", record)
+	}
+}
+
+func TestReceiveUpdate_TelegramResults(t *testing.T) {
+	var methods []string
+	var phoneRequest telegram.SendMessageRequest
+	var document telegram.SendDocumentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		methods = append(methods, method)
+		if method == "sendDocument" {
+			json.NewDecoder(r.Body).Decode(&document)
+		} else {
+			json.NewDecoder(r.Body).Decode(&phoneRequest)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":43}}`)
+	}))
+	defer server.Close()
+
+	lookup := results.Stub{Results: map[string]results.Result{
+		"994503981865": {Ready: true, DocumentURL: "https://example.com/api/v1/994503981865/document"},
+	}}
+	c := NewController(&fakeMessagingClient{}, WithResultLookup(lookup), WithChannel(telegram.NewClient("123:abc", "secret", server.URL+"/")))
+	api := NewAPI(c)
+
+	post := func(update string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(update))
+		r.Header.Set(telegram.SecretTokenHeader, "secret")
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	post(`{"update_id":1,"message":{"message_id":42,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"text":"result"}}`)
+	if len(methods) != 1 || phoneRequest.ReplyMarkup == nil || !phoneRequest.ReplyMarkup.Keyboard[0][0].RequestContact {
+		t.Fatalf("expected the phone request, got %v %+v", methods, phoneRequest)
+	}
+
+	// A contact card of someone else is not linked
+	post(`{"update_id":2,"message":{"message_id":43,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899809,"contact":{"phone_number":"+994503981865","first_name":"Someone","user_id":123456789}}}`)
+	if contact, _, _ := c.contacts.Get("telegram:987654321"); contact.Phone != "" {
+		t.Fatalf("expected the phone of someone else not to be linked, got %+v", contact)
+	}
+
+	post(`{"update_id":3,"message":{"message_id":44,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899810,"contact":{"phone_number":"+994503981865","first_name":"Tabriz","user_id":987654321}}}`)
+	if methods[len(methods)-1] != "sendDocument" || document.ChatID != "987654321" || document.Document != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("expected the results on telegram, got %v %+v", methods, document)
+	}
+}
+
+func TestReceiveUpdate_ViberResults(t *testing.T) {
+	var sent []viber.SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload viber.SendMessageRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload)
+		fmt.Fprint(w, `{"status":0,"status_message":"ok"}`)
+	}))
+	defer server.Close()
+
+	lookup := results.Stub{Results: map[string]results.Result{
+		"994503981865": {Ready: true, DocumentURL: server.URL + "/api/v1/994503981865/document"},
+	}}
+	c := NewController(&fakeMessagingClient{}, WithResultLookup(lookup), WithChannel(viber.NewClient("token", "Lab", server.URL+"/")))
+	api := NewAPI(c)
+
+	post := func(body string) {
+		mac := hmac.New(sha256.New, []byte("token"))
+		mac.Write([]byte(body))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/viber/hook", strings.NewReader(body))
+		r.Header.Set(viber.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// Viber can't tell whose number a shared contact is, the contact is sent to WhatsApp
+	post(`{"event":"message","timestamp":1681899808000,"message_token":1,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"text","text":"result"}}`)
+	if len(sent) != 1 || sent[0].Keyboard != nil || !strings.Contains(sent[0].Text, "WhatsApp") {
+		t.Fatalf("expected the phone unavailable text on viber, got %+v", sent)
+	}
+
+	post(`{"event":"message","timestamp":1681899809000,"message_token":2,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"contact","contact":{"name":"T.A","phone_number":"+994503981865"},"tracking_data":"share_phone"}}`)
+	if contact, _, _ := c.contacts.Get("viber:01234567890A="); contact.Phone != "" {
+		t.Errorf("expected the phone of the contact card not to be linked, got %+v", contact)
+	}
+	for _, message := range sent {
+		if message.Type == "file" {
+			t.Errorf("expected no results on viber, got %+v", sent)
+		}
+	}
+}
+
+func TestReceiveMessage_MessengerResults(t *testing.T) {
+	var sent []messenger.SendRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload messenger.SendRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload)
+		fmt.Fprint(w, `{"recipient_id":"6054128371239","message_id":"m_2"}`)
+	}))
+	defer server.Close()
+
+	lookup := results.Stub{Results: map[string]results.Result{
+		"994503981865": {Ready: true, DocumentURL: "https://example.com/api/v1/994503981865/document"},
+	}}
+	c := NewController(&fakeMessagingClient{}, WithResultLookup(lookup),
+		WithChannel(messenger.NewClient(messenger.ChannelMessenger, "token", "secret", server.URL+"/")),
+		WithChannel(messenger.NewClient(messenger.ChannelInstagram, "token", "secret", server.URL+"/")))
+	api := NewAPI(c)
+
+	post := func(object, message string) {
+		webhook := fmt.Sprintf(`{"object":%q,"entry":[{"id":"1029384756","time":%[2]d000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":%[2]d000,"message":%s}]}]}`, object, testTimestamp, message)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader(webhook))
+		r.Header.Set(messenger.SignatureHeader, metaSignature("secret", webhook))
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	post("page", `{"mid":"m_1","text":"result"}`)
+	if len(sent) != 1 || len(sent[0].Message.QuickReplies) != 1 || sent[0].Message.QuickReplies[0].ContentType != "user_phone_number" {
+		t.Fatalf("expected the phone request, got %+v", sent)
+	}
+
+	post("page", `{"mid":"m_2","text":"+994503981865","quick_reply":{"payload":"+994503981865"}}`)
+	if len(sent) != 3 || sent[1].Message.Attachment == nil || sent[1].Message.Attachment.Payload.URL != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("expected the results on messenger, got %+v", sent)
+	}
+
+	// Instagram can't share the phone number, the contact is sent to WhatsApp
+	sent = nil
+	post("instagram", `{"mid":"m_3","text":"result"}`)
+	if len(sent) != 1 || len(sent[0].Message.QuickReplies) != 0 || !strings.Contains(sent[0].Message.Text, "WhatsApp") {
+		t.Errorf("expected the phone unavailable text on instagram, got %+v", sent)
+	}
+}
@@ -3,30 +3,338 @@ package api
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/alert"
+	"github.com/tebrizetayi/messaging-integration-service/internal/billing"
+	"github.com/tebrizetayi/messaging-integration-service/internal/campaign"
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/handoff"
+	"github.com/tebrizetayi/messaging-integration-service/internal/idempotency"
+	"github.com/tebrizetayi/messaging-integration-service/internal/intent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/messenger"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+	"github.com/tebrizetayi/messaging-integration-service/internal/schedule"
 )
 
 type MessagingClientManager interface {
-	SendDocument(from, document, recipientID, caption string, link bool) (map[string]interface{}, error)
-	SendMessageText(from, message, recipientID string) (map[string]interface{}, error)
+	SendMessage(from, to, templateName, languageCode string, opts ...whatsapp.SendOption) (whatsapp.SendMessageResponse, error)
+	SendDocument(from, document, recipientID, caption string, link bool, opts ...whatsapp.SendOption) (map[string]interface{}, error)
+	SendMessageText(from, message, recipientID string, opts ...whatsapp.SendOption) (map[string]interface{}, error)
+	SendInteractiveList(from, recipientID, body, button string, sections []whatsapp.ListSection, opts ...whatsapp.SendOption) (map[string]interface{}, error)
+	SendLocation(from, recipientID string, location whatsapp.Location, opts ...whatsapp.SendOption) (map[string]interface{}, error)
+	SendContacts(from, recipientID string, contacts []whatsapp.Contact, opts ...whatsapp.SendOption) (map[string]interface{}, error)
+	SendReaction(from, recipientID, messageID, emoji string) (map[string]interface{}, error)
+	MarkAsRead(from, messageID string) error
+	SendTypingIndicator(from, messageID string) error
+}
+
+// MessageLog stores the messages received from the contacts
+// and tracks the delivery of the messages sent to them
+type MessageLog interface {
+	SaveInbound(record messagelog.InboundRecord) error
+	UpdateStatus(update messagelog.StatusUpdate) error
+	OutboundTo(recipient, messageType string) ([]messagelog.OutboundRecord, error)
+	Outbound(providerID string) (messagelog.OutboundRecord, error)
+	Undelivered(after, before time.Time) ([]messagelog.OutboundRecord, error)
+	MarkFallback(providerID string, at time.Time) (bool, error)
+	SetReplaced(providerID, messageType, body string) error
+}
+
+// Billing stores the conversations Meta charges for
+type Billing interface {
+	RecordConversation(conversation billing.Conversation) error
+	Report(query billing.ReportQuery) ([]billing.ReportRow, error)
+}
+
+// EmailSender sends the results by email
+type EmailSender interface {
+	Send(message email.Message) error
+}
+
+// CampaignStore keeps the campaigns and the status of their recipients
+type CampaignStore interface {
+	Create(scheduled campaign.Campaign, recipients []campaign.Recipient) (campaign.Campaign, error)
+	Get(id string) (campaign.Campaign, error)
+	Due(now time.Time) ([]campaign.Campaign, error)
+	Recipients(id string) ([]campaign.Recipient, error)
+	Pending(id string, limit int) ([]campaign.Recipient, error)
+	Claim(recipient campaign.Recipient) (bool, error)
+	UpdateRecipient(recipient campaign.Recipient) error
+	ExpireClaims(before time.Time) (int64, error)
+	Transition(id, action string) (campaign.Campaign, error)
+}
+
+// Scheduler keeps the messages to send at a later time
+type Scheduler interface {
+	Create(job schedule.Job) (schedule.Job, error)
+	Get(id string) (schedule.Job, error)
+	Due(now time.Time, limit int) ([]schedule.Job, error)
+	List(status string, limit int) ([]schedule.Job, error)
+	Claim(id string, now time.Time) (bool, error)
+	ExpireClaims(before time.Time) (int64, error)
+	Finish(id, status, messageID, errorText string, at time.Time) error
+	Cancel(id string) (schedule.Job, error)
+	Reschedule(id string, sendAt time.Time) (schedule.Job, error)
+}
+
+// ResultLookup tells whether the analysis results of a number are ready
+type ResultLookup interface {
+	Lookup(number string) (results.Result, error)
 }
 
 // Controller is the API controller
 type Controller struct {
 	messagingClientManager MessagingClientManager
+	resultLookup           ResultLookup
+	conversationStore      conversation.Store
+	conversations          *conversation.Machine
+	intents                *intent.Router
+	catalog                *catalog.Catalog
+	contacts               contacts.Store
+	consents               consent.Ledger
+	handoffs               handoff.Store
+	apiKeys                []string
+	messageLog             MessageLog
+	billing                Billing
+	notifier               alert.Notifier
+	messageHandlers        map[string]MessageHandler
+	windowTemplate         string
+	windowTemplateLanguage string
+	channels               *channel.Registry
+	fallback               channel.Provider
+	fallbackTimeout        time.Duration
+	email                  EmailSender
+	emailing               *sync.WaitGroup
+	idempotency            idempotency.Store
+	businessNumbers        []string
+	campaigns              CampaignStore
+	campaignLimiter        *campaign.Limiter
+	schedule               Scheduler
+}
+
+// Option configures the Controller
+type Option func(*Controller)
+
+// WithResultLookup sets the source used to check whether the results are ready.
+// The documents uploaded to this service are used by default.
+func WithResultLookup(rl ResultLookup) Option {
+	return func(c *Controller) {
+		c.resultLookup = rl
+	}
 }
 
-func NewController(mc MessagingClientManager) Controller {
-	return Controller{
+// WithConversationStore sets the store of the conversation sessions.
+// The sessions are kept in memory by default.
+func WithConversationStore(store conversation.Store) Option {
+	return func(c *Controller) {
+		c.conversationStore = store
+	}
+}
+
+// WithIntentRouter sets the router matching the text of the inbound messages.
+// The router matches intent.DefaultRules by default.
+func WithIntentRouter(router *intent.Router) Option {
+	return func(c *Controller) {
+		c.intents = router
+	}
+}
+
+// WithCatalog sets the catalog of the reply texts.
+// The catalog shipped with the service is used by default.
+func WithCatalog(catalog *catalog.Catalog) Option {
+	return func(c *Controller) {
+		c.catalog = catalog
+	}
+}
+
+// WithContactStore sets the store of the contacts.
+// The contacts are kept in memory by default.
+func WithContactStore(store contacts.Store) Option {
+	return func(c *Controller) {
+		c.contacts = store
+	}
+}
+
+// WithConsentLedger sets the ledger of the consents checked before sending.
+// The consents are kept in memory by default.
+func WithConsentLedger(ledger consent.Ledger) Option {
+	return func(c *Controller) {
+		c.consents = ledger
+	}
+}
+
+// WithHandoffStore sets the store of the conversations handed off to staff.
+// The handoffs are kept in memory by default.
+func WithHandoffStore(store handoff.Store) Option {
+	return func(c *Controller) {
+		c.handoffs = store
+	}
+}
+
+// WithAPIKeys sets the keys the staff and the internal systems send messages with
+func WithAPIKeys(keys ...string) Option {
+	return func(c *Controller) {
+		c.apiKeys = keys
+	}
+}
+
+// WithMessageLog sets the log of the inbound messages, nothing is logged by default
+func WithMessageLog(ml MessageLog) Option {
+	return func(c *Controller) {
+		c.messageLog = ml
+	}
+}
+
+// WithBilling sets the store of the charged conversations, nothing is stored by default
+func WithBilling(b Billing) Option {
+	return func(c *Controller) {
+		c.billing = b
+	}
+}
+
+// WithNotifier sets the notifier of the delivery failures.
+// The alerts are only logged by default.
+func WithNotifier(n alert.Notifier) Option {
+	return func(c *Controller) {
+		c.notifier = n
+	}
+}
+
+// WithWindowTemplate sets the template sent instead of the messages to contacts
+// whose customer service window is closed. The language is the language code of
+// the template, the language of the contact is used if it is empty. The messages
+// fail with ErrWindowClosed either way, since they weren't sent.
+func WithWindowTemplate(name, language string) Option {
+	return func(c *Controller) {
+		c.windowTemplate = name
+		c.windowTemplateLanguage = language
+	}
+}
+
+// WithChannel adds a channel the contacts can write on besides WhatsApp.
+// The updates of the channel are posted to /api/v1/channels/{name}/hook.
+func WithChannel(provider channel.Provider) Option {
+	return func(c *Controller) {
+		c.channels.Register(provider)
+	}
+}
+
+// WithFallback sets the channel the WhatsApp messages are sent again on when the
+// contact doesn't use WhatsApp or the message isn't delivered within the timeout.
+// The fallback needs the message log, nothing is sent again by default.
+func WithFallback(provider channel.Provider, timeout time.Duration) Option {
+	return func(c *Controller) {
+		c.channels.Register(provider)
+		c.fallback = provider
+		c.fallbackTimeout = timeout
+	}
+}
+
+// WithEmail sets the sender of the results to the contacts who registered
+// an email address, no emails are sent by default
+func WithEmail(sender EmailSender) Option {
+	return func(c *Controller) {
+		c.email = sender
+	}
+}
+
+// WithIdempotencyStore sets the store of the idempotency keys of the sent messages
+func WithIdempotencyStore(store idempotency.Store) Option {
+	return func(c *Controller) {
+		c.idempotency = store
+	}
+}
+
+// WithBusinessNumbers sets the numbers the messages of the API may be sent from,
+// the first number is used when the request doesn't name one
+func WithBusinessNumbers(numbers ...string) Option {
+	return func(c *Controller) {
+		c.businessNumbers = numbers
+	}
+}
+
+// WithCampaigns sets the store of the campaigns, which are sent at rate messages per second
+func WithCampaigns(store CampaignStore, rate int) Option {
+	return func(c *Controller) {
+		c.campaigns = store
+		c.campaignLimiter = campaign.NewLimiter(rate)
+	}
+}
+
+// WithScheduler sets the store of the scheduled messages
+func WithScheduler(scheduler Scheduler) Option {
+	return func(c *Controller) {
+		c.schedule = scheduler
+	}
+}
+
+// WithMessageHandler sets the handler of the inbound messages of a type, e.g.
+// MessageTypeLocation. The texts, buttons, list replies and the media are
+// answered by the conversation flows by default and the types the bot doesn't
+// understand are answered with the unsupported message text. The messages are
+// marked as read before the handler runs.
+func WithMessageHandler(messageType string, h MessageHandler) Option {
+	return func(c *Controller) {
+		c.messageHandlers[messageType] = c.readMessage(h)
+	}
+}
+
+func NewController(mc MessagingClientManager, opts ...Option) Controller {
+	intents, _ := intent.NewRouter(intent.DefaultRules())
+	messages, _ := catalog.Default("az")
+	c := Controller{
 		messagingClientManager: mc,
+		resultLookup:           results.NotConfigured{},
+		conversationStore:      conversation.NewMemoryStore(),
+		intents:                intents,
+		catalog:                messages,
+		contacts:               contacts.NewMemoryStore(),
+		consents:               consent.NewMemoryLedger(),
+		handoffs:               handoff.NewMemoryStore(),
+		notifier:               alert.LogNotifier{},
+		messageHandlers:        map[string]MessageHandler{},
+		channels:               channel.NewRegistry(),
+		idempotency:            idempotency.NewMemoryStore(),
+		emailing:               &sync.WaitGroup{},
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	c.conversations = conversation.NewMachine(c.conversationStore, conversation.StateIdle)
+	c.registerFlows()
+
+	return c
+}
+
+// respondJSON writes the value as the JSON body of the response
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error writing response: %v", err)
 	}
 }
 
@@ -49,56 +357,121 @@ func (c *Controller) parsingMessage(message []byte) error {
 	changedField := md.ChangedField()
 
 	if changedField == "messages" {
+		err = md.Preprocess(data)
+		if err != nil {
+			log.Printf("Error preprocessing message: %v", err)
+			return err
+		}
+
+		c.reportWebhookErrors(md)
+
 		newMessage := md.IsMessage()
 		if newMessage {
-			mobile, _ := md.GetMobile()
 			name, _ := md.GetName()
-			messageType, _ := md.GetMessageType()
 			businessNumber, _ := md.GetBusinessNumber()
 
-			log.Printf("New Message; sender:%s name:%s type:%s", mobile, name, messageType)
-			url := fmt.Sprintf("https://whatsapp-businessapi.herokuapp.com/api/v1/%s/document", mobile)
-			req, err := http.NewRequest("GET", url, nil)
+			message, err := md.GetMessage()
 			if err != nil {
+				log.Printf("Error parsing message: %v", err)
 				return err
 			}
 
-			_ = businessNumber
+			handler, ok := c.messageHandlers[message.Type]
+			if !ok {
+				handler = c.messageHandlers[MessageTypeUnsupported]
+			}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
+			// The message was received, Meta would deliver it again if a failed
+			// answer made the webhook fail and the contact would be answered twice
+			err = c.receive(message.Inbound(businessNumber, name), func(event conversation.Event) error {
+				return handler(message, event)
+			})
 			if err != nil {
-				return err
+				log.Printf("Error handling %s message %s: %v", message.Type, message.ID, err)
 			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				msg := fmt.Sprintf("Hormetli %s.Analiz neticeleriniz hazir degildir", name)
-				_, err = c.messagingClientManager.SendMessageText(businessNumber, msg, mobile)
-				if err != nil {
-					log.Fatalf("Error: %v", err)
-				}
-			} else {
-				caption := fmt.Sprintf("Hormetli %s. Analiz neticeleriniz hazirdir", name)
-				_, err = c.messagingClientManager.SendDocument(businessNumber, url, mobile, caption, true)
-				if err != nil {
-					log.Fatalf("Error: %v", err)
-				}
-			}
-
 		} else {
-			delivery, _ := md.GetDelivery()
-			if delivery != nil {
-				log.Printf("Message : %v", delivery)
-			} else {
-				log.Println("No new message")
-			}
+			c.updateStatuses(md)
 		}
 	}
 
 	return nil
 }
 
+// markAsRead marks the message as read, failures are logged as the message can still be answered
+func (c *Controller) markAsRead(businessNumber, messageID string) {
+	err := c.messagingClientManager.MarkAsRead(businessNumber, messageID)
+	if err != nil {
+		log.Printf("Error marking message %s as read: %v", messageID, err)
+	}
+}
+
+// logInbound stores the inbound message, failures are logged as the message can still be answered
+func (c *Controller) logInbound(record messagelog.InboundRecord) {
+	if c.messageLog == nil {
+		return
+	}
+
+	err := c.messageLog.SaveInbound(record)
+	if err != nil {
+		log.Printf("Error saving inbound message %s: %v", record.WaMID, err)
+	}
+}
+
+// updateStatuses tracks the delivery of the outbound messages
+func (c *Controller) updateStatuses(md *MessengerData) {
+	statuses, err := md.GetStatuses()
+	if err != nil {
+		log.Println("No new message")
+		return
+	}
+
+	businessNumber, _ := md.GetBusinessNumber()
+	for _, status := range statuses {
+		log.Printf("Message %s to %s : %s", status.ID, status.RecipientID, status.Status)
+		c.recordConversation(businessNumber, status)
+		c.reportStatusErrors(businessNumber, status)
+
+		if c.messageLog == nil {
+			continue
+		}
+
+		err = c.messageLog.UpdateStatus(messagelog.StatusUpdate{
+			ProviderID: status.ID,
+			Status:     status.Status,
+			Timestamp:  status.Timestamp,
+			Error:      joinErrors(status.Errors),
+		})
+		if err != nil {
+			log.Printf("Error updating status of message %s: %v", status.ID, err)
+		}
+
+		if status.Status == messagelog.StatusFailed && hasErrorCode(status.Errors, errorCodeUndeliverable) {
+			c.fallBackFailed(status.ID)
+		}
+	}
+}
+
+// recordConversation stores the pricing of the conversation the status belongs to
+func (c *Controller) recordConversation(businessNumber string, status Status) {
+	if c.billing == nil || status.ConversationID == "" {
+		return
+	}
+
+	err := c.billing.RecordConversation(billing.Conversation{
+		ID:             status.ConversationID,
+		BusinessNumber: businessNumber,
+		Recipient:      status.RecipientID,
+		OriginType:     status.OriginType,
+		Category:       status.Category,
+		PricingModel:   status.PricingModel,
+		Billable:       status.Billable,
+		StartedAt:      status.Timestamp,
+	})
+	if err != nil {
+		log.Printf("Error recording conversation %s: %v", status.ConversationID, err)
+	}
+}
+
 func (c *Controller) ReceiveMessage(w http.ResponseWriter, r *http.Request) {
 
 	bytes, err := ioutil.ReadAll(r.Body)
@@ -107,6 +480,14 @@ func (c *Controller) ReceiveMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Println(string(bytes))
+
+	// The Messenger and Instagram conversations of the page are received on the same webhook
+	if name, ok := messenger.Objects[webhookObject(bytes)]; ok {
+		r.Body = ioutil.NopCloser(strings.NewReader(string(bytes)))
+		c.receiveUpdate(w, r, name)
+		return
+	}
+
 	err = c.parsingMessage(bytes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -118,6 +499,16 @@ func (c *Controller) ReceiveMessage(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// webhookObject returns the object of the webhook request, the product it comes from
+func webhookObject(data []byte) string {
+	var webhook struct {
+		Object string `json:"object"`
+	}
+	json.Unmarshal(data, &webhook)
+
+	return webhook.Object
+}
+
 func (c *Controller) VerifyToken(w http.ResponseWriter, r *http.Request) {
 	verifyToken := r.URL.Query().Get("hub.verify_token")
 	challenge := r.URL.Query().Get("hub.challenge")
@@ -145,8 +536,14 @@ func (c *Controller) VerifyToken(w http.ResponseWriter, r *http.Request) {
 type RequestData struct {
 	Number   string `json:"number"`
 	Document string `json:"document"`
+	// Email registers the address the results of the number are emailed to
+	Email string `json:"email,omitempty"`
+	// ReplaceEmail replaces another registered address with Email
+	ReplaceEmail bool `json:"replace_email,omitempty"`
 }
 
+// UploadDocument saves the results document of the number, then registers the
+// email address of the request and emails the document in the background
 func (c *Controller) UploadDocument(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON data
 	var requestData RequestData
@@ -163,6 +560,21 @@ func (c *Controller) UploadDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Registering an address decides where the results go, only the lab system may do it
+	var address *mail.Address
+	if requestData.Email != "" {
+		if !c.authorized(r) {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		address, err = mail.ParseAddress(requestData.Email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid email address: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Save the PDF to disk with the filename as the number
 	filename := fmt.Sprintf("%s.pdf", requestData.Number)
 	err = ioutil.WriteFile(filename, pdfData, 0644)
@@ -171,10 +583,42 @@ func (c *Controller) UploadDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if address != nil {
+		if requestData.ReplaceEmail {
+			err = c.contacts.ReplaceEmail(requestData.Number, address.Address)
+		} else {
+			err = c.contacts.SetEmail(requestData.Number, address.Address)
+		}
+		if errors.Is(err, contacts.ErrEmailRegistered) {
+			http.Error(w, fmt.Sprintf("document saved, not emailed: %v, set replace_email to replace it", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The document is saved, a failed email doesn't fail the upload
+	c.emailing.Add(1)
+	go func() {
+		defer c.emailing.Done()
+
+		err := c.emailResults(requestData.Number, filename, pdfData)
+		if err != nil {
+			log.Printf("Error emailing results of %s: %v", requestData.Number, err)
+		}
+	}()
+
 	// Send a success response
 	w.WriteHeader(http.StatusOK)
 }
 
+// WaitEmails waits for the results being emailed in the background
+func (c *Controller) WaitEmails() {
+	c.emailing.Wait()
+}
+
 func (c *Controller) GetDocument(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	number := vars["number"]
@@ -1,20 +1,138 @@
 package api
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
 )
 
-func TestParseMessage_Success(t *testing.T) {
-	c := NewController(nil)
-	data := []byte(`{
+type sentMessage struct {
+	from, to, text, document string
+	replyTo                  string
+	parameters               []string
+}
+
+type fakeMessagingClient struct {
+	texts     []sentMessage
+	documents []sentMessage
+	lists     [][]whatsapp.ListSection
+	locations []whatsapp.Location
+	contacts  [][]whatsapp.Contact
+	reactions []sentMessage
+	read      []string
+	typing    []string
+	templates []sentMessage
+	// templateErrors are returned for the templates to the numbers
+	templateErrors map[string]*whatsapp.ResponseError
+	// templateSent is called after a template is sent
+	templateSent func(to string)
+	// textError is returned for the texts
+	textError error
+}
+
+func (f *fakeMessagingClient) SendMessage(from, to, templateName, languageCode string, opts ...whatsapp.SendOption) (whatsapp.SendMessageResponse, error) {
+	if err, ok := f.templateErrors[to]; ok {
+		return whatsapp.SendMessageResponse{}, err
+	}
+
+	f.templates = append(f.templates, sentMessage{from: from, to: to, text: languageCode, document: templateName, parameters: whatsapp.NewSendOptions(opts...).Parameters})
+	if f.templateSent != nil {
+		f.templateSent(to)
+	}
+	var resp whatsapp.SendMessageResponse
+	resp.Messages = append(resp.Messages, struct {
+		ID string `json:"id"`
+	}{ID: fmt.Sprintf("wamid.template.%d", len(f.templates))})
+	return resp, nil
+}
+
+func (f *fakeMessagingClient) SendDocument(from, document, recipientID, caption string, link bool, opts ...whatsapp.SendOption) (map[string]interface{}, error) {
+	f.documents = append(f.documents, sentMessage{from: from, to: recipientID, text: caption, document: document, replyTo: quotedMessage(opts)})
+	return sentResponse(fmt.Sprintf("wamid.document.%d", len(f.documents))), nil
+}
+
+func (f *fakeMessagingClient) SendMessageText(from, message, recipientID string, opts ...whatsapp.SendOption) (map[string]interface{}, error) {
+	if f.textError != nil {
+		return nil, f.textError
+	}
+
+	f.texts = append(f.texts, sentMessage{from: from, to: recipientID, text: message, replyTo: quotedMessage(opts)})
+	return sentResponse(fmt.Sprintf("wamid.text.%d", len(f.texts))), nil
+}
+
+// sentResponse is the response of the Cloud API to a sent message
+func sentResponse(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"messages": []interface{}{map[string]interface{}{"id": id}},
+	}
+}
+
+func (f *fakeMessagingClient) SendInteractiveList(from, recipientID, body, button string, sections []whatsapp.ListSection, opts ...whatsapp.SendOption) (map[string]interface{}, error) {
+	f.lists = append(f.lists, sections)
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeMessagingClient) SendLocation(from, recipientID string, location whatsapp.Location, opts ...whatsapp.SendOption) (map[string]interface{}, error) {
+	f.locations = append(f.locations, location)
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeMessagingClient) SendContacts(from, recipientID string, contacts []whatsapp.Contact, opts ...whatsapp.SendOption) (map[string]interface{}, error) {
+	f.contacts = append(f.contacts, contacts)
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeMessagingClient) SendReaction(from, recipientID, messageID, emoji string) (map[string]interface{}, error) {
+	f.reactions = append(f.reactions, sentMessage{from: from, to: recipientID, text: emoji, document: messageID})
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeMessagingClient) MarkAsRead(from, messageID string) error {
+	f.read = append(f.read, messageID)
+	return nil
+}
+
+func (f *fakeMessagingClient) SendTypingIndicator(from, messageID string) error {
+	f.typing = append(f.typing, messageID)
+	return nil
+}
+
+func newTestMessageLog(t *testing.T) *messagelog.Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := messagelog.NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating message log: %v", err)
+	}
+
+	return repository
+}
+
+// testTimestamp is the time of the test messages, the customer service
+// window of the contacts is open when the answers are sent
+var testTimestamp = time.Now().Unix()
+
+var textMessage = []byte(fmt.Sprintf(`{
 		"object": "whatsapp_business_account",
 		"entry": [
 			{
@@ -39,7 +157,7 @@ func TestParseMessage_Success(t *testing.T) {
 								{
 									"from": "994503981865",
 									"id": "wamid.HBgNNDkxNzYzNTE2MzE5MRUCABIYFjNFQjA4QTQyQTIxOUZCMDI2QTFDRTYA",
-									"timestamp": "1681899808",
+									"timestamp": "%d",
 									"text": {
 										"body": "test"
 									},
@@ -52,12 +170,207 @@ func TestParseMessage_Success(t *testing.T) {
 				]
 			}
 		]
-	}`)
-	err := c.parsingMessage(data)
+	}`, testTimestamp))
+
+// newTextMessage returns a webhook payload of a text message
+func newTextMessage(from, name, id, body string) []byte {
+	return []byte(fmt.Sprintf(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"contacts":[{"profile":{"name":%q},"wa_id":%q}],"messages":[{"from":%q,"id":%q,"timestamp":"%d","text":{"body":%q},"type":"text"}]},"field":"messages"}]}]}`, name, from, from, id, testTimestamp, body))
+}
+
+// newListReply returns a webhook payload of a list reply
+func newListReply(from, name, id, rowID, title string) []byte {
+	return []byte(fmt.Sprintf(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"contacts":[{"profile":{"name":%q},"wa_id":%q}],"messages":[{"from":%q,"id":%q,"timestamp":"%d","interactive":{"type":"list_reply","list_reply":{"id":%q,"title":%q}},"type":"interactive"}]},"field":"messages"}]}]}`, name, from, from, id, testTimestamp, rowID, title))
+}
+
+func TestParseMessage_Success(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(textMessage)
+	if err != nil {
+		t.Errorf("error parsing message: %v", err)
+	}
+
+	if len(mc.texts) != 1 || mc.texts[0].to != "994503981865" || mc.texts[0].from != "15550909792" {
+		t.Errorf("expected a not ready text message, got %+v", mc.texts)
+	}
+}
+
+func TestParseMessage_ResultReady(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	lookup := results.Stub{Results: map[string]results.Result{
+		"994503981865": {Ready: true, DocumentURL: "https://example.com/api/v1/994503981865/document"},
+	}}
+	c := NewController(mc, WithResultLookup(lookup))
+	err := c.parsingMessage(textMessage)
+	if err != nil {
+		t.Errorf("error parsing message: %v", err)
+	}
+
+	if len(mc.documents) != 1 || mc.documents[0].document != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("expected the document to be sent, got %+v", mc.documents)
+	}
+}
+
+func TestParseMessage_Help(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "help"))
 	if err != nil {
 		t.Errorf("error parsing message: %v", err)
 	}
 
+	if len(mc.texts) != 1 || mc.texts[0].text != "Dear T.A, send \"result\" to receive your analysis results." {
+		t.Errorf("expected a help message, got %+v", mc.texts)
+	}
+}
+
+func TestParseMessage_ReplyLanguage(t *testing.T) {
+	tests := []struct {
+		body string
+		text string
+	}{
+		{body: "Результат", text: "Уважаемый(ая) T.A, результаты ваших анализов ещё не готовы."},
+		{body: "sonuç", text: "Sayın T.A, tahlil sonuçlarınız henüz hazır değil."},
+		{body: "salam", text: "Hörmətli T.A. Analiz nəticələriniz hələ hazır deyil."},
+	}
+
+	for _, tt := range tests {
+		mc := &fakeMessagingClient{}
+		c := NewController(mc, WithResultLookup(results.Stub{}))
+		err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", tt.body))
+		if err != nil {
+			t.Fatalf("error parsing message: %v", err)
+		}
+
+		if len(mc.texts) != 1 || mc.texts[0].text != tt.text {
+			t.Errorf("%s: expected %q, got %+v", tt.body, tt.text, mc.texts)
+		}
+	}
+}
+
+func TestParseMessage_ChangeLanguage(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "language"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if len(mc.lists) != 1 || len(mc.lists[0][0].Rows) != 4 {
+		t.Fatalf("expected a list of the languages, got %+v", mc.lists)
+	}
+
+	err = c.parsingMessage(newListReply("994503981865", "T.A", "wamid.2", "language:ru", "Русский"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	contact, _, _ := c.contacts.Get("994503981865")
+	if contact.Language != "ru" || contact.Name != "T.A" {
+		t.Errorf("expected the language to be stored, got %+v", contact)
+	}
+
+	err = c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.3", "salam"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if len(mc.texts) != 2 || mc.texts[1].text != "Уважаемый(ая) T.A, результаты ваших анализов ещё не готовы." {
+		t.Errorf("expected a reply in the chosen language, got %+v", mc.texts)
+	}
+}
+
+func TestParseMessage_OptOut(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+
+	messages := []struct {
+		body  string
+		texts int
+	}{
+		{body: "STOP", texts: 1},
+		{body: "netice", texts: 1},
+		{body: "START", texts: 2},
+		{body: "netice", texts: 3},
+	}
+
+	for i, m := range messages {
+		err := c.parsingMessage(newTextMessage("994503981865", "T.A", fmt.Sprintf("wamid.%d", i), m.body))
+		if err != nil {
+			t.Fatalf("error parsing message: %v", err)
+		}
+		if len(mc.texts) != m.texts {
+			t.Fatalf("%s: expected %d texts sent, got %+v", m.body, m.texts, mc.texts)
+		}
+	}
+
+	history, _ := c.consents.History("994503981865")
+	if len(history) != 2 || history[0].MessageID != "wamid.0" || history[1].MessageID != "wamid.2" {
+		t.Errorf("unexpected consent history: %+v", history)
+	}
+}
+
+func TestParseMessage_OptOutNotConfirmed(t *testing.T) {
+	mc := &fakeMessagingClient{textError: errors.New("graph unavailable")}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "STOP"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	status, _ := c.consents.Status("994503981865")
+	if status != contacts.OptInRevoked {
+		t.Errorf("expected the opt-out to be recorded though the confirmation failed, got %q", status)
+	}
+}
+
+func TestParseMessage_Logged(t *testing.T) {
+	ml := newTestMessageLog(t)
+	c := NewController(&fakeMessagingClient{}, WithResultLookup(results.Stub{}), WithMessageLog(ml))
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "netice"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	expected := messagelog.InboundRecord{
+		WaMID:     "wamid.1",
+		From:      "994503981865",
+		To:        "15550909792",
+		Type:      "text",
+		Body:      "netice",
+		Timestamp: time.Unix(testTimestamp, 0).UTC(),
+	}
+	inbound, err := ml.Inbound("994503981865")
+	if err != nil {
+		t.Fatalf("error loading inbound messages: %v", err)
+	}
+	if len(inbound) != 1 || inbound[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, inbound)
+	}
+}
+
+// failingLookup fails every results lookup
+type failingLookup struct{}
+
+func (failingLookup) Lookup(number string) (results.Result, error) {
+	return results.Result{}, fmt.Errorf("lab system unavailable")
+}
+
+func TestReceiveMessage_HandlerError(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(failingLookup{}))
+
+	// A failed answer must not make Meta deliver the message again
+	rr := httptest.NewRecorder()
+	c.ReceiveMessage(rr, httptest.NewRequest(http.MethodPost, "/api/v1/hook", bytes.NewReader(newTextMessage("994503981865", "T.A", "wamid.1", "netice"))))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	c.ReceiveMessage(rr, httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader("{")))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed webhook, got %d", rr.Code)
+	}
 }
 
 func TestParseMessage_Example(t *testing.T) {
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/handoff"
+	"github.com/tebrizetayi/messaging-integration-service/internal/intent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+const (
+	stateChooseLanguage conversation.State = "choose_language"
+
+	languagePayloadPrefix = "language:"
+)
+
+// registerFlows registers the conversation states and intents handled by the bot
+func (c *Controller) registerFlows() {
+	c.intents.Handle(intent.IntentResult, c.handleResult)
+	c.intents.Handle(intent.IntentHelp, c.handleHelp)
+	c.intents.Handle(intent.IntentLanguage, c.handleLanguage)
+	c.intents.Handle(intent.IntentStop, c.handleStop)
+	c.intents.Handle(intent.IntentStart, c.handleStart)
+	c.intents.Handle(intent.IntentOperator, c.handleOperator)
+	c.intents.Fallback(c.handleResult)
+
+	c.conversations.Handle(conversation.StateIdle, 0, c.handleIdle)
+	c.conversations.Handle(stateChooseLanguage, 10*time.Minute, c.handleChooseLanguage)
+	c.conversations.Handle(stateAgent, 0, c.handleAgent)
+
+	for _, messageType := range []string{
+		MessageTypeText, MessageTypeButton, MessageTypeInteractive,
+		MessageTypeImage, MessageTypeAudio, MessageTypeVideo, MessageTypeDocument, MessageTypeSticker,
+		MessageTypeLocation, MessageTypeContacts, MessageTypeOrder,
+	} {
+		c.handleMessage(messageType, c.dispatchMessage)
+	}
+	c.handleMessage(MessageTypeReaction, c.readMessage(c.logMessage))
+	c.handleMessage(MessageTypeSystem, c.readMessage(c.logMessage))
+	c.handleMessage(MessageTypeUnsupported, c.readMessage(c.replyUnsupported))
+}
+
+// handleMessage sets the handler of the message type unless an option already set it
+func (c *Controller) handleMessage(messageType string, h MessageHandler) {
+	if _, ok := c.messageHandlers[messageType]; !ok {
+		c.messageHandlers[messageType] = h
+	}
+}
+
+// handleIdle answers a contact which is not in the middle of a dialog
+func (c *Controller) handleIdle(session *conversation.Session, event conversation.Event) (conversation.State, error) {
+	// The session of a handed off contact is lost with the sessions kept in
+	// memory, the open handoff is kept
+	handedOff, err := c.handoffs.Get(event.ContactID)
+	if err == nil && handedOff.Open {
+		return c.handleAgent(session, event)
+	}
+	if err != nil && !errors.Is(err, handoff.ErrNotFound) {
+		return session.State, err
+	}
+
+	// The phone number is shared to answer a phone request for the results
+	if event.Phone != "" {
+		return c.handleResult(session, event, intent.Match{})
+	}
+
+	// The language list may be answered after the dialog timed out
+	if strings.HasPrefix(event.Payload, languagePayloadPrefix) {
+		return c.handleChooseLanguage(session, event)
+	}
+
+	if !isConversational(event.Type) {
+		err := c.replyUnsupported(Message{Type: event.Type}, event)
+		if err != nil {
+			return session.State, err
+		}
+		return conversation.StateIdle, nil
+	}
+
+	return c.intents.Dispatch(session, event)
+}
+
+// handleResult answers with the analysis results of the contact
+func (c *Controller) handleResult(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	err := c.sendResults(event, c.language(event, match))
+	if err != nil {
+		return session.State, err
+	}
+
+	return conversation.StateIdle, nil
+}
+
+// handleHelp tells the contact how to talk to the bot
+func (c *Controller) handleHelp(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	msg := c.catalog.Text(c.language(event, match), catalog.KeyHelp, map[string]string{"name": event.Name})
+	err := c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	if err != nil {
+		return session.State, err
+	}
+
+	return conversation.StateIdle, nil
+}
+
+// handleStop opts the contact out, the confirmation is the last message sent.
+// The opt-out is recorded first, a failed confirmation is only logged since
+// the webhook is acknowledged either way and the STOP isn't delivered again.
+func (c *Controller) handleStop(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	err := c.recordConsent(event, contacts.OptInRevoked)
+	if err != nil {
+		return session.State, err
+	}
+
+	msg := c.catalog.Text(c.language(event, match), catalog.KeyOptedOut, map[string]string{"name": event.Name})
+	err = c.deliverText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	if err != nil {
+		log.Printf("Error confirming the opt-out of %s: %v", event.ContactID, err)
+	}
+
+	return conversation.StateIdle, nil
+}
+
+// handleStart opts the contact in again
+func (c *Controller) handleStart(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	err := c.recordConsent(event, contacts.OptInGranted)
+	if err != nil {
+		return session.State, err
+	}
+
+	msg := c.catalog.Text(c.language(event, match), catalog.KeyOptedIn, map[string]string{"name": event.Name})
+	err = c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	if err != nil {
+		return session.State, err
+	}
+
+	return conversation.StateIdle, nil
+}
+
+// recordConsent records the consent in the ledger and on the contact
+func (c *Controller) recordConsent(event conversation.Event, optIn contacts.OptIn) error {
+	err := c.consents.Record(consent.Entry{
+		WaID:      event.ContactID,
+		OptIn:     optIn,
+		MessageID: event.MessageID,
+		At:        event.Time,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Contact %s %s by message %s", event.ContactID, optIn, event.MessageID)
+
+	return c.contacts.SetOptIn(event.ContactID, optIn)
+}
+
+// handleLanguage lets the contact pick the language of the replies from a list
+func (c *Controller) handleLanguage(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	language := c.language(event, match)
+
+	var rows []whatsapp.ListRow
+	for _, code := range c.catalog.Languages() {
+		rows = append(rows, whatsapp.ListRow{
+			ID:    languagePayloadPrefix + code,
+			Title: c.catalog.Text(code, catalog.KeyLanguageName, nil),
+		})
+	}
+
+	err := c.sendList(
+		event.Recipient,
+		event.ContactID,
+		c.catalog.Text(language, catalog.KeyChooseLanguage, map[string]string{"name": event.Name}),
+		c.catalog.Text(language, catalog.KeyChooseLanguageButton, nil),
+		[]whatsapp.ListSection{{Rows: rows}},
+		whatsapp.ReplyTo(event.MessageID),
+	)
+	if err != nil {
+		return session.State, err
+	}
+
+	return stateChooseLanguage, nil
+}
+
+// handleChooseLanguage stores the language the contact picked from the list or typed.
+// Anything else is answered as if the contact was idle.
+func (c *Controller) handleChooseLanguage(session *conversation.Session, event conversation.Event) (conversation.State, error) {
+	language := c.chosenLanguage(event)
+	if language == "" {
+		return c.intents.Dispatch(session, event)
+	}
+
+	err := c.contacts.SetLanguage(event.ContactID, language)
+	if err != nil {
+		return session.State, err
+	}
+	log.Printf("Contact %s changed language to %s", event.ContactID, language)
+
+	msg := c.catalog.Text(language, catalog.KeyLanguageChanged, map[string]string{"name": event.Name})
+	err = c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	if err != nil {
+		return session.State, err
+	}
+
+	return conversation.StateIdle, nil
+}
+
+// chosenLanguage returns the supported language of the list reply or the text of the event
+func (c *Controller) chosenLanguage(event conversation.Event) string {
+	if strings.HasPrefix(event.Payload, languagePayloadPrefix) {
+		language := strings.TrimPrefix(event.Payload, languagePayloadPrefix)
+		if c.catalog.Supports(language) {
+			return language
+		}
+		return ""
+	}
+
+	text := strings.TrimSpace(event.Text)
+	for _, language := range c.catalog.Languages() {
+		if strings.EqualFold(text, language) || strings.EqualFold(text, c.catalog.Text(language, catalog.KeyLanguageName, nil)) {
+			return language
+		}
+	}
+
+	return ""
+}
+
+// language returns the language to answer the event in: the language the
+// contact chose, then the language of the text, then the default language
+func (c *Controller) language(event conversation.Event, match intent.Match) string {
+	var preferred string
+	contact, ok, err := c.contacts.Get(event.ContactID)
+	if err != nil {
+		log.Printf("Error loading contact %s: %v", event.ContactID, err)
+	}
+	if ok {
+		preferred = contact.Language
+	}
+
+	return c.catalog.Language(preferred, match.Language, catalog.Detect(event.Text))
+}
+
+// sendResults sends the analysis results to the contact or tells that they are not ready yet
+func (c *Controller) sendResults(event conversation.Event, language string) error {
+	params := map[string]string{"name": event.Name}
+
+	number, err := c.resultsNumber(event.ContactID)
+	if err != nil {
+		return err
+	}
+	if number == "" {
+		return c.requestPhone(event, language, params)
+	}
+
+	result, err := c.resultLookup.Lookup(number)
+	if err != nil {
+		return err
+	}
+
+	if !result.Ready {
+		msg := c.catalog.Text(language, catalog.KeyResultsNotReady, params)
+		return c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	}
+
+	key := catalog.KeyResultsReady
+	if !result.Date.IsZero() {
+		key = catalog.KeyResultsReadyDate
+		params["date"] = c.catalog.FormatDate(language, result.Date)
+	}
+
+	caption := c.catalog.Text(language, key, params)
+	return c.sendDocument(event.Recipient, result.DocumentURL, event.ContactID, caption, whatsapp.ReplyTo(event.MessageID))
+}
+
+// resultsNumber returns the phone number the results of the contact are kept by:
+// the WhatsApp ID of the WhatsApp contacts, the shared phone number of the others.
+// It is empty while the contact of another channel hasn't shared it.
+func (c *Controller) resultsNumber(contactID string) (string, error) {
+	if name, _ := channel.Split(contactID); name == channel.WhatsApp {
+		return contactID, nil
+	}
+
+	contact, _, err := c.contacts.Get(contactID)
+	if err != nil {
+		return "", err
+	}
+
+	return contact.Phone, nil
+}
+
+// requestPhone asks the contact to share their phone number with the button of
+// the channel, the channels without such a button can't link a phone number
+func (c *Controller) requestPhone(event conversation.Event, language string, params map[string]string) error {
+	err := c.sendPhoneRequest(
+		event.Recipient,
+		event.ContactID,
+		c.catalog.Text(language, catalog.KeySharePhone, params),
+		c.catalog.Text(language, catalog.KeySharePhoneButton, nil),
+		whatsapp.ReplyTo(event.MessageID),
+	)
+	if errors.Is(err, channel.ErrPhoneRequestUnsupported) {
+		msg := c.catalog.Text(language, catalog.KeyPhoneUnavailable, params)
+		return c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	}
+
+	return err
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// DeliveryReport tells whether a patient received and read the results sent to them
+type DeliveryReport struct {
+	Number    string                      `json:"number"`
+	Delivered bool                        `json:"delivered"`
+	Read      bool                        `json:"read"`
+	Documents []messagelog.OutboundRecord `json:"documents"`
+}
+
+// GetDelivery returns the delivery statuses of the documents sent to the number, newest first
+func (c *Controller) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+
+	if c.messageLog == nil {
+		http.Error(w, "message log is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	documents, err := c.messageLog.OutboundTo(number, whatsapp.MessageTypeDocument)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := DeliveryReport{
+		Number:    number,
+		Documents: documents,
+	}
+	for _, document := range documents {
+		if document.DeliveredAt != nil || document.ReadAt != nil {
+			report.Delivered = true
+		}
+		if document.ReadAt != nil {
+			report.Read = true
+		}
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+)
+
+// newStatusMessage returns a webhook payload of a status update
+func newStatusMessage(id, status, recipient string, timestamp int64) []byte {
+	return []byte(fmt.Sprintf(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"statuses":[{"id":%q,"status":%q,"timestamp":"%d","recipient_id":%q}]},"field":"messages"}]}]}`, id, status, timestamp, recipient))
+}
+
+func TestGetDelivery_Success(t *testing.T) {
+	ml := newTestMessageLog(t)
+	c := NewController(&fakeMessagingClient{}, WithAPIKeys("lab-key"), WithMessageLog(ml))
+	api := NewAPI(c)
+
+	err := ml.SaveOutbound(messagelog.OutboundRecord{
+		ProviderID: "wamid.2",
+		From:       "15550909792",
+		To:         "994503981865",
+		Type:       "document",
+		Status:     messagelog.StatusSent,
+		CreatedAt:  time.Unix(1681899800, 0),
+	})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	// The read status arrives before the delivered status
+	for _, webhook := range [][]byte{
+		newStatusMessage("wamid.2", "sent", "994503981865", 1681899801),
+		newStatusMessage("wamid.2", "read", "994503981865", 1681899900),
+		newStatusMessage("wamid.2", "delivered", "994503981865", 1681899850),
+	} {
+		err = c.parsingMessage(webhook)
+		if err != nil {
+			t.Fatalf("error parsing message: %v", err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/994503981865/delivery", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without an API key, got %d", rr.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/994503981865/delivery", nil)
+	r.Header.Set("Authorization", "Bearer lab-key")
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var report DeliveryReport
+	err = json.NewDecoder(rr.Body).Decode(&report)
+	if err != nil {
+		t.Fatalf("Unable to decode report: %v", err)
+	}
+	if !report.Delivered || !report.Read || len(report.Documents) != 1 || report.Documents[0].Status != messagelog.StatusRead {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
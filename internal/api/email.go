@@ -0,0 +1,47 @@
+package api
+
+import (
+	"log"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+)
+
+// emailResults emails the results document to the address the contact registered,
+// in the language of the contact
+func (c *Controller) emailResults(number, filename string, document []byte) error {
+	if c.email == nil {
+		return nil
+	}
+
+	contact, ok, err := c.contacts.Get(number)
+	if err != nil {
+		return err
+	}
+	if !ok || contact.Email == "" {
+		return nil
+	}
+	err = c.checkConsent(number, "email")
+	if err != nil {
+		return err
+	}
+
+	language := c.catalog.Language(contact.Language)
+	params := map[string]string{"name": contact.Name}
+	err = c.email.Send(email.Message{
+		To:      []string{contact.Email},
+		Subject: c.catalog.Text(language, catalog.KeyEmailSubject, params),
+		Body:    c.catalog.Text(language, catalog.KeyEmailBody, params),
+		Attachments: []email.Attachment{{
+			Filename:    filename,
+			ContentType: "application/pdf",
+			Data:        document,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Emailed results of %s to %s", number, contact.Email)
+
+	return nil
+}
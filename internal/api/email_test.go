@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/email"
+)
+
+type fakeEmailSender struct {
+	messages []email.Message
+}
+
+func (f *fakeEmailSender) Send(message email.Message) error {
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func TestUploadDocument_Email(t *testing.T) {
+	sender := &fakeEmailSender{}
+	store := contacts.NewMemoryStore()
+	store.SetLanguage("994500000001", "en")
+	c := NewController(&fakeMessagingClient{}, WithAPIKeys("lab-key"), WithEmail(sender), WithContactStore(store))
+
+	pdf := []byte("%PDF-1.4 results")
+	var saved bool
+	uploadReplacing := func(number, address, token string, replace bool) int {
+		body := fmt.Sprintf(`{"number":%q,"document":%q,"email":%q,"replace_email":%t}`, number, base64.StdEncoding.EncodeToString(pdf), address, replace)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/"+number+"/document", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		c.UploadDocument(rr, r)
+		c.WaitEmails()
+		_, err := os.Stat(number + ".pdf")
+		saved = err == nil
+		os.Remove(number + ".pdf")
+		return rr.Code
+	}
+	upload := func(number, address, token string) int {
+		return uploadReplacing(number, address, token, false)
+	}
+
+	if code := upload("994500000001", "patient@example.com", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 registering an address without an API key, got %d", code)
+	}
+	if code := upload("994500000001", "patient@", "lab-key"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid address, got %d", code)
+	}
+	if code := upload("994500000001", "patient@example.com", "lab-key"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// The address registered before is used for the next results
+	if code := upload("994500000001", "", ""); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := upload("994500000002", "", ""); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// Another address doesn't replace the registered one, the document is saved anyway
+	if code := upload("994500000001", "someone@example.com", "lab-key"); code != http.StatusConflict {
+		t.Fatalf("expected 409 for another address, got %d", code)
+	}
+	if !saved {
+		t.Errorf("expected the document to be saved despite the conflict")
+	}
+	if code := uploadReplacing("994500000001", "someone@example.com", "", true); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 replacing an address without an API key, got %d", code)
+	}
+	if code := uploadReplacing("994500000001", "someone@example.com", "lab-key", true); code != http.StatusOK {
+		t.Fatalf("expected 200 replacing the address, got %d", code)
+	}
+
+	if len(sender.messages) != 3 {
+		t.Fatalf("expected 3 emails, got %+v", sender.messages)
+	}
+	if sender.messages[2].To[0] != "someone@example.com" {
+		t.Errorf("expected the results to go to the replaced address, got %+v", sender.messages[2].To)
+	}
+	message := sender.messages[0]
+	if message.To[0] != "patient@example.com" || message.Subject != "Your analysis results" {
+		t.Errorf("unexpected email %+v", message)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].Filename != "994500000001.pdf" || string(message.Attachments[0].Data) != string(pdf) {
+		t.Errorf("unexpected attachments %+v", message.Attachments)
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// errorCodeUndeliverable is the error of the messages to numbers which
+// don't use WhatsApp or can't receive the message
+const errorCodeUndeliverable = 131026
+
+// fallbackMaxAge is how long an undelivered message is still worth sending
+// on the fallback channel, e.g. the backlog of a downtime isn't sent days later
+const fallbackMaxAge = 24 * time.Hour
+
+// hasErrorCode tells whether one of the errors has the code
+func hasErrorCode(errs []WebhookError, code int) bool {
+	for _, e := range errs {
+		if e.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunFallback sends the undelivered messages on the fallback channel every
+// interval until stop is closed
+func (c *Controller) RunFallback(interval time.Duration, stop <-chan struct{}) {
+	if c.fallback == nil || c.messageLog == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := c.FallBackUndelivered(now)
+			if err != nil {
+				log.Printf("Error sending undelivered messages on %s: %v", c.fallback.Name(), err)
+			}
+		}
+	}
+}
+
+// FallBackUndelivered sends the messages which aren't delivered within
+// the fallback timeout on the fallback channel
+func (c *Controller) FallBackUndelivered(now time.Time) error {
+	if c.fallback == nil || c.messageLog == nil {
+		return nil
+	}
+
+	records, err := c.messageLog.Undelivered(now.Add(-fallbackMaxAge), now.Add(-c.fallbackTimeout))
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = c.fallBack(record, now)
+		if err != nil {
+			log.Printf("Error sending message %s on %s: %v", record.ProviderID, c.fallback.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// fallBackFailed sends the message which failed on WhatsApp on the fallback channel
+func (c *Controller) fallBackFailed(providerID string) {
+	if c.fallback == nil || c.messageLog == nil {
+		return
+	}
+
+	record, err := c.messageLog.Outbound(providerID)
+	if err != nil {
+		log.Printf("Error loading message %s: %v", providerID, err)
+		return
+	}
+
+	err = c.fallBack(record, time.Now())
+	if err != nil {
+		log.Printf("Error sending message %s on %s: %v", providerID, c.fallback.Name(), err)
+	}
+}
+
+// fallBack sends the text or document of the message on the fallback channel,
+// a message is sent there once even if it both fails and times out.
+// A window template is sent as the message it replaced.
+func (c *Controller) fallBack(record messagelog.OutboundRecord, now time.Time) error {
+	messageType := record.Type
+	if messageType == whatsapp.MessageTypeTemplate {
+		messageType = record.Replaces
+	}
+	if messageType != "text" && messageType != "document" {
+		return nil
+	}
+
+	// Messages logged before the body was stored only have the summary
+	body := record.Body
+	if body == "" {
+		body = record.Summary
+	}
+
+	marked, err := c.messageLog.MarkFallback(record.ProviderID, now)
+	if err != nil || !marked {
+		return err
+	}
+
+	err = c.checkConsent(record.To, c.fallback.Name())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sending %s message %s to %s on %s", messageType, record.ProviderID, record.To, c.fallback.Name())
+	message := channel.OutboundMessage{
+		RecipientID: record.To,
+		Type:        channel.MessageTypeText,
+		Text:        body,
+	}
+	if messageType == "document" {
+		message.Type = channel.MessageTypeDocument
+		message.Text = ""
+		message.DocumentURL = body
+	}
+
+	return c.fallback.Send(message)
+}
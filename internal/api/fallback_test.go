@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+)
+
+// fakeProvider records the messages sent on a channel
+type fakeProvider struct {
+	name      string
+	texts     []sentMessage
+	documents []sentMessage
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) Send(message channel.OutboundMessage) error {
+	sent := sentMessage{to: message.RecipientID, text: message.Text, document: message.DocumentURL, replyTo: message.ReplyTo}
+	if message.Type == channel.MessageTypeDocument {
+		p.documents = append(p.documents, sent)
+		return nil
+	}
+
+	p.texts = append(p.texts, sent)
+	return nil
+}
+
+func (p *fakeProvider) ParseUpdate(r *http.Request) ([]channel.InboundMessage, error) {
+	return nil, nil
+}
+
+func TestFallback_Undeliverable(t *testing.T) {
+	ml := newTestMessageLog(t)
+	sms := &fakeProvider{name: "sms"}
+	c := NewController(&fakeMessagingClient{}, WithMessageLog(ml), WithFallback(sms, 15*time.Minute), WithNotifier(&fakeNotifier{}))
+
+	err := ml.SaveOutbound(messagelog.OutboundRecord{ProviderID: "wamid.4", To: "994503981865", Type: "document", Summary: "https://example.com/api/v1/994503981865/document", Status: messagelog.StatusSent})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	data := []byte(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"statuses":[{"id":"wamid.4","status":"failed","timestamp":"1681899900","recipient_id":"994503981865","errors":[{"code":131026,"title":"Message undeliverable"}]}]},"field":"messages"}]}]}`)
+	for i := 0; i < 2; i++ {
+		err = c.parsingMessage(data)
+		if err != nil {
+			t.Fatalf("error parsing message: %v", err)
+		}
+	}
+
+	if len(sms.documents) != 1 || sms.documents[0].to != "994503981865" || sms.documents[0].document != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("expected the document to be sent once by SMS, got %+v", sms.documents)
+	}
+}
+
+func TestFallback_Undelivered(t *testing.T) {
+	ml := newTestMessageLog(t)
+	sms := &fakeProvider{name: "sms"}
+	c := NewController(&fakeMessagingClient{}, WithMessageLog(ml), WithFallback(sms, 15*time.Minute))
+
+	now := time.Now()
+	for _, record := range []messagelog.OutboundRecord{
+		{ProviderID: "wamid.1", To: "994503981865", Type: "text", Summary: "Your results are ready", Status: messagelog.StatusSent, CreatedAt: now.Add(-time.Hour)},
+		{ProviderID: "wamid.2", To: "994503981866", Type: "text", Summary: "Your results are ready", Status: messagelog.StatusSent, CreatedAt: now.Add(-time.Minute)},
+		{ProviderID: "wamid.3", To: "994503981867", Type: "interactive", Summary: "Choose language", Status: messagelog.StatusSent, CreatedAt: now.Add(-time.Hour)},
+		{ProviderID: "wamid.4", To: "994503981868", Type: "text", Summary: "Your results are ready", Status: messagelog.StatusSent, CreatedAt: now.Add(-72 * time.Hour)},
+	} {
+		err := ml.SaveOutbound(record)
+		if err != nil {
+			t.Fatalf("error saving outbound message: %v", err)
+		}
+	}
+
+	err := c.FallBackUndelivered(now)
+	if err != nil {
+		t.Fatalf("error sending undelivered messages: %v", err)
+	}
+
+	if len(sms.texts) != 1 || sms.texts[0].to != "994503981865" || sms.texts[0].text != "Your results are ready" {
+		t.Errorf("expected the timed out text to be sent by SMS, got %+v", sms.texts)
+	}
+}
+
+func TestFallback_FullBody(t *testing.T) {
+	ml := newTestMessageLog(t)
+	sms := &fakeProvider{name: "sms"}
+	c := NewController(&fakeMessagingClient{}, WithMessageLog(ml), WithFallback(sms, 15*time.Minute))
+
+	now := time.Now()
+	text := strings.Repeat("Your results are ready. ", 20)
+	err := ml.SaveOutbound(messagelog.OutboundRecord{ProviderID: "wamid.1", To: "994503981865", Type: "text", Summary: text[:200], Body: text, Status: messagelog.StatusSent, CreatedAt: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	err = c.FallBackUndelivered(now)
+	if err != nil {
+		t.Fatalf("error sending undelivered messages: %v", err)
+	}
+
+	if len(sms.texts) != 1 || sms.texts[0].text != text {
+		t.Errorf("expected the full text to be sent by SMS, got %+v", sms.texts)
+	}
+}
+
+func TestFallback_WindowTemplate(t *testing.T) {
+	ml := newTestMessageLog(t)
+	sms := &fakeProvider{name: "sms"}
+	c := NewController(&fakeMessagingClient{}, WithMessageLog(ml), WithFallback(sms, 15*time.Minute),
+		WithContactStore(newLapsedContacts(t)), WithWindowTemplate("results_ready", ""))
+
+	// The fake client doesn't log the messages, the WhatsApp client records the template when sending it
+	now := time.Now()
+	err := ml.SaveOutbound(messagelog.OutboundRecord{ProviderID: "wamid.template.1", To: "994503981865", Type: "template", Summary: "results_ready", Status: messagelog.StatusSent, CreatedAt: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	err = c.sendDocument("15550909792", "https://example.com/api/v1/994503981865/document", "994503981865", "Your results")
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Fatalf("expected ErrWindowClosed, got %v", err)
+	}
+
+	err = c.FallBackUndelivered(now)
+	if err != nil {
+		t.Fatalf("error sending undelivered messages: %v", err)
+	}
+
+	if len(sms.documents) != 1 || sms.documents[0].to != "994503981865" || sms.documents[0].document != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("expected the replaced document to be sent by SMS, got %+v", sms.documents)
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/handoff"
+	"github.com/tebrizetayi/messaging-integration-service/internal/intent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+const (
+	// stateAgent is the state of the conversations handled by staff,
+	// the bot doesn't answer until the handoff is closed.
+	stateAgent conversation.State = "agent"
+)
+
+// HandoffReply is the JSON body of a staff reply
+type HandoffReply struct {
+	Text string `json:"text"`
+}
+
+// handleOperator hands the conversation off to staff
+func (c *Controller) handleOperator(session *conversation.Session, event conversation.Event, match intent.Match) (conversation.State, error) {
+	_, err := c.handoffs.Open(event.ContactID, event.Name, event.Recipient, event.Time)
+	if err != nil {
+		return session.State, err
+	}
+
+	err = c.handoffs.Append(event.ContactID, handoff.Message{
+		Direction: handoff.DirectionInbound,
+		MessageID: event.MessageID,
+		Text:      event.Text,
+		At:        event.Time,
+	})
+	if err != nil {
+		return session.State, err
+	}
+	log.Printf("Conversation with %s handed off to staff", event.ContactID)
+
+	msg := c.catalog.Text(c.language(event, match), catalog.KeyHandoffStarted, map[string]string{"name": event.Name})
+	err = c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+	if err != nil {
+		return session.State, err
+	}
+
+	return stateAgent, nil
+}
+
+// handleAgent queues the messages of a conversation handled by staff
+func (c *Controller) handleAgent(session *conversation.Session, event conversation.Event) (conversation.State, error) {
+	err := c.handoffs.Append(event.ContactID, handoff.Message{
+		Direction: handoff.DirectionInbound,
+		MessageID: event.MessageID,
+		Text:      handoffText(event),
+		At:        event.Time,
+	})
+	if errors.Is(err, handoff.ErrNotFound) || errors.Is(err, handoff.ErrClosed) {
+		// The handoff was closed without the session being moved back to the bot
+		return c.handleIdle(session, event)
+	}
+	if err != nil {
+		return session.State, err
+	}
+
+	return stateAgent, nil
+}
+
+// handedOff tells whether staff answers the conversation of the contact.
+// The open handoff decides rather than the session, which doesn't survive a restart.
+func (c *Controller) handedOff(contactID string) bool {
+	handedOff, err := c.handoffs.Get(contactID)
+	if err != nil && !errors.Is(err, handoff.ErrNotFound) {
+		log.Printf("Error loading handoff of %s: %v", contactID, err)
+	}
+
+	return err == nil && handedOff.Open
+}
+
+// handoffText returns the text staff sees for the message, the media and
+// the other messages which are not texts are marked with their type
+func handoffText(event conversation.Event) string {
+	if isConversational(event.Type) {
+		return event.Text
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("[%s] %s", event.Type, event.Text))
+}
+
+// ListHandoffs returns the conversations waiting for staff
+func (c *Controller) ListHandoffs(w http.ResponseWriter, r *http.Request) {
+	handedOff, err := c.handoffs.ListOpen()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, handedOff)
+}
+
+// GetHandoff returns a handed off conversation with its messages
+func (c *Controller) GetHandoff(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+
+	handedOff, err := c.handoffs.Get(number)
+	if errors.Is(err, handoff.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, handedOff)
+}
+
+// ReplyHandoff sends the reply of staff to the contact
+func (c *Controller) ReplyHandoff(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+
+	var reply HandoffReply
+	err := json.NewDecoder(r.Body).Decode(&reply)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reply.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	handedOff, err := c.handoffs.Get(number)
+	if errors.Is(err, handoff.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !handedOff.Open {
+		http.Error(w, handoff.ErrClosed.Error(), http.StatusConflict)
+		return
+	}
+
+	// The history only shows what the contact received
+	sendErr := c.sendText(handedOff.Recipient, reply.Text, number)
+	var templateSent *windowTemplateSent
+	switch {
+	case sendErr == nil:
+		err = c.appendOutbound(number, reply.Text)
+	case errors.As(sendErr, &templateSent):
+		err = c.appendOutbound(number, fmt.Sprintf("[template] %s", templateSent.template))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case errors.Is(sendErr, ErrOptedOut):
+		http.Error(w, sendErr.Error(), http.StatusConflict)
+	case errors.Is(sendErr, ErrWindowClosed):
+		http.Error(w, sendErr.Error(), http.StatusUnprocessableEntity)
+	case sendErr != nil:
+		http.Error(w, sendErr.Error(), http.StatusBadGateway)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// appendOutbound adds a message sent to the contact to the history of the handoff
+func (c *Controller) appendOutbound(waID, text string) error {
+	return c.handoffs.Append(waID, handoff.Message{
+		Direction: handoff.DirectionOutbound,
+		Text:      text,
+		At:        time.Now(),
+	})
+}
+
+// CloseHandoff hands the conversation back to the bot
+func (c *Controller) CloseHandoff(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+
+	handedOff, err := c.handoffs.Close(number, time.Now())
+	if errors.Is(err, handoff.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, handoff.ErrClosed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = c.conversations.Transition(number, conversation.StateIdle)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Conversation with %s handed back to the bot", number)
+
+	respondJSON(w, http.StatusOK, handedOff)
+}
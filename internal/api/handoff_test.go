@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/handoff"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+)
+
+func handoffRequest(api http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer staff-key")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestHandoff_Lifecycle(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithAPIKeys("staff-key"), WithResultLookup(results.Stub{}))
+	api := NewAPI(c)
+
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "operator"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	err = c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.2", "When do you open?"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if len(mc.texts) != 1 {
+		t.Fatalf("expected the bot to stop replying, got %+v", mc.texts)
+	}
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/handoffs", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without an API key, got %d", rr.Code)
+	}
+
+	rr = handoffRequest(api, http.MethodGet, "/api/v1/handoffs", "")
+	var open []handoff.Conversation
+	json.NewDecoder(rr.Body).Decode(&open)
+	if rr.Code != http.StatusOK || len(open) != 1 || open[0].WaID != "994503981865" {
+		t.Fatalf("expected one open handoff, got %d %+v", rr.Code, open)
+	}
+
+	rr = handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/reply", `{"text":"At 9:00"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if len(mc.texts) != 2 || mc.texts[1].text != "At 9:00" || mc.texts[1].from != "15550909792" {
+		t.Errorf("expected the reply to be sent, got %+v", mc.texts)
+	}
+
+	rr = handoffRequest(api, http.MethodGet, "/api/v1/handoffs/994503981865", "")
+	var history handoff.Conversation
+	json.NewDecoder(rr.Body).Decode(&history)
+	if len(history.Messages) != 3 || history.Messages[2].Direction != handoff.DirectionOutbound {
+		t.Errorf("unexpected history: %+v", history.Messages)
+	}
+
+	rr = handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/close", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	err = c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.3", "netice"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if len(mc.texts) != 3 {
+		t.Errorf("expected the bot to reply again, got %+v", mc.texts)
+	}
+
+	rr = handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/reply", `{"text":"Hello"}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestHandoff_SurvivesRestart(t *testing.T) {
+	handoffs := handoff.NewMemoryStore()
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithHandoffStore(handoffs), WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "operator"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	// The sessions are lost on a restart, the handoff store isn't
+	restarted := NewController(mc, WithHandoffStore(handoffs), WithResultLookup(results.Stub{}))
+	err = restarted.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.2", "netice"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if len(mc.texts) != 1 || len(mc.documents) != 0 {
+		t.Errorf("expected the bot to stay silent, got %+v %+v", mc.texts, mc.documents)
+	}
+
+	history, err := handoffs.Get("994503981865")
+	if err != nil || len(history.Messages) != 2 || history.Messages[1].Text != "netice" {
+		t.Errorf("expected the message to be queued for staff, got %+v: %v", history.Messages, err)
+	}
+}
+
+func TestReplyHandoff_NotSent(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	handoffs := handoff.NewMemoryStore()
+	handoffs.Open("994503981865", "T.A", "15550909792", time.Now().Add(-48*time.Hour))
+	ledger := consent.NewMemoryLedger()
+	c := NewController(mc, WithAPIKeys("staff-key"), WithHandoffStore(handoffs), WithConsentLedger(ledger),
+		WithContactStore(newLapsedContacts(t)), WithWindowTemplate("results_ready", ""))
+	api := NewAPI(c)
+
+	rr := handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/reply", `{"text":"At 9:00"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 with a closed window, got %d", rr.Code)
+	}
+	if len(mc.texts) != 0 || len(mc.templates) != 1 {
+		t.Errorf("expected the window template instead of the reply, got %+v %+v", mc.texts, mc.templates)
+	}
+
+	ledger.Record(consent.Entry{WaID: "994503981865", OptIn: contacts.OptInRevoked, At: time.Now()})
+	rr = handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/reply", `{"text":"At 9:00"}`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for an opted out contact, got %d", rr.Code)
+	}
+
+	history, _ := handoffs.Get("994503981865")
+	if len(history.Messages) != 1 || history.Messages[0].Text != "[template] results_ready" {
+		t.Errorf("expected only the template in the history, got %+v", history.Messages)
+	}
+}
+
+func TestReplyHandoff_Rejected(t *testing.T) {
+	mc := &fakeMessagingClient{textError: &whatsapp.ResponseError{Code: 131026, Message: "Message undeliverable"}}
+	handoffs := handoff.NewMemoryStore()
+	handoffs.Open("994503981865", "T.A", "15550909792", time.Now())
+	store := contacts.NewMemoryStore()
+	store.Touch("994503981865", "T.A", time.Now())
+	c := NewController(mc, WithAPIKeys("staff-key"), WithHandoffStore(handoffs), WithContactStore(store))
+	api := NewAPI(c)
+
+	rr := handoffRequest(api, http.MethodPost, "/api/v1/handoffs/994503981865/reply", `{"text":"At 9:00"}`)
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for a rejected reply, got %d", rr.Code)
+	}
+
+	history, _ := handoffs.Get("994503981865")
+	if len(history.Messages) != 0 {
+		t.Errorf("expected no history for a rejected reply, got %+v", history.Messages)
+	}
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/catalog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/intent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// The types of the inbound WhatsApp messages
+const (
+	MessageTypeText        = "text"
+	MessageTypeImage       = "image"
+	MessageTypeAudio       = "audio"
+	MessageTypeVideo       = "video"
+	MessageTypeDocument    = "document"
+	MessageTypeSticker     = "sticker"
+	MessageTypeLocation    = "location"
+	MessageTypeContacts    = "contacts"
+	MessageTypeButton      = "button"
+	MessageTypeInteractive = "interactive"
+	MessageTypeReaction    = "reaction"
+	MessageTypeOrder       = "order"
+	MessageTypeSystem      = "system"
+	MessageTypeUnsupported = "unsupported"
+)
+
+// Message is an inbound WhatsApp message.
+// Only the field matching the Type is set.
+type Message struct {
+	ID        string
+	From      string
+	Type      string
+	Timestamp time.Time
+
+	Text        string
+	Media       *Media
+	Location    *Location
+	Contacts    []SharedContact
+	Button      *Button
+	Interactive *InteractiveReply
+	Reaction    *Reaction
+	Order       *Order
+	System      *System
+	Errors      []WebhookError
+	Context     *MessageContext
+}
+
+// MessageContext is set when the message quotes or forwards another message
+type MessageContext struct {
+	From                string `json:"from"`
+	ID                  string `json:"id"`
+	Forwarded           bool   `json:"forwarded"`
+	FrequentlyForwarded bool   `json:"frequently_forwarded"`
+}
+
+// Media is an image, audio, video, document or sticker, downloadable by its ID
+type Media struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	SHA256   string `json:"sha256"`
+	Caption  string `json:"caption"`
+	Filename string `json:"filename"`
+	Animated bool   `json:"animated"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	URL       string  `json:"url"`
+}
+
+// SharedContact is a contact card the sender shared
+type SharedContact struct {
+	Name struct {
+		FormattedName string `json:"formatted_name"`
+		FirstName     string `json:"first_name"`
+		LastName      string `json:"last_name"`
+	} `json:"name"`
+	Phones []struct {
+		Phone string `json:"phone"`
+		WaID  string `json:"wa_id"`
+		Type  string `json:"type"`
+	} `json:"phones"`
+	Emails []struct {
+		Email string `json:"email"`
+		Type  string `json:"type"`
+	} `json:"emails"`
+}
+
+// Button is the quick reply button of a template the sender tapped
+type Button struct {
+	Payload string `json:"payload"`
+	Text    string `json:"text"`
+}
+
+// InteractiveReply is the reply button or list row the sender picked
+type InteractiveReply struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Reaction is an emoji the sender put on a message, an empty emoji removes the reaction
+type Reaction struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+type Order struct {
+	CatalogID    string `json:"catalog_id"`
+	Text         string `json:"text"`
+	ProductItems []struct {
+		ProductRetailerID string  `json:"product_retailer_id"`
+		Quantity          int     `json:"quantity"`
+		ItemPrice         float64 `json:"item_price"`
+		Currency          string  `json:"currency"`
+	} `json:"product_items"`
+}
+
+// System is a notification about the sender, e.g. a changed phone number
+type System struct {
+	Body     string `json:"body"`
+	Type     string `json:"type"`
+	NewWaID  string `json:"new_wa_id"`
+	WaID     string `json:"wa_id"`
+	Identity string `json:"identity"`
+}
+
+// rawMessage is a message as it is sent in the webhook
+type rawMessage struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Text      *struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image       *Media          `json:"image"`
+	Audio       *Media          `json:"audio"`
+	Video       *Media          `json:"video"`
+	Document    *Media          `json:"document"`
+	Sticker     *Media          `json:"sticker"`
+	Location    *Location       `json:"location"`
+	Contacts    []SharedContact `json:"contacts"`
+	Button      *Button         `json:"button"`
+	Interactive *struct {
+		Type        string            `json:"type"`
+		ButtonReply *InteractiveReply `json:"button_reply"`
+		ListReply   *InteractiveReply `json:"list_reply"`
+	} `json:"interactive"`
+	Reaction *Reaction       `json:"reaction"`
+	Order    *Order          `json:"order"`
+	System   *System         `json:"system"`
+	Errors   []interface{}   `json:"errors"`
+	Context  *MessageContext `json:"context"`
+}
+
+// GetMessage returns the first message of the webhook.
+// Types this service doesn't know are returned as unsupported.
+func (md *MessengerData) GetMessage() (Message, error) {
+	messageList, ok := md.preprocessedData["messages"].([]interface{})
+	if !ok || len(messageList) == 0 {
+		return Message{}, errors.New("messages not found in data")
+	}
+
+	data, err := json.Marshal(messageList[0])
+	if err != nil {
+		return Message{}, err
+	}
+
+	var raw rawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return Message{}, fmt.Errorf("invalid message: %w", err)
+	}
+
+	message := Message{
+		ID:      raw.ID,
+		From:    raw.From,
+		Type:    raw.Type,
+		Errors:  parseErrors(raw.Errors),
+		Context: raw.Context,
+	}
+
+	seconds, err := strconv.ParseInt(raw.Timestamp, 10, 64)
+	if err == nil {
+		message.Timestamp = time.Unix(seconds, 0)
+	}
+
+	switch raw.Type {
+	case MessageTypeText:
+		if raw.Text != nil {
+			message.Text = raw.Text.Body
+		}
+	case MessageTypeImage:
+		message.Media = raw.Image
+	case MessageTypeAudio:
+		message.Media = raw.Audio
+	case MessageTypeVideo:
+		message.Media = raw.Video
+	case MessageTypeDocument:
+		message.Media = raw.Document
+	case MessageTypeSticker:
+		message.Media = raw.Sticker
+	case MessageTypeLocation:
+		message.Location = raw.Location
+	case MessageTypeContacts:
+		message.Contacts = raw.Contacts
+	case MessageTypeButton:
+		message.Button = raw.Button
+	case MessageTypeInteractive:
+		if raw.Interactive != nil {
+			reply := raw.Interactive.ButtonReply
+			if reply == nil {
+				reply = raw.Interactive.ListReply
+			}
+			if reply != nil {
+				reply.Type = raw.Interactive.Type
+			}
+			message.Interactive = reply
+		}
+	case MessageTypeReaction:
+		message.Reaction = raw.Reaction
+	case MessageTypeOrder:
+		message.Order = raw.Order
+	case MessageTypeSystem:
+		message.System = raw.System
+	default:
+		message.Type = MessageTypeUnsupported
+	}
+
+	return message, nil
+}
+
+// Body returns the text of the message: the text body, the title of the
+// picked button or row, the caption of the media or the text of the order
+func (m Message) Body() string {
+	switch {
+	case m.Text != "":
+		return m.Text
+	case m.Button != nil:
+		return m.Button.Text
+	case m.Interactive != nil:
+		return m.Interactive.Title
+	case m.Media != nil:
+		return m.Media.Caption
+	case m.Order != nil:
+		return m.Order.Text
+	case m.System != nil:
+		return m.System.Body
+	case m.Location != nil:
+		return m.Location.Name
+	}
+
+	return ""
+}
+
+// Payload returns the ID of the button or list row the sender picked
+func (m Message) Payload() string {
+	switch {
+	case m.Button != nil:
+		return m.Button.Payload
+	case m.Interactive != nil:
+		return m.Interactive.ID
+	}
+
+	return ""
+}
+
+// ReplyTo returns the ID of the message the sender quoted,
+// a forwarded message quotes nothing
+func (m Message) ReplyTo() string {
+	if m.Context == nil {
+		return ""
+	}
+
+	return m.Context.ID
+}
+
+// Inbound translates the message to the channel-neutral model,
+// the recipient is the business number the message was sent to
+func (m Message) Inbound(recipient, name string) channel.InboundMessage {
+	message := channel.InboundMessage{
+		Channel:     channel.WhatsApp,
+		SenderID:    m.From,
+		ContactName: name,
+		Recipient:   recipient,
+		MessageID:   m.ID,
+		Type:        m.Type,
+		Text:        m.Body(),
+		Payload:     m.Payload(),
+		ReplyTo:     m.ReplyTo(),
+		Time:        m.Timestamp,
+	}
+	if m.Media != nil {
+		message.Media = []channel.MediaRef{{ID: m.Media.ID, MimeType: m.Media.MimeType, Caption: m.Media.Caption}}
+	}
+
+	return message
+}
+
+// MessageHandler handles an inbound message of a type, the event carries
+// the sender and the text of the message for the conversation flows
+type MessageHandler func(message Message, event conversation.Event) error
+
+// isConversational tells whether the bot understands messages of the type
+func isConversational(messageType string) bool {
+	switch messageType {
+	case "", MessageTypeText, MessageTypeButton, MessageTypeInteractive:
+		return true
+	}
+
+	return false
+}
+
+// readMessage marks the message as read before handling it, unless staff
+// answers the conversation. The messages passed to the conversation flows
+// aren't marked separately, the typing indicator marks them as read.
+func (c *Controller) readMessage(h MessageHandler) MessageHandler {
+	return func(message Message, event conversation.Event) error {
+		if !c.handedOff(event.ContactID) {
+			c.markAsRead(event.Recipient, event.MessageID)
+		}
+
+		return h(message, event)
+	}
+}
+
+// dispatchMessage passes the message to the conversation of the sender.
+// The sender sees the bot typing unless staff answers the conversation,
+// the typing indicator also marks the message as read.
+func (c *Controller) dispatchMessage(message Message, event conversation.Event) error {
+	if !c.handedOff(event.ContactID) {
+		err := c.messagingClientManager.SendTypingIndicator(event.Recipient, event.MessageID)
+		if err != nil {
+			log.Printf("Error sending typing indicator to %s: %v", event.ContactID, err)
+		}
+	}
+
+	_, err := c.conversations.Dispatch(event)
+	return err
+}
+
+// logMessage only logs the message, e.g. a reaction which needs no answer
+func (c *Controller) logMessage(message Message, event conversation.Event) error {
+	switch {
+	case message.Reaction != nil:
+		log.Printf("Contact %s reacted %q to message %s", event.ContactID, message.Reaction.Emoji, message.Reaction.MessageID)
+	case message.System != nil:
+		log.Printf("System message for contact %s: %s", event.ContactID, message.System.Body)
+	default:
+		log.Printf("Ignoring %s message %s of contact %s", message.Type, message.ID, event.ContactID)
+	}
+
+	return nil
+}
+
+// replyUnsupported tells the contact that the bot only reads text messages
+func (c *Controller) replyUnsupported(message Message, event conversation.Event) error {
+	for _, e := range message.Errors {
+		log.Printf("Unsupported message %s of contact %s: %v", message.ID, event.ContactID, e)
+	}
+
+	msg := c.catalog.Text(c.language(event, intent.Match{}), catalog.KeyUnsupportedMessage, map[string]string{"name": event.Name})
+	return c.sendText(event.Recipient, msg, event.ContactID, whatsapp.ReplyTo(event.MessageID))
+}
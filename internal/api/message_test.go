@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+	"github.com/tebrizetayi/messaging-integration-service/internal/results"
+)
+
+// newMessage returns a webhook payload of a message, content is the JSON field of the type
+func newMessage(from, id, messageType, content string) []byte {
+	return []byte(fmt.Sprintf(`{"object":"whatsapp_business_account","entry":[{"id":"102140959526615","changes":[{"value":{"messaging_product":"whatsapp","metadata":{"display_phone_number":"15550909792","phone_number_id":"106189092448679"},"contacts":[{"profile":{"name":"T.A"},"wa_id":%q}],"messages":[{"from":%q,"id":%q,"timestamp":"%d",%s"type":%q}]},"field":"messages"}]}]}`, from, from, id, testTimestamp, content, messageType))
+}
+
+func TestGetMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType string
+		content     string
+		check       func(Message) bool
+	}{
+		{"text", "text", `"text":{"body":"result"},`, func(m Message) bool { return m.Body() == "result" }},
+		{"image", "image", `"image":{"id":"media-1","mime_type":"image/jpeg","caption":"my result"},`, func(m Message) bool {
+			return m.Inbound("", "").MediaRef() == "media-1" && m.Body() == "my result" && m.Media.MimeType == "image/jpeg"
+		}},
+		{"sticker", "sticker", `"sticker":{"id":"media-2","animated":true},`, func(m Message) bool { return m.Media.Animated }},
+		{"location", "location", `"location":{"latitude":40.4,"longitude":49.8,"name":"Clinic"},`, func(m Message) bool {
+			return m.Location.Latitude == 40.4 && m.Location.Name == "Clinic"
+		}},
+		{"contacts", "contacts", `"contacts":[{"name":{"formatted_name":"John"},"phones":[{"phone":"+994501234567","wa_id":"994501234567"}]}],`, func(m Message) bool {
+			return len(m.Contacts) == 1 && m.Contacts[0].Phones[0].WaID == "994501234567"
+		}},
+		{"button", "button", `"button":{"payload":"result","text":"Results"},`, func(m Message) bool {
+			return m.Payload() == "result" && m.Body() == "Results"
+		}},
+		{"button reply", "interactive", `"interactive":{"type":"button_reply","button_reply":{"id":"yes","title":"Yes"}},`, func(m Message) bool {
+			return m.Payload() == "yes" && m.Interactive.Type == "button_reply"
+		}},
+		{"reaction", "reaction", `"reaction":{"message_id":"wamid.1","emoji":"👍"},`, func(m Message) bool { return m.Reaction.Emoji == "👍" }},
+		{"order", "order", `"order":{"catalog_id":"c1","product_items":[{"product_retailer_id":"p1","quantity":2}]},`, func(m Message) bool {
+			return m.Order.ProductItems[0].Quantity == 2
+		}},
+		{"system", "system", `"system":{"body":"User changed number","type":"user_changed_number","new_wa_id":"994509999999"},`, func(m Message) bool {
+			return m.System.NewWaID == "994509999999"
+		}},
+		{"unsupported", "unsupported", `"errors":[{"code":131051,"title":"Message type unknown"}],`, func(m Message) bool {
+			return m.Type == MessageTypeUnsupported && len(m.Errors) == 1 && m.Errors[0].Code == 131051
+		}},
+		{"reply", "text", `"context":{"from":"15550909792","id":"wamid.1"},"text":{"body":"thanks"},`, func(m Message) bool {
+			return m.ReplyTo() == "wamid.1" && m.Body() == "thanks"
+		}},
+		{"forwarded", "text", `"context":{"forwarded":true},"text":{"body":"result"},`, func(m Message) bool {
+			return m.Context.Forwarded && m.ReplyTo() == ""
+		}},
+		{"unknown", "ephemeral", ``, func(m Message) bool { return m.Type == MessageTypeUnsupported }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			err := json.Unmarshal(newMessage("994503981865", "wamid.test", tt.messageType, tt.content), &data)
+			if err != nil {
+				t.Fatalf("error decoding message: %v", err)
+			}
+
+			md := &MessengerData{}
+			err = md.Preprocess(data)
+			if err != nil {
+				t.Fatalf("error preprocessing message: %v", err)
+			}
+
+			message, err := md.GetMessage()
+			if err != nil {
+				t.Fatalf("error getting message: %v", err)
+			}
+			if message.ID != "wamid.test" || message.Timestamp.Unix() != testTimestamp {
+				t.Errorf("unexpected message %+v", message)
+			}
+			if !tt.check(message) {
+				t.Errorf("unexpected %s message %+v", tt.messageType, message)
+			}
+		})
+	}
+}
+
+func TestParseMessage_Unsupported(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newMessage("994503981865", "wamid.sticker", "sticker", `"sticker":{"id":"media-2"},`))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	if len(mc.texts) != 1 || !strings.Contains(mc.texts[0].text, "yalnız mətn mesajlarını") {
+		t.Errorf("expected the unsupported message text, got %+v", mc.texts)
+	}
+}
+
+func TestParseMessage_Reaction(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newMessage("994503981865", "wamid.reaction", "reaction", `"reaction":{"message_id":"wamid.1","emoji":"👍"},`))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	if len(mc.texts) != 0 || len(mc.documents) != 0 {
+		t.Errorf("expected no answer to a reaction, got %+v %+v", mc.texts, mc.documents)
+	}
+}
+
+func TestParseMessage_MessageHandler(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	var locations []Location
+	c := NewController(mc, WithMessageHandler(MessageTypeLocation, func(message Message, event conversation.Event) error {
+		locations = append(locations, *message.Location)
+		return nil
+	}))
+	err := c.parsingMessage(newMessage("994503981865", "wamid.location", "location", `"location":{"latitude":40.4,"longitude":49.8},`))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	if len(locations) != 1 || locations[0].Longitude != 49.8 {
+		t.Errorf("expected the location to be handled, got %+v", locations)
+	}
+	if len(mc.texts) != 0 {
+		t.Errorf("expected no default answer, got %+v", mc.texts)
+	}
+}
+
+func TestParseMessage_MarkedAsRead(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "operator"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	err = c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.2", "When can I come?"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	err = c.parsingMessage(newMessage("994503981866", "wamid.3", "reaction", `"reaction":{"message_id":"wamid.text.1","emoji":"👍"},`))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	// The typing indicator marks the messages answered by the bot as read
+	if len(mc.read) != 1 || mc.read[0] != "wamid.3" {
+		t.Errorf("expected only the reaction to be marked as read, got %v", mc.read)
+	}
+	if len(mc.typing) != 1 || mc.typing[0] != "wamid.1" {
+		t.Errorf("expected the bot to type only before handing off, got %v", mc.typing)
+	}
+}
+
+func TestParseMessage_ReplyInThread(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithResultLookup(results.Stub{}))
+	err := c.parsingMessage(newTextMessage("994503981865", "T.A", "wamid.1", "help"))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	if len(mc.texts) != 1 || mc.texts[0].replyTo != "wamid.1" {
+		t.Errorf("expected the answer to quote wamid.1, got %+v", mc.texts)
+	}
+}
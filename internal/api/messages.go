@@ -0,0 +1,275 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// IdempotencyKeyHeader carries the key which makes a retried request send the message once
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// completeAttempts is how many times the message of a key is stored before giving up
+	completeAttempts = 3
+
+	// completeBackoff is the wait before storing the message of a key again, growing with each attempt
+	completeBackoff = 50 * time.Millisecond
+)
+
+// The types of the messages sent with PostMessage
+const (
+	OutboundTypeText     = "text"
+	OutboundTypeTemplate = "template"
+	OutboundTypeDocument = "document"
+)
+
+// OutboundRequest is the JSON body of PostMessage. From is the business
+// number to send from, the configured business number if empty.
+type OutboundRequest struct {
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	Type     string            `json:"type"`
+	Text     string            `json:"text,omitempty"`
+	Template *OutboundTemplate `json:"template,omitempty"`
+	Document *OutboundDocument `json:"document,omitempty"`
+}
+
+// OutboundTemplate is a template to send, the parameters fill the {{1}}, {{2}}, ...
+// placeholders of its body. The campaigns reject them, they take the parameters of each recipient.
+type OutboundTemplate struct {
+	Name       string   `json:"name"`
+	Language   string   `json:"language"`
+	Parameters []string `json:"parameters,omitempty"`
+}
+
+type OutboundDocument struct {
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
+}
+
+// OutboundResponse is the JSON body of the response of PostMessage
+type OutboundResponse struct {
+	MessageID string `json:"message_id"`
+}
+
+// validate checks the request has the fields of its type
+func (r OutboundRequest) validate() error {
+	if r.To == "" {
+		return errors.New("to is required")
+	}
+	if strings.Contains(r.To, ":") {
+		return errors.New("to must be a WhatsApp number")
+	}
+
+	switch r.Type {
+	case OutboundTypeText:
+		if r.Text == "" {
+			return errors.New("text is required")
+		}
+	case OutboundTypeTemplate:
+		if r.Template == nil || r.Template.Name == "" {
+			return errors.New("template name is required")
+		}
+	case OutboundTypeDocument:
+		if r.Document == nil || r.Document.URL == "" {
+			return errors.New("document url is required")
+		}
+	default:
+		return fmt.Errorf("unknown message type %q", r.Type)
+	}
+
+	return nil
+}
+
+// PostMessage sends a text, template or document message to a WhatsApp number
+// for the staff and the internal systems, and returns the ID WhatsApp gave it.
+// A request repeated with the same Idempotency-Key returns the first message
+// instead of sending it again.
+func (c *Controller) PostMessage(w http.ResponseWriter, r *http.Request) {
+	var request OutboundRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = request.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.From, err = c.senderNumber(request.From)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key != "" {
+		hash, err := requestHash(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		record, claimed, err := c.idempotency.Begin(key, hash, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			switch {
+			case record.RequestHash != hash:
+				http.Error(w, "idempotency key was used for another request", http.StatusUnprocessableEntity)
+			case !record.Done():
+				http.Error(w, "request with the idempotency key is in progress", http.StatusConflict)
+			default:
+				respondJSON(w, http.StatusOK, OutboundResponse{MessageID: record.MessageID})
+			}
+			return
+		}
+	}
+
+	messageID, err := c.sendOutbound(request)
+	if err != nil {
+		if key != "" {
+			c.releaseIdempotencyKey(key)
+		}
+
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrOptedOut) || errors.Is(err, ErrWindowClosed) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if key != "" {
+		c.completeIdempotencyKey(key, messageID)
+	}
+
+	respondJSON(w, http.StatusCreated, OutboundResponse{MessageID: messageID})
+}
+
+// completeIdempotencyKey stores the message sent for the key, retrying a failed store.
+// The message was sent, so a key which can't be completed stays in progress until
+// it expires: a retried request is refused instead of sending the message twice.
+func (c *Controller) completeIdempotencyKey(key, messageID string) {
+	var err error
+	for attempt := 1; attempt <= completeAttempts; attempt++ {
+		err = c.idempotency.Complete(key, messageID)
+		if err == nil {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * completeBackoff)
+	}
+
+	log.Printf("Error completing idempotency key %s of message %s, the key stays in progress: %v", key, messageID, err)
+}
+
+// releaseIdempotencyKey lets the key be used again by a retried request
+func (c *Controller) releaseIdempotencyKey(key string) {
+	err := c.idempotency.Release(key)
+	if err != nil {
+		log.Printf("Error releasing idempotency key %s: %v", key, err)
+	}
+}
+
+// requestHash identifies the request of an idempotency key. The decoded request
+// is hashed, so the formatting and the order of the JSON fields don't matter.
+func requestHash(request OutboundRequest) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// senderNumber returns the business number to send from, the first configured
+// number if from is empty. Other numbers than the configured ones are rejected.
+func (c *Controller) senderNumber(from string) (string, error) {
+	if len(c.businessNumbers) == 0 {
+		return "", errors.New("no business number is configured")
+	}
+	if from == "" {
+		return c.businessNumbers[0], nil
+	}
+
+	for _, number := range c.businessNumbers {
+		if number == from {
+			return from, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown business number %q", from)
+}
+
+// sendOutbound sends the message of the request and returns its WhatsApp message ID.
+// Unlike the answers of the flows, the texts and documents aren't replaced by the
+// window template when the window is closed, the caller chooses to send a template.
+func (c *Controller) sendOutbound(request OutboundRequest) (string, error) {
+	err := c.checkConsent(request.To, request.Type)
+	if err != nil {
+		return "", err
+	}
+
+	from, err := c.senderNumber(request.From)
+	if err != nil {
+		return "", err
+	}
+
+	if request.Type == OutboundTypeTemplate {
+		language := c.templateLanguage(request.To, request.Template.Language)
+		resp, err := c.messagingClientManager.SendMessage(from, request.To, request.Template.Name, language, whatsapp.TemplateParameters(request.Template.Parameters...))
+		if err != nil {
+			return "", err
+		}
+		return providerMessageID(resp)
+	}
+
+	err = c.checkWindow(request.To, request.Type)
+	if err != nil {
+		return "", err
+	}
+
+	var result map[string]interface{}
+	if request.Type == OutboundTypeDocument {
+		result, err = c.messagingClientManager.SendDocument(from, request.Document.URL, request.To, request.Document.Caption, true)
+	} else {
+		result, err = c.messagingClientManager.SendMessageText(from, request.Text, request.To)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	var resp whatsapp.SendMessageResponse
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return providerMessageID(resp)
+}
+
+// providerMessageID returns the ID WhatsApp gave the sent message
+func providerMessageID(resp whatsapp.SendMessageResponse) (string, error) {
+	if len(resp.Messages) == 0 {
+		return "", errors.New("whatsapp returned no message ID")
+	}
+
+	return resp.Messages[0].ID, nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/idempotency"
+)
+
+func postMessage(api http.Handler, token, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/messages", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestPostMessage_Text(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	store := contacts.NewMemoryStore()
+	store.Touch("994503981865", "T.A", time.Now())
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithContactStore(store))
+	api := NewAPI(c)
+
+	body := `{"to":"994503981865","type":"text","text":"Your sample was received"}`
+	rr := postMessage(api, "guess", "", body)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong key, got %d", rr.Code)
+	}
+
+	rr = postMessage(api, "lab-key", "order-1", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var response OutboundResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.MessageID != "wamid.text.1" {
+		t.Errorf("expected the provider message ID, got %+v", response)
+	}
+	if len(mc.texts) != 1 || mc.texts[0].from != "15550909792" || mc.texts[0].text != "Your sample was received" {
+		t.Errorf("unexpected texts %+v", mc.texts)
+	}
+
+	// The retry is formatted differently but is the same request
+	rr = postMessage(api, "lab-key", "order-1", `{"text": "Your sample was received", "type": "text", "to": "994503981865"}`)
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || response.MessageID != "wamid.text.1" || len(mc.texts) != 1 {
+		t.Errorf("expected the retry to return the first message, got %d %+v %+v", rr.Code, response, mc.texts)
+	}
+
+	rr = postMessage(api, "lab-key", "order-1", `{"to":"994503981865","type":"text","text":"Another text"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a reused key, got %d", rr.Code)
+	}
+
+	rr = postMessage(api, "lab-key", "", body)
+	if rr.Code != http.StatusCreated || len(mc.texts) != 2 {
+		t.Errorf("expected a second message without a key, got %d %+v", rr.Code, mc.texts)
+	}
+}
+
+func TestPostMessage_Template(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithContactStore(newLapsedContacts(t)))
+	api := NewAPI(c)
+
+	rr := postMessage(api, "lab-key", "", `{"to":"994503981865","type":"document","document":{"url":"https://example.com/api/v1/994503981865/document"}}`)
+	if rr.Code != http.StatusConflict || len(mc.documents) != 0 {
+		t.Errorf("expected 409 for a document outside the window, got %d %+v", rr.Code, mc.documents)
+	}
+
+	rr = postMessage(api, "lab-key", "", `{"to":"994503981865","type":"template","template":{"name":"results_ready"}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(mc.templates) != 1 || mc.templates[0].document != "results_ready" || mc.templates[0].text != "en" {
+		t.Errorf("expected the template in the language of the contact, got %+v", mc.templates)
+	}
+}
+
+func TestPostMessage_Rejected(t *testing.T) {
+	ledger := consent.NewMemoryLedger()
+	ledger.Record(consent.Entry{WaID: "994503981865", OptIn: contacts.OptInRevoked, At: time.Now()})
+	c := NewController(&fakeMessagingClient{}, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithConsentLedger(ledger))
+	api := NewAPI(c)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"opted out", `{"to":"994503981865","type":"template","template":{"name":"results_ready"}}`, http.StatusConflict},
+		{"missing text", `{"to":"994503981865","type":"text"}`, http.StatusBadRequest},
+		{"other channel", `{"to":"telegram:987654321","type":"text","text":"Hi"}`, http.StatusBadRequest},
+		{"unknown type", `{"to":"994503981865","type":"sticker"}`, http.StatusBadRequest},
+		{"unknown from", `{"from":"4917635163191","to":"994503981865","type":"template","template":{"name":"results_ready"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postMessage(api, "lab-key", "", tt.body)
+			if rr.Code != tt.status {
+				t.Errorf("expected %d, got %d %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+// failingCompleteStore fails to complete the idempotency keys
+type failingCompleteStore struct {
+	*idempotency.MemoryStore
+}
+
+func (s failingCompleteStore) Complete(key, messageID string) error {
+	return errors.New("database is locked")
+}
+
+func TestPostMessage_CompleteFailed(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	store := failingCompleteStore{idempotency.NewMemoryStore()}
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithIdempotencyStore(store))
+	api := NewAPI(c)
+
+	body := `{"to":"994503981865","type":"template","template":{"name":"results_ready"}}`
+	rr := postMessage(api, "lab-key", "order-1", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The message was sent, a retry must not send it again
+	rr = postMessage(api, "lab-key", "order-1", body)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 while the key is in progress, got %d", rr.Code)
+	}
+	if len(mc.templates) != 1 {
+		t.Errorf("expected the template to be sent once, got %+v", mc.templates)
+	}
+}
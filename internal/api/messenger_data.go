@@ -2,6 +2,9 @@ package api
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
 )
 
 type MessengerData struct {
@@ -39,15 +42,119 @@ func (md *MessengerData) ChangedField() string {
 	return fieldName
 }
 
-func (md *MessengerData) GetDelivery() (interface{}, error) {
-	if statusList, ok := md.preprocessedData["statuses"].([]interface{}); ok {
-		if len(statusList) > 0 {
-			deliveryStatus := statusList[0].(map[string]interface{})["status"]
-			return deliveryStatus, nil
+// WebhookError is an error Meta reports in a webhook, either for the
+// whole webhook or for a message which could not be delivered.
+// See https://developers.facebook.com/docs/whatsapp/cloud-api/support/error-codes
+type WebhookError struct {
+	Code    int    `json:"code"`
+	Title   string `json:"title"`
+	Message string `json:"message,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+func (e WebhookError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%d: %s (%s)", e.Code, e.Title, e.Details)
+	}
+
+	return fmt.Sprintf("%d: %s", e.Code, e.Title)
+}
+
+// GetErrors returns the errors reported for the whole webhook
+func (md *MessengerData) GetErrors() []WebhookError {
+	errorList, ok := md.preprocessedData["errors"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	return parseErrors(errorList)
+}
+
+func parseErrors(errorList []interface{}) []WebhookError {
+	var webhookErrors []WebhookError
+	for _, item := range errorList {
+		errorInfo, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		webhookError := WebhookError{}
+		code, _ := errorInfo["code"].(float64)
+		webhookError.Code = int(code)
+		webhookError.Title, _ = errorInfo["title"].(string)
+		webhookError.Message, _ = errorInfo["message"].(string)
+		if errorData, ok := errorInfo["error_data"].(map[string]interface{}); ok {
+			webhookError.Details, _ = errorData["details"].(string)
+		}
+
+		webhookErrors = append(webhookErrors, webhookError)
+	}
+
+	return webhookErrors
+}
+
+// Status is a status update of a message sent to a contact.
+// The conversation and pricing are only sent with some of the statuses.
+type Status struct {
+	ID          string
+	Status      string
+	RecipientID string
+	Timestamp   time.Time
+	Errors      []WebhookError
+
+	ConversationID string
+	OriginType     string
+	Category       string
+	PricingModel   string
+	Billable       bool
+}
+
+// GetStatuses returns the status updates of the webhook
+func (md *MessengerData) GetStatuses() ([]Status, error) {
+	statusList, ok := md.preprocessedData["statuses"].([]interface{})
+	if !ok {
+		return nil, errors.New("statuses not found in data")
+	}
+
+	var statuses []Status
+	for _, item := range statusList {
+		statusInfo, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid status in statuses")
 		}
+
+		status := Status{}
+		status.ID, _ = statusInfo["id"].(string)
+		status.Status, _ = statusInfo["status"].(string)
+		status.RecipientID, _ = statusInfo["recipient_id"].(string)
+
+		timestamp, _ := statusInfo["timestamp"].(string)
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err == nil {
+			status.Timestamp = time.Unix(seconds, 0)
+		}
+
+		if conversationInfo, ok := statusInfo["conversation"].(map[string]interface{}); ok {
+			status.ConversationID, _ = conversationInfo["id"].(string)
+			if originInfo, ok := conversationInfo["origin"].(map[string]interface{}); ok {
+				status.OriginType, _ = originInfo["type"].(string)
+			}
+		}
+
+		if pricingInfo, ok := statusInfo["pricing"].(map[string]interface{}); ok {
+			status.Billable, _ = pricingInfo["billable"].(bool)
+			status.Category, _ = pricingInfo["category"].(string)
+			status.PricingModel, _ = pricingInfo["pricing_model"].(string)
+		}
+
+		if errorList, ok := statusInfo["errors"].([]interface{}); ok {
+			status.Errors = parseErrors(errorList)
+		}
+
+		statuses = append(statuses, status)
 	}
 
-	return nil, nil
+	return statuses, nil
 }
 
 func (md *MessengerData) IsMessage() bool {
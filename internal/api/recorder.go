@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// summaryLength is the length the texts are truncated to in the message log
+const summaryLength = 200
+
+// OutboundLog stores the messages sent to the contacts
+type OutboundLog interface {
+	SaveOutbound(record messagelog.OutboundRecord) error
+}
+
+// SentRecorder records the messages sent by the WhatsApp client in the message log
+type SentRecorder struct {
+	log OutboundLog
+}
+
+func NewSentRecorder(log OutboundLog) *SentRecorder {
+	return &SentRecorder{log: log}
+}
+
+// RecordSent stores the sent message with a summary of its body
+func (r *SentRecorder) RecordSent(message whatsapp.SentMessage) error {
+	summary := message.Body
+	if runes := []rune(summary); len(runes) > summaryLength {
+		summary = string(runes[:summaryLength])
+	}
+
+	record := messagelog.OutboundRecord{
+		ProviderID: message.MessageID,
+		From:       message.From,
+		To:         message.To,
+		Type:       message.Type,
+		Summary:    summary,
+		Body:       message.Body,
+		Status:     messagelog.StatusSent,
+		Error:      message.Error,
+	}
+	if message.Error != "" {
+		record.Status = messagelog.StatusFailed
+	}
+
+	return r.log.SaveOutbound(record)
+}
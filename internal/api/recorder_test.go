@@ -0,0 +1,47 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagelog"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+func TestSentRecorder_RecordSent(t *testing.T) {
+	ml := newTestMessageLog(t)
+	recorder := NewSentRecorder(ml)
+
+	long := strings.Repeat("ə", summaryLength+10)
+	err := recorder.RecordSent(whatsapp.SentMessage{MessageID: "wamid.1", From: "15550909792", To: "994503981865", Type: whatsapp.MessageTypeText, Body: long})
+	if err != nil {
+		t.Fatalf("error recording sent message: %v", err)
+	}
+	err = recorder.RecordSent(whatsapp.SentMessage{From: "15550909792", To: "994503981865", Type: whatsapp.MessageTypeText, Body: "Hello", Error: "whatsapp: 100: Invalid parameter"})
+	if err != nil {
+		t.Fatalf("error recording failed message: %v", err)
+	}
+
+	records, err := ml.OutboundTo("994503981865", whatsapp.MessageTypeText)
+	if err != nil {
+		t.Fatalf("error loading outbound messages: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+
+	var sent, failed messagelog.OutboundRecord
+	for _, record := range records {
+		if record.ProviderID == "wamid.1" {
+			sent = record
+		} else {
+			failed = record
+		}
+	}
+	if sent.Status != messagelog.StatusSent || len([]rune(sent.Summary)) != summaryLength || sent.Body != long {
+		t.Errorf("expected the summary of the sent message to be shortened and the body to be kept, got %+v", sent)
+	}
+	if failed.Status != messagelog.StatusFailed || failed.Error != "whatsapp: 100: Invalid parameter" || failed.Summary != "Hello" {
+		t.Errorf("unexpected failed record: %+v", failed)
+	}
+}
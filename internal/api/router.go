@@ -18,8 +18,31 @@ func NewAPI(apiController Controller) http.Handler {
 	router.HandleFunc("/api/v1/health", apiController.HealthCheck).Methods(http.MethodGet)
 	router.HandleFunc("/api/v1/hook", apiController.ReceiveMessage).Methods(http.MethodPost)
 	router.HandleFunc("/api/v1/hook", apiController.VerifyToken).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/channels/{channel}/hook", apiController.ReceiveUpdate).Methods(http.MethodPost)
 	router.HandleFunc("/api/v1/{number}/document", apiController.UploadDocument).Methods(http.MethodPost)
 	router.HandleFunc("/api/v1/{number}/document", apiController.GetDocument).Methods(http.MethodGet)
+
+	// Endpoints of the staff and the internal systems require an API key
+	authenticated := router.NewRoute().Subrouter()
+	authenticated.Use(apiController.requireAPIKey)
+	authenticated.HandleFunc("/api/v1/messages", apiController.PostMessage).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/scheduled-messages", apiController.ScheduleMessage).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/scheduled-messages", apiController.ListScheduledMessages).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/scheduled-messages/{id}", apiController.GetScheduledMessage).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/scheduled-messages/{id}/cancel", apiController.CancelScheduledMessage).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/scheduled-messages/{id}/reschedule", apiController.RescheduleMessage).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/campaigns", apiController.CreateCampaign).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/campaigns/{id}", apiController.GetCampaign).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/campaigns/{id}/recipients", apiController.ListCampaignRecipients).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/campaigns/{id}/pause", apiController.PauseCampaign).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/campaigns/{id}/resume", apiController.ResumeCampaign).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/campaigns/{id}/cancel", apiController.CancelCampaign).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/{number}/delivery", apiController.GetDelivery).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/reports/billing", apiController.GetBillingReport).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/handoffs", apiController.ListHandoffs).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/handoffs/{number}", apiController.GetHandoff).Methods(http.MethodGet)
+	authenticated.HandleFunc("/api/v1/handoffs/{number}/reply", apiController.ReplyHandoff).Methods(http.MethodPost)
+	authenticated.HandleFunc("/api/v1/handoffs/{number}/close", apiController.CloseHandoff).Methods(http.MethodPost)
 	// Add rate limiting middleware to all endpoints
 	return router
 }
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/messaging-integration-service/internal/schedule"
+)
+
+const (
+	// scheduleBatchSize is the number of due messages fired at each tick of the scheduler
+	scheduleBatchSize = 100
+
+	// scheduleListLimit is the most messages ListScheduledMessages returns
+	scheduleListLimit = 500
+
+	// scheduleClaimTimeout is how long a message may stay sending before its
+	// outcome is given up on, the send of a message takes seconds
+	scheduleClaimTimeout = 10 * time.Minute
+)
+
+// ScheduleRequest is the JSON body of ScheduleMessage, a message of PostMessage
+// with the time to send it at
+type ScheduleRequest struct {
+	OutboundRequest
+	SendAt time.Time `json:"send_at"`
+}
+
+// RescheduleRequest is the JSON body of RescheduleMessage
+type RescheduleRequest struct {
+	SendAt time.Time `json:"send_at"`
+}
+
+// ScheduleMessage schedules a text, template or document message, e.g. a reminder
+// to collect the originals, to be sent at a time. Texts and documents are only
+// scheduled within the service window of the contact.
+func (c *Controller) ScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	if c.schedule == nil {
+		http.Error(w, "scheduled messages are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request ScheduleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = request.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.SendAt.IsZero() {
+		http.Error(w, "send_at is required", http.StatusBadRequest)
+		return
+	}
+	_, err = c.senderNumber(request.From)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = c.checkScheduledWindow(request.OutboundRequest, request.SendAt)
+	if !respondWindowError(w, err) {
+		return
+	}
+
+	message, err := json.Marshal(request.OutboundRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := c.schedule.Create(schedule.Job{
+		To:      request.To,
+		Message: message,
+		SendAt:  request.SendAt,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, job)
+}
+
+// GetScheduledMessage returns the scheduled message with its status
+func (c *Controller) GetScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	if c.schedule == nil {
+		http.Error(w, "scheduled messages are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	job, err := c.schedule.Get(mux.Vars(r)["id"])
+	respondJob(w, job, err)
+}
+
+// ListScheduledMessages returns the scheduled messages with the status of the
+// status query parameter, e.g. the unknown messages to check with the contact
+func (c *Controller) ListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	if c.schedule == nil {
+		http.Error(w, "scheduled messages are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		http.Error(w, "status is required", http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := c.schedule.List(status, scheduleListLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []schedule.Job{}
+	}
+
+	respondJSON(w, http.StatusOK, jobs)
+}
+
+// CancelScheduledMessage cancels a message which wasn't sent yet
+func (c *Controller) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	if c.schedule == nil {
+		http.Error(w, "scheduled messages are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	job, err := c.schedule.Cancel(mux.Vars(r)["id"])
+	respondJob(w, job, err)
+}
+
+// RescheduleMessage moves a message which wasn't sent yet to another time
+func (c *Controller) RescheduleMessage(w http.ResponseWriter, r *http.Request) {
+	if c.schedule == nil {
+		http.Error(w, "scheduled messages are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request RescheduleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.SendAt.IsZero() {
+		http.Error(w, "send_at is required", http.StatusBadRequest)
+		return
+	}
+
+	job, err := c.schedule.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondJob(w, job, err)
+		return
+	}
+	var message OutboundRequest
+	err = json.Unmarshal(job.Message, &message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = c.checkScheduledWindow(message, request.SendAt)
+	if !respondWindowError(w, err) {
+		return
+	}
+
+	job, err = c.schedule.Reschedule(job.ID, request.SendAt)
+	respondJob(w, job, err)
+}
+
+// checkScheduledWindow returns ErrWindowClosed unless the message can be sent at the time.
+// Only templates may be sent outside the service window, so a text or document is
+// only scheduled within the window of the contact. The window only grows as the
+// contact writes, a message fitting in it now still fits when it is sent.
+func (c *Controller) checkScheduledWindow(request OutboundRequest, sendAt time.Time) error {
+	if request.Type == OutboundTypeTemplate {
+		return nil
+	}
+
+	contact, ok, err := c.contacts.Get(request.To)
+	if err != nil {
+		return err
+	}
+	if !ok || !contact.WindowOpen(sendAt) {
+		return fmt.Errorf("%s message to %s at %s: %w, schedule a template instead", request.Type, request.To, sendAt.Format(time.RFC3339), ErrWindowClosed)
+	}
+
+	return nil
+}
+
+// respondWindowError writes the error of checkScheduledWindow, it returns
+// false when the request is answered
+func respondWindowError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrWindowClosed):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		return true
+	}
+
+	return false
+}
+
+// respondJob writes the job, or the status of the error of the job store
+func respondJob(w http.ResponseWriter, job schedule.Job, err error) {
+	switch {
+	case errors.Is(err, schedule.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, schedule.ErrNotScheduled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		respondJSON(w, http.StatusOK, job)
+	}
+}
+
+// RunScheduler sends the due scheduled messages every interval until stop is closed
+func (c *Controller) RunScheduler(interval time.Duration, stop <-chan struct{}) {
+	if c.schedule == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := c.SendScheduled(now)
+			if err != nil {
+				log.Printf("Error sending scheduled messages: %v", err)
+			}
+		}
+	}
+}
+
+// SendScheduled sends the messages due at now. The recipients who opted out
+// since the message was scheduled are skipped. The messages left sending by
+// a crash or a failed update are expired to unknown first.
+func (c *Controller) SendScheduled(now time.Time) error {
+	if c.schedule == nil {
+		return nil
+	}
+
+	expired, err := c.schedule.ExpireClaims(now.Add(-scheduleClaimTimeout))
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("Expired %d scheduled messages left sending, they may have been sent", expired)
+	}
+
+	jobs, err := c.schedule.Due(now, scheduleBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		claimed, err := c.schedule.Claim(job.ID, now)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		status, messageID, errorText := c.fire(job)
+		err = c.schedule.Finish(job.ID, status, messageID, errorText, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fire sends the message of the job and returns the status of the job,
+// the ID of the sent message and the error of a failed send
+func (c *Controller) fire(job schedule.Job) (string, string, string) {
+	var request OutboundRequest
+	err := json.Unmarshal(job.Message, &request)
+	if err != nil {
+		return schedule.StatusFailed, "", err.Error()
+	}
+
+	messageID, err := c.sendOutbound(request)
+	if errors.Is(err, ErrOptedOut) {
+		log.Printf("Skipped scheduled message %s: %v", job.ID, err)
+		return schedule.StatusSkipped, "", err.Error()
+	}
+	if err != nil {
+		log.Printf("Error sending scheduled message %s: %v", job.ID, err)
+		return schedule.StatusFailed, "", err.Error()
+	}
+
+	return schedule.StatusSent, messageID, ""
+}
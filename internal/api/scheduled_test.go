@@ -0,0 +1,270 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/schedule"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func newTestScheduler(t *testing.T) *schedule.Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "schedule.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := schedule.NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating schedule repository: %v", err)
+	}
+
+	return repository
+}
+
+func scheduleRequest(api http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lab-key")
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestScheduledMessage_Send(t *testing.T) {
+	ledger := consent.NewMemoryLedger()
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithConsentLedger(ledger), WithScheduler(newTestScheduler(t)))
+	api := NewAPI(c)
+
+	sendAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	body := fmt.Sprintf(`{"to":"994503981865","type":"template","template":{"name":"collect_originals","language":"en","parameters":["T.A"]},"send_at":%q}`, sendAt.Format(time.RFC3339))
+	rr := scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var reminder schedule.Job
+	json.NewDecoder(rr.Body).Decode(&reminder)
+
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981866","type":"template","template":{"name":"follow_up"},"send_at":%q}`, sendAt.Format(time.RFC3339)))
+	var optedOut schedule.Job
+	json.NewDecoder(rr.Body).Decode(&optedOut)
+	ledger.Record(consent.Entry{WaID: "994503981866", OptIn: contacts.OptInRevoked, At: time.Now()})
+
+	err := c.SendScheduled(time.Now())
+	if err != nil || len(mc.templates) != 0 {
+		t.Fatalf("expected nothing sent before the time, got %+v %v", mc.templates, err)
+	}
+
+	err = c.SendScheduled(sendAt)
+	if err != nil {
+		t.Fatalf("error sending scheduled messages: %v", err)
+	}
+	if len(mc.templates) != 1 || mc.templates[0].document != "collect_originals" || mc.templates[0].parameters[0] != "T.A" {
+		t.Errorf("expected the reminder, got %+v", mc.templates)
+	}
+
+	rr = scheduleRequest(api, http.MethodGet, "/api/v1/scheduled-messages/"+reminder.ID, "")
+	json.NewDecoder(rr.Body).Decode(&reminder)
+	if reminder.Status != schedule.StatusSent || reminder.MessageID != "wamid.template.1" {
+		t.Errorf("unexpected sent reminder %+v", reminder)
+	}
+
+	rr = scheduleRequest(api, http.MethodGet, "/api/v1/scheduled-messages/"+optedOut.ID, "")
+	json.NewDecoder(rr.Body).Decode(&optedOut)
+	if optedOut.Status != schedule.StatusSkipped {
+		t.Errorf("expected the opted out recipient to be skipped, got %+v", optedOut)
+	}
+
+	err = c.SendScheduled(sendAt.Add(time.Hour))
+	if err != nil || len(mc.templates) != 1 {
+		t.Errorf("expected the reminder to be sent once, got %+v %v", mc.templates, err)
+	}
+}
+
+func TestScheduledMessage_Control(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithScheduler(newTestScheduler(t)))
+	api := NewAPI(c)
+
+	sendAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rr := scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"template","template":{"name":"follow_up"},"send_at":%q}`, sendAt.Format(time.RFC3339)))
+	var job schedule.Job
+	json.NewDecoder(rr.Body).Decode(&job)
+
+	later := sendAt.Add(24 * time.Hour)
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages/"+job.ID+"/reschedule", fmt.Sprintf(`{"send_at":%q}`, later.Format(time.RFC3339)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	c.SendScheduled(sendAt)
+	if len(mc.templates) != 0 {
+		t.Errorf("expected the rescheduled message not to be sent, got %+v", mc.templates)
+	}
+
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages/"+job.ID+"/cancel", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	c.SendScheduled(later)
+	if len(mc.templates) != 0 {
+		t.Errorf("expected the cancelled message not to be sent, got %+v", mc.templates)
+	}
+
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages/"+job.ID+"/reschedule", fmt.Sprintf(`{"send_at":%q}`, later.Format(time.RFC3339)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 rescheduling a cancelled message, got %d", rr.Code)
+	}
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages/unknown/cancel", "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", `{"to":"994503981865","type":"text","text":"Hi"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without send_at, got %d", rr.Code)
+	}
+}
+
+func TestScheduledMessage_Window(t *testing.T) {
+	store := contacts.NewMemoryStore()
+	lastSeen := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	store.Touch("994503981865", "T.A", lastSeen)
+	c := NewController(&fakeMessagingClient{}, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithContactStore(store), WithScheduler(newTestScheduler(t)))
+	api := NewAPI(c)
+
+	inWindow := lastSeen.Add(2 * time.Hour)
+	rr := scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"text","text":"Your originals are ready","send_at":%q}`, inWindow.Format(time.RFC3339)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a text within the window, got %d %s", rr.Code, rr.Body.String())
+	}
+	var job schedule.Job
+	json.NewDecoder(rr.Body).Decode(&job)
+
+	afterWindow := lastSeen.Add(contacts.ServiceWindow + time.Hour)
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"text","text":"Your originals are ready","send_at":%q}`, afterWindow.Format(time.RFC3339)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a text after the window, got %d", rr.Code)
+	}
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981866","type":"document","document":{"url":"https://example.com/a.pdf"},"send_at":%q}`, inWindow.Format(time.RFC3339)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a document to a contact who never wrote, got %d", rr.Code)
+	}
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"template","template":{"name":"collect_originals"},"send_at":%q}`, afterWindow.Format(time.RFC3339)))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected 201 for a template after the window, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages/"+job.ID+"/reschedule", fmt.Sprintf(`{"send_at":%q}`, afterWindow.Format(time.RFC3339)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 rescheduling a text after the window, got %d", rr.Code)
+	}
+	rr = scheduleRequest(api, http.MethodGet, "/api/v1/scheduled-messages/"+job.ID, "")
+	json.NewDecoder(rr.Body).Decode(&job)
+	if !job.SendAt.Equal(inWindow) {
+		t.Errorf("expected the text to keep its time, got %+v", job)
+	}
+}
+
+func TestScheduledMessage_OptOutAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.db")
+	open := func() (*sql.DB, *consent.Repository, *schedule.Repository) {
+		db, err := storage.Open(path)
+		if err != nil {
+			t.Fatalf("error opening database: %v", err)
+		}
+		ledger, err := consent.NewRepository(db)
+		if err != nil {
+			t.Fatalf("error creating consent ledger: %v", err)
+		}
+		scheduler, err := schedule.NewRepository(db)
+		if err != nil {
+			t.Fatalf("error creating schedule repository: %v", err)
+		}
+		return db, ledger, scheduler
+	}
+
+	db, ledger, scheduler := open()
+	c := NewController(&fakeMessagingClient{}, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithConsentLedger(ledger), WithScheduler(scheduler))
+	sendAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rr := scheduleRequest(NewAPI(c), http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"template","template":{"name":"follow_up"},"send_at":%q}`, sendAt.Format(time.RFC3339)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var job schedule.Job
+	json.NewDecoder(rr.Body).Decode(&job)
+
+	err := ledger.Record(consent.Entry{WaID: "994503981865", OptIn: contacts.OptInRevoked, MessageID: "wamid.stop", At: time.Now()})
+	if err != nil {
+		t.Fatalf("error recording the opt-out: %v", err)
+	}
+	db.Close()
+
+	// The service restarts before the message is due
+	db, ledger, scheduler = open()
+	defer db.Close()
+	mc := &fakeMessagingClient{}
+	c = NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithConsentLedger(ledger), WithScheduler(scheduler))
+
+	err = c.SendScheduled(sendAt)
+	if err != nil {
+		t.Fatalf("error sending scheduled messages: %v", err)
+	}
+	if len(mc.templates) != 0 {
+		t.Errorf("expected nothing sent to the opted out recipient, got %+v", mc.templates)
+	}
+
+	job, err = scheduler.Get(job.ID)
+	if err != nil || job.Status != schedule.StatusSkipped {
+		t.Errorf("expected the message to be skipped, got %+v %v", job, err)
+	}
+}
+
+func TestScheduledMessage_ExpiredClaim(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	scheduler := newTestScheduler(t)
+	c := NewController(mc, WithAPIKeys("lab-key"), WithBusinessNumbers("15550909792"), WithScheduler(scheduler))
+	api := NewAPI(c)
+
+	sendAt := time.Now().UTC().Truncate(time.Second)
+	rr := scheduleRequest(api, http.MethodPost, "/api/v1/scheduled-messages", fmt.Sprintf(`{"to":"994503981865","type":"template","template":{"name":"follow_up"},"send_at":%q}`, sendAt.Format(time.RFC3339)))
+	var job schedule.Job
+	json.NewDecoder(rr.Body).Decode(&job)
+
+	// The process crashed while sending the message
+	claimed, err := scheduler.Claim(job.ID, sendAt)
+	if err != nil || !claimed {
+		t.Fatalf("expected the job to be claimed, got %v %v", claimed, err)
+	}
+
+	err = c.SendScheduled(sendAt.Add(scheduleClaimTimeout + time.Minute))
+	if err != nil {
+		t.Fatalf("error sending scheduled messages: %v", err)
+	}
+	if len(mc.templates) != 0 {
+		t.Errorf("expected the message not to be sent again, got %+v", mc.templates)
+	}
+
+	rr = scheduleRequest(api, http.MethodGet, "/api/v1/scheduled-messages?status=unknown", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var unknown []schedule.Job
+	json.NewDecoder(rr.Body).Decode(&unknown)
+	if len(unknown) != 1 || unknown[0].ID != job.ID || unknown[0].Status != schedule.StatusUnknown {
+		t.Errorf("expected the expired message to be reported, got %+v", unknown)
+	}
+
+	rr = scheduleRequest(api, http.MethodGet, "/api/v1/scheduled-messages", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a status, got %d", rr.Code)
+	}
+}
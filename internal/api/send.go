@@ -0,0 +1,384 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+var (
+	// ErrOptedOut is returned for the messages to contacts who opted out
+	ErrOptedOut = errors.New("recipient opted out")
+	// ErrWindowClosed is returned for the messages to contacts who haven't written
+	// in the last 24 hours, the message isn't sent
+	ErrWindowClosed = errors.New("customer service window is closed")
+)
+
+// windowTemplateSent is the ErrWindowClosed error of a message
+// which was replaced by the window template
+type windowTemplateSent struct {
+	template    string
+	messageType string
+	recipientID string
+}
+
+func (e *windowTemplateSent) Error() string {
+	return fmt.Sprintf("%s message to %s: %v, sent template %s instead", e.messageType, e.recipientID, ErrWindowClosed, e.template)
+}
+
+func (e *windowTemplateSent) Unwrap() error {
+	return ErrWindowClosed
+}
+
+// The send helpers check the consent of the recipient before sending.
+// Contacts of the other channels are answered through the provider of
+// their channel, lists are sent to them as reply buttons and locations
+// and contact cards as texts.
+// Messages to contacts who opted out fail with ErrOptedOut.
+// Messages to contacts whose customer service window is closed fail with
+// ErrWindowClosed, the window template is sent instead when it is configured.
+// The flows answer with whatsapp.ReplyTo so the answer quotes the question.
+
+func (c *Controller) sendText(from, message, recipientID string, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, "text")
+	if err != nil {
+		return err
+	}
+
+	return c.deliverText(from, message, recipientID, opts...)
+}
+
+// deliverText sends the text without checking the consent of the recipient,
+// only the confirmation of an opt-out is sent to a contact who opted out
+func (c *Controller) deliverText(from, message, recipientID string, opts ...whatsapp.SendOption) error {
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return provider.Send(channel.OutboundMessage{
+			RecipientID: chatID,
+			Type:        channel.MessageTypeText,
+			Text:        message,
+			ReplyTo:     quotedMessage(opts),
+		})
+	}
+
+	if !c.windowOpen(recipientID) {
+		return c.sendWindowTemplate(from, recipientID, "text", message)
+	}
+
+	_, err = c.messagingClientManager.SendMessageText(from, message, recipientID, opts...)
+	return err
+}
+
+func (c *Controller) sendDocument(from, document, recipientID, caption string, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, "document")
+	if err != nil {
+		return err
+	}
+
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return provider.Send(channel.OutboundMessage{
+			RecipientID: chatID,
+			Type:        channel.MessageTypeDocument,
+			Text:        caption,
+			DocumentURL: document,
+			ReplyTo:     quotedMessage(opts),
+		})
+	}
+
+	if !c.windowOpen(recipientID) {
+		return c.sendWindowTemplate(from, recipientID, "document", document)
+	}
+
+	_, err = c.messagingClientManager.SendDocument(from, document, recipientID, caption, true, opts...)
+	return err
+}
+
+func (c *Controller) sendList(from, recipientID, body, button string, sections []whatsapp.ListSection, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, "list")
+	if err != nil {
+		return err
+	}
+
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return provider.Send(channel.OutboundMessage{
+			RecipientID: chatID,
+			Type:        channel.MessageTypeKeyboard,
+			Text:        body,
+			Buttons:     listButtons(sections),
+			ReplyTo:     quotedMessage(opts),
+		})
+	}
+
+	if !c.windowOpen(recipientID) {
+		return c.sendWindowTemplate(from, recipientID, "list", body)
+	}
+
+	_, err = c.messagingClientManager.SendInteractiveList(from, recipientID, body, button, sections, opts...)
+	return err
+}
+
+// sendLocation sends a pin on the map, e.g. the address of a branch. The other
+// channels get the name, the address and a map link of the location as a text.
+func (c *Controller) sendLocation(from, recipientID string, location whatsapp.Location, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, whatsapp.MessageTypeLocation)
+	if err != nil {
+		return err
+	}
+
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return provider.Send(channel.OutboundMessage{
+			RecipientID: chatID,
+			Type:        channel.MessageTypeText,
+			Text:        locationText(location),
+			ReplyTo:     quotedMessage(opts),
+		})
+	}
+
+	if !c.windowOpen(recipientID) {
+		return c.sendWindowTemplate(from, recipientID, whatsapp.MessageTypeLocation, locationText(location))
+	}
+
+	_, err = c.messagingClientManager.SendLocation(from, recipientID, location, opts...)
+	return err
+}
+
+// sendContacts sends contact cards, e.g. the phone numbers of the staff. The
+// other channels get the names and the phone numbers of the cards as a text.
+func (c *Controller) sendContacts(from, recipientID string, cards []whatsapp.Contact, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, whatsapp.MessageTypeContacts)
+	if err != nil {
+		return err
+	}
+
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return provider.Send(channel.OutboundMessage{
+			RecipientID: chatID,
+			Type:        channel.MessageTypeText,
+			Text:        contactsText(cards),
+			ReplyTo:     quotedMessage(opts),
+		})
+	}
+
+	if !c.windowOpen(recipientID) {
+		return c.sendWindowTemplate(from, recipientID, whatsapp.MessageTypeContacts, contactsText(cards))
+	}
+
+	_, err = c.messagingClientManager.SendContacts(from, recipientID, cards, opts...)
+	return err
+}
+
+// sendReaction reacts with the emoji to a message of the contact. The other
+// channels have no reactions, nothing is sent to their contacts. A reaction
+// isn't worth the window template, it fails with ErrWindowClosed.
+func (c *Controller) sendReaction(from, recipientID, messageID, emoji string) error {
+	err := c.checkConsent(recipientID, whatsapp.MessageTypeReaction)
+	if err != nil {
+		return err
+	}
+
+	if name, _ := channel.Split(recipientID); name != channel.WhatsApp {
+		return nil
+	}
+
+	err = c.checkWindow(recipientID, whatsapp.MessageTypeReaction)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.messagingClientManager.SendReaction(from, recipientID, messageID, emoji)
+	return err
+}
+
+// sendPhoneRequest asks the contact of another channel to share their phone number,
+// the button is the label of the channel's share button
+func (c *Controller) sendPhoneRequest(from, recipientID, text, button string, opts ...whatsapp.SendOption) error {
+	err := c.checkConsent(recipientID, channel.MessageTypePhoneRequest)
+	if err != nil {
+		return err
+	}
+
+	provider, chatID, err := c.provider(recipientID)
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		return fmt.Errorf("WhatsApp contact %s: %w", recipientID, channel.ErrPhoneRequestUnsupported)
+	}
+
+	return provider.Send(channel.OutboundMessage{
+		RecipientID: chatID,
+		Type:        channel.MessageTypePhoneRequest,
+		Text:        text,
+		Buttons:     []channel.Button{{ID: channel.MessageTypePhoneRequest, Title: button}},
+		ReplyTo:     quotedMessage(opts),
+	})
+}
+
+// checkConsent returns ErrOptedOut unless the recipient may receive messages.
+// Contacts which never opted out are allowed since they wrote to us first.
+func (c *Controller) checkConsent(recipientID, messageType string) error {
+	status, err := c.consents.Status(recipientID)
+	if err != nil {
+		return fmt.Errorf("error loading consent of %s, %s message not sent: %w", recipientID, messageType, err)
+	}
+
+	if status == contacts.OptInRevoked {
+		return fmt.Errorf("%s message to %s: %w", messageType, recipientID, ErrOptedOut)
+	}
+
+	return nil
+}
+
+// provider returns the provider of the channel of the recipient and the chat ID
+// of the recipient on it, the provider is nil for the WhatsApp contacts
+func (c *Controller) provider(recipientID string) (channel.Provider, string, error) {
+	name, chatID := channel.Split(recipientID)
+	if name == channel.WhatsApp {
+		return nil, chatID, nil
+	}
+
+	provider, err := c.channels.Get(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return provider, chatID, nil
+}
+
+// quotedMessage returns the ID of the message the options reply to
+func quotedMessage(opts []whatsapp.SendOption) string {
+	options := whatsapp.NewSendOptions(opts...)
+	if options.Context == nil {
+		return ""
+	}
+
+	return options.Context.MessageID
+}
+
+// locationText returns the location as a text with a map link
+func locationText(location whatsapp.Location) string {
+	var lines []string
+	for _, line := range []string{location.Name, location.Address} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, fmt.Sprintf("https://maps.google.com/?q=%f,%f", location.Latitude, location.Longitude))
+
+	return strings.Join(lines, "\n")
+}
+
+// contactsText returns a line with the name and the phone numbers of each card
+func contactsText(cards []whatsapp.Contact) string {
+	var lines []string
+	for _, card := range cards {
+		line := card.Name.FormattedName
+		for _, phone := range card.Phones {
+			line += " " + phone.Phone
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// listButtons returns the rows of the list as reply buttons
+func listButtons(sections []whatsapp.ListSection) []channel.Button {
+	var buttons []channel.Button
+	for _, section := range sections {
+		for _, row := range section.Rows {
+			buttons = append(buttons, channel.Button{ID: row.ID, Title: row.Title})
+		}
+	}
+
+	return buttons
+}
+
+// checkWindow returns ErrWindowClosed unless free-form messages may be sent to
+// the recipient, for the messages which aren't replaced by the window template
+func (c *Controller) checkWindow(recipientID, messageType string) error {
+	if !c.windowOpen(recipientID) {
+		return fmt.Errorf("%s message to %s: %w", messageType, recipientID, ErrWindowClosed)
+	}
+
+	return nil
+}
+
+// windowOpen tells whether free-form messages may be sent to the recipient
+func (c *Controller) windowOpen(recipientID string) bool {
+	contact, ok, err := c.contacts.Get(recipientID)
+	if err != nil {
+		log.Printf("Error loading contact %s: %v", recipientID, err)
+		return false
+	}
+
+	return ok && contact.WindowOpen(time.Now())
+}
+
+// sendWindowTemplate sends the window template instead of the message,
+// the template asks the contact to write so the window opens again.
+// The message isn't sent, so the error is ErrWindowClosed even when the
+// template is sent. The template keeps the body of the message in the
+// message log, so the fallback channel can send the message itself.
+func (c *Controller) sendWindowTemplate(from, recipientID, messageType, body string) error {
+	if c.windowTemplate == "" {
+		return fmt.Errorf("%s message to %s: %w", messageType, recipientID, ErrWindowClosed)
+	}
+
+	language := c.templateLanguage(recipientID, c.windowTemplateLanguage)
+	log.Printf("Window of %s is closed, sending template %s instead of %s message", recipientID, c.windowTemplate, messageType)
+	resp, err := c.messagingClientManager.SendMessage(from, recipientID, c.windowTemplate, language)
+	if err != nil {
+		return fmt.Errorf("template %s to %s: %w", c.windowTemplate, recipientID, err)
+	}
+
+	if c.messageLog != nil && len(resp.Messages) > 0 {
+		err = c.messageLog.SetReplaced(resp.Messages[0].ID, messageType, body)
+		if err != nil {
+			log.Printf("Error recording the %s message replaced by template %s: %v", messageType, resp.Messages[0].ID, err)
+		}
+	}
+
+	return &windowTemplateSent{template: c.windowTemplate, messageType: messageType, recipientID: recipientID}
+}
+
+// templateLanguage returns the language to send a template in: the language
+// if set, else the language the recipient chose, else the default language
+func (c *Controller) templateLanguage(recipientID, language string) string {
+	if language != "" {
+		return language
+	}
+
+	var preferred string
+	contact, ok, err := c.contacts.Get(recipientID)
+	if err == nil && ok {
+		preferred = contact.Language
+	}
+
+	return c.catalog.Language(preferred)
+}
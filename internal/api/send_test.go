@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/consent"
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/messagingclients/whatsapp"
+)
+
+// newLapsedContacts returns a contact store with a contact who last wrote two days ago
+func newLapsedContacts(t *testing.T) *contacts.MemoryStore {
+	store := contacts.NewMemoryStore()
+	_, err := store.Touch("994503981865", "T.A", time.Now().Add(-48*time.Hour))
+	if err != nil {
+		t.Fatalf("error touching contact: %v", err)
+	}
+	err = store.SetLanguage("994503981865", "en")
+	if err != nil {
+		t.Fatalf("error setting language: %v", err)
+	}
+
+	return store
+}
+
+func TestSendText_WindowClosed(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithContactStore(newLapsedContacts(t)))
+
+	err := c.sendText("15550909792", "Your results are ready", "994503981865")
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Errorf("expected ErrWindowClosed, got %v", err)
+	}
+	err = c.sendText("15550909792", "Hello", "994500000000")
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Errorf("expected ErrWindowClosed for a contact who never wrote, got %v", err)
+	}
+
+	if len(mc.texts) != 0 || len(mc.templates) != 0 {
+		t.Errorf("expected nothing to be sent, got %+v %+v", mc.texts, mc.templates)
+	}
+}
+
+func TestSendText_WindowTemplate(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	c := NewController(mc, WithContactStore(newLapsedContacts(t)), WithWindowTemplate("results_ready", ""))
+
+	err := c.sendDocument("15550909792", "https://example.com/api/v1/994503981865/document", "994503981865", "Your results")
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Fatalf("expected ErrWindowClosed, got %v", err)
+	}
+
+	if len(mc.documents) != 0 {
+		t.Errorf("expected no document to be sent, got %+v", mc.documents)
+	}
+	if len(mc.templates) != 1 || mc.templates[0].document != "results_ready" || mc.templates[0].text != "en" {
+		t.Errorf("expected the template in the language of the contact, got %+v", mc.templates)
+	}
+}
+
+func TestSendText_OptedOut(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	ledger := consent.NewMemoryLedger()
+	ledger.Record(consent.Entry{WaID: "994503981865", OptIn: contacts.OptInRevoked, At: time.Now()})
+	c := NewController(mc, WithContactStore(newLapsedContacts(t)), WithConsentLedger(ledger))
+
+	err := c.sendText("15550909792", "Hello", "994503981865")
+	if !errors.Is(err, ErrOptedOut) {
+		t.Errorf("expected ErrOptedOut, got %v", err)
+	}
+	if len(mc.texts) != 0 {
+		t.Errorf("expected nothing to be sent, got %+v", mc.texts)
+	}
+}
+
+func TestSendLocation(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	store := newLapsedContacts(t)
+	store.Touch("994503981866", "R.M", time.Now())
+	telegram := &fakeProvider{name: "telegram"}
+	c := NewController(mc, WithContactStore(store), WithChannel(telegram), WithWindowTemplate("results_ready", ""))
+
+	branch := whatsapp.Location{Latitude: 40.4093, Longitude: 49.8671, Name: "Nizami branch", Address: "Nizami 10, Baku"}
+	err := c.sendLocation("15550909792", "994503981866", branch)
+	if err != nil {
+		t.Fatalf("error sending location: %v", err)
+	}
+	if len(mc.locations) != 1 || mc.locations[0] != branch {
+		t.Errorf("expected the location to be sent, got %+v", mc.locations)
+	}
+
+	err = c.sendLocation("15550909792", "994503981865", branch)
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Errorf("expected ErrWindowClosed, got %v", err)
+	}
+	if len(mc.locations) != 1 || len(mc.templates) != 1 {
+		t.Errorf("expected the window template instead of the location, got %+v %+v", mc.locations, mc.templates)
+	}
+
+	err = c.sendLocation("15550909792", "telegram:42", branch)
+	if err != nil {
+		t.Fatalf("error sending location: %v", err)
+	}
+	if len(telegram.texts) != 1 || telegram.texts[0].text != "Nizami branch\nNizami 10, Baku\nhttps://maps.google.com/?q=40.409300,49.867100" {
+		t.Errorf("expected the location as a text, got %+v", telegram.texts)
+	}
+}
+
+func TestSendContacts(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	ledger := consent.NewMemoryLedger()
+	ledger.Record(consent.Entry{WaID: "994503981865", OptIn: contacts.OptInRevoked, At: time.Now()})
+	telegram := &fakeProvider{name: "telegram"}
+	c := NewController(mc, WithConsentLedger(ledger), WithChannel(telegram))
+
+	staff := []whatsapp.Contact{{
+		Name:   whatsapp.ContactName{FormattedName: "Reception"},
+		Phones: []whatsapp.ContactPhone{{Phone: "+994125550000"}},
+	}}
+	err := c.sendContacts("15550909792", "994503981865", staff)
+	if !errors.Is(err, ErrOptedOut) {
+		t.Errorf("expected ErrOptedOut, got %v", err)
+	}
+	if len(mc.contacts) != 0 {
+		t.Errorf("expected nothing to be sent, got %+v", mc.contacts)
+	}
+
+	err = c.sendContacts("15550909792", "telegram:42", staff)
+	if err != nil {
+		t.Fatalf("error sending contacts: %v", err)
+	}
+	if len(telegram.texts) != 1 || telegram.texts[0].text != "Reception +994125550000" {
+		t.Errorf("expected the contact card as a text, got %+v", telegram.texts)
+	}
+}
+
+func TestSendReaction(t *testing.T) {
+	mc := &fakeMessagingClient{}
+	store := newLapsedContacts(t)
+	store.Touch("994503981866", "R.M", time.Now())
+	telegram := &fakeProvider{name: "telegram"}
+	c := NewController(mc, WithContactStore(store), WithChannel(telegram), WithWindowTemplate("results_ready", ""))
+
+	err := c.sendReaction("15550909792", "994503981866", "wamid.1", "👍")
+	if err != nil {
+		t.Fatalf("error sending reaction: %v", err)
+	}
+	err = c.sendReaction("15550909792", "994503981865", "wamid.2", "👍")
+	if !errors.Is(err, ErrWindowClosed) {
+		t.Errorf("expected ErrWindowClosed, got %v", err)
+	}
+	err = c.sendReaction("15550909792", "telegram:42", "7", "👍")
+	if err != nil {
+		t.Fatalf("error sending reaction: %v", err)
+	}
+
+	if len(mc.reactions) != 1 || mc.reactions[0].document != "wamid.1" {
+		t.Errorf("expected one reaction, got %+v", mc.reactions)
+	}
+	if len(mc.templates) != 0 || len(telegram.texts) != 0 {
+		t.Errorf("expected nothing else to be sent, got %+v %+v", mc.templates, telegram.texts)
+	}
+}
@@ -0,0 +1,120 @@
+package billing
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+var periodFormats = map[string]string{
+	PeriodDay:   "%Y-%m-%d",
+	PeriodMonth: "%Y-%m",
+}
+
+// Conversation is the pricing information Meta sends with the statuses.
+// Meta charges per conversation, so a conversation is recorded once.
+type Conversation struct {
+	ID             string
+	BusinessNumber string
+	Recipient      string
+	OriginType     string
+	Category       string
+	PricingModel   string
+	Billable       bool
+	StartedAt      time.Time
+}
+
+// ReportQuery selects the conversations started in [From, To) grouped by Period
+type ReportQuery struct {
+	From   time.Time
+	To     time.Time
+	Period string
+}
+
+// ReportRow is the number of conversations of a business number and category in a period
+type ReportRow struct {
+	Period         string `json:"period"`
+	BusinessNumber string `json:"business_number"`
+	Category       string `json:"category"`
+	Conversations  int    `json:"conversations"`
+	Billable       int    `json:"billable"`
+}
+
+var migrations = []string{
+	`CREATE TABLE conversations (
+		id TEXT PRIMARY KEY,
+		business_number TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		origin_type TEXT NOT NULL,
+		category TEXT NOT NULL,
+		pricing_model TEXT NOT NULL,
+		billable BOOLEAN NOT NULL,
+		started_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX conversations_started_at ON conversations (started_at);`,
+}
+
+// Repository stores the conversations in SQLite
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "billing", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// RecordConversation stores the conversation unless it was already recorded.
+// The earliest status of the conversation tells when it started.
+func (r *Repository) RecordConversation(conversation Conversation) error {
+	_, err := r.db.Exec(`INSERT INTO conversations (id, business_number, recipient, origin_type, category, pricing_model, billable, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET started_at = MIN(started_at, excluded.started_at)`,
+		conversation.ID, conversation.BusinessNumber, conversation.Recipient, conversation.OriginType, conversation.Category,
+		conversation.PricingModel, conversation.Billable, conversation.StartedAt.UTC())
+	return err
+}
+
+// Report counts the conversations per period, business number and category
+func (r *Repository) Report(query ReportQuery) ([]ReportRow, error) {
+	format, ok := periodFormats[query.Period]
+	if !ok {
+		return nil, fmt.Errorf("unknown period %q", query.Period)
+	}
+
+	rows, err := r.db.Query(`SELECT strftime(?, started_at) AS period, business_number, category,
+			COUNT(*), SUM(CASE WHEN billable THEN 1 ELSE 0 END)
+		FROM conversations
+		WHERE started_at >= ? AND started_at < ?
+		GROUP BY period, business_number, category
+		ORDER BY period, business_number, category`,
+		format, query.From.UTC(), query.To.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []ReportRow{}
+	for rows.Next() {
+		var row ReportRow
+		err = rows.Scan(&row.Period, &row.BusinessNumber, &row.Category, &row.Conversations, &row.Billable)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
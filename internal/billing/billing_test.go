@@ -0,0 +1,73 @@
+package billing
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func TestRepository_Report(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "billing.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	day := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	conversations := []Conversation{
+		{ID: "c1", BusinessNumber: "15550909792", Category: "user_initiated", Billable: true, StartedAt: day},
+		// A later status of the same conversation
+		{ID: "c1", BusinessNumber: "15550909792", Category: "user_initiated", Billable: true, StartedAt: day.Add(time.Hour)},
+		{ID: "c2", BusinessNumber: "15550909792", Category: "user_initiated", Billable: false, StartedAt: day.Add(2 * time.Hour)},
+		{ID: "c3", BusinessNumber: "15550909792", Category: "business_initiated", Billable: true, StartedAt: day.AddDate(0, 0, 1)},
+		{ID: "c4", BusinessNumber: "15550909793", Category: "user_initiated", Billable: true, StartedAt: day.AddDate(0, 0, 1)},
+		{ID: "c5", BusinessNumber: "15550909792", Category: "user_initiated", Billable: true, StartedAt: day.AddDate(0, 1, 0)},
+	}
+	for _, conversation := range conversations {
+		err = repository.RecordConversation(conversation)
+		if err != nil {
+			t.Fatalf("error recording conversation: %v", err)
+		}
+	}
+
+	from := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	report, err := repository.Report(ReportQuery{From: from, To: to, Period: PeriodDay})
+	if err != nil {
+		t.Fatalf("error building report: %v", err)
+	}
+	expected := []ReportRow{
+		{Period: "2023-04-19", BusinessNumber: "15550909792", Category: "user_initiated", Conversations: 2, Billable: 1},
+		{Period: "2023-04-20", BusinessNumber: "15550909792", Category: "business_initiated", Conversations: 1, Billable: 1},
+		{Period: "2023-04-20", BusinessNumber: "15550909793", Category: "user_initiated", Conversations: 1, Billable: 1},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, report)
+	}
+	for i := range expected {
+		if report[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], report[i])
+		}
+	}
+
+	report, err = repository.Report(ReportQuery{From: from, To: to.AddDate(0, 1, 0), Period: PeriodMonth})
+	if err != nil {
+		t.Fatalf("error building report: %v", err)
+	}
+	if len(report) != 4 || report[3].Period != "2023-05" {
+		t.Errorf("unexpected monthly report: %+v", report)
+	}
+
+	_, err = repository.Report(ReportQuery{From: from, To: to, Period: "week"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown period")
+	}
+}
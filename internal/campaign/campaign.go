@@ -0,0 +1,386 @@
+package campaign
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// The statuses of a campaign. A scheduled campaign starts running at its
+// scheduled time and completes once every recipient was handled.
+const (
+	StatusScheduled = "scheduled"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+	StatusCompleted = "completed"
+)
+
+// The statuses of a recipient of a campaign. A recipient is claimed as sending
+// right before the template is sent to it, so a cancelled campaign sends no more.
+// A claim whose outcome was never stored, e.g. after a crash, expires to unknown:
+// the template may have been sent, so it isn't sent again.
+// The delivery of the sent messages is tracked by the message log.
+const (
+	RecipientPending   = "pending"
+	RecipientSending   = "sending"
+	RecipientSent      = "sent"
+	RecipientFailed    = "failed"
+	RecipientSkipped   = "skipped"
+	RecipientCancelled = "cancelled"
+	RecipientUnknown   = "unknown"
+)
+
+// The actions changing the status of a campaign
+const (
+	ActionStart    = "start"
+	ActionPause    = "pause"
+	ActionResume   = "resume"
+	ActionCancel   = "cancel"
+	ActionComplete = "complete"
+)
+
+var (
+	ErrNotFound          = errors.New("campaign not found")
+	ErrInvalidTransition = errors.New("campaign status doesn't allow the action")
+)
+
+type transition struct {
+	from []string
+	to   string
+}
+
+var transitions = map[string]transition{
+	ActionStart:    {from: []string{StatusScheduled}, to: StatusRunning},
+	ActionPause:    {from: []string{StatusScheduled, StatusRunning}, to: StatusPaused},
+	ActionResume:   {from: []string{StatusPaused}, to: StatusScheduled},
+	ActionCancel:   {from: []string{StatusScheduled, StatusRunning, StatusPaused}, to: StatusCancelled},
+	ActionComplete: {from: []string{StatusRunning}, to: StatusCompleted},
+}
+
+// Campaign sends a template to a list of recipients. Counts is the number
+// of recipients per status, it is only filled by Get.
+type Campaign struct {
+	ID               string         `json:"id"`
+	Name             string         `json:"name"`
+	From             string         `json:"from"`
+	Template         string         `json:"template"`
+	TemplateLanguage string         `json:"template_language"`
+	Status           string         `json:"status"`
+	ScheduledAt      time.Time      `json:"scheduled_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	Counts           map[string]int `json:"counts,omitempty"`
+}
+
+// Recipient is a number the campaign sends the template to, the
+// parameters fill the placeholders of the template
+type Recipient struct {
+	CampaignID string     `json:"-"`
+	To         string     `json:"to"`
+	Parameters []string   `json:"parameters,omitempty"`
+	Status     string     `json:"status"`
+	MessageID  string     `json:"message_id,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+}
+
+var migrations = []string{
+	`CREATE TABLE campaigns (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		template TEXT NOT NULL,
+		template_language TEXT NOT NULL,
+		status TEXT NOT NULL,
+		scheduled_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX campaigns_status ON campaigns (status, scheduled_at);
+	CREATE TABLE campaign_recipients (
+		campaign_id TEXT NOT NULL REFERENCES campaigns (id),
+		position INTEGER NOT NULL,
+		recipient TEXT NOT NULL,
+		parameters TEXT NOT NULL,
+		status TEXT NOT NULL,
+		message_id TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		sent_at TIMESTAMP,
+		PRIMARY KEY (campaign_id, recipient)
+	);
+	CREATE INDEX campaign_recipients_status ON campaign_recipients (campaign_id, status, position);`,
+	`ALTER TABLE campaign_recipients ADD COLUMN claimed_at TIMESTAMP;`,
+}
+
+// Repository stores the campaigns and their recipients in SQLite
+type Repository struct {
+	db *sql.DB
+	// mu serializes the transitions, a transition reads and updates the status
+	mu sync.Mutex
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "campaign", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Create stores the campaign as scheduled with its recipients, a number listed
+// twice only gets the template once. The ID and the creation time are set.
+func (r *Repository) Create(campaign Campaign, recipients []Recipient) (Campaign, error) {
+	id, err := newID()
+	if err != nil {
+		return Campaign{}, err
+	}
+	campaign.ID = id
+	campaign.Status = StatusScheduled
+	campaign.CreatedAt = time.Now().UTC()
+	campaign.ScheduledAt = campaign.ScheduledAt.UTC()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Campaign{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO campaigns (id, name, sender, template, template_language, status, scheduled_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		campaign.ID, campaign.Name, campaign.From, campaign.Template, campaign.TemplateLanguage, campaign.Status, campaign.ScheduledAt, campaign.CreatedAt)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	for i, recipient := range recipients {
+		parameters, err := json.Marshal(recipient.Parameters)
+		if err != nil {
+			return Campaign{}, err
+		}
+
+		_, err = tx.Exec(`INSERT OR IGNORE INTO campaign_recipients (campaign_id, position, recipient, parameters, status) VALUES (?, ?, ?, ?, ?)`,
+			campaign.ID, i, recipient.To, string(parameters), RecipientPending)
+		if err != nil {
+			return Campaign{}, err
+		}
+	}
+
+	return campaign, tx.Commit()
+}
+
+// Get returns the campaign with the number of recipients per status
+func (r *Repository) Get(id string) (Campaign, error) {
+	campaign, err := scanCampaign(r.db.QueryRow(`SELECT id, name, sender, template, template_language, status, scheduled_at, created_at FROM campaigns WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Campaign{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	rows, err := r.db.Query(`SELECT status, COUNT(*) FROM campaign_recipients WHERE campaign_id = ? GROUP BY status`, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	defer rows.Close()
+
+	campaign.Counts = map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return Campaign{}, err
+		}
+		campaign.Counts[status] = count
+	}
+
+	return campaign, rows.Err()
+}
+
+// Due returns the scheduled and running campaigns whose time came, the earliest first
+func (r *Repository) Due(now time.Time) ([]Campaign, error) {
+	rows, err := r.db.Query(`SELECT id, name, sender, template, template_language, status, scheduled_at, created_at FROM campaigns
+		WHERE status IN (?, ?) AND scheduled_at <= ? ORDER BY scheduled_at, created_at`, StatusScheduled, StatusRunning, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, rows.Err()
+}
+
+// Recipients returns the recipients of the campaign in the order of the list
+func (r *Repository) Recipients(id string) ([]Recipient, error) {
+	return r.recipients(`SELECT campaign_id, recipient, parameters, status, message_id, error, sent_at FROM campaign_recipients
+		WHERE campaign_id = ? ORDER BY position`, id)
+}
+
+// Pending returns the next recipients of the campaign the template wasn't sent to
+func (r *Repository) Pending(id string, limit int) ([]Recipient, error) {
+	return r.recipients(`SELECT campaign_id, recipient, parameters, status, message_id, error, sent_at FROM campaign_recipients
+		WHERE campaign_id = ? AND status = ? ORDER BY position LIMIT ?`, id, RecipientPending, limit)
+}
+
+// Claim marks the pending recipient as sending while the campaign is running.
+// It returns false when the campaign was paused or cancelled meanwhile.
+func (r *Repository) Claim(recipient Recipient) (bool, error) {
+	result, err := r.db.Exec(`UPDATE campaign_recipients SET status = ?, claimed_at = ?
+		WHERE campaign_id = ? AND recipient = ? AND status = ?
+			AND (SELECT status FROM campaigns WHERE id = ?) = ?`,
+		RecipientSending, time.Now().UTC(), recipient.CampaignID, recipient.To, RecipientPending, recipient.CampaignID, StatusRunning)
+	if err != nil {
+		return false, err
+	}
+
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+// UpdateRecipient stores the outcome of the send to the claimed recipient,
+// a pending status gives the recipient back for a retry
+func (r *Repository) UpdateRecipient(recipient Recipient) error {
+	var sentAt interface{}
+	if recipient.SentAt != nil {
+		sentAt = recipient.SentAt.UTC()
+	}
+
+	_, err := r.db.Exec(`UPDATE campaign_recipients SET status = ?, message_id = ?, error = ?, sent_at = ?
+		WHERE campaign_id = ? AND recipient = ? AND status = ?`,
+		recipient.Status, recipient.MessageID, recipient.Error, sentAt, recipient.CampaignID, recipient.To, RecipientSending)
+	return err
+}
+
+// ExpireClaims moves the recipients claimed before the time and still sending
+// to unknown, and returns how many were moved
+func (r *Repository) ExpireClaims(before time.Time) (int64, error) {
+	result, err := r.db.Exec(`UPDATE campaign_recipients SET status = ?, error = ?
+		WHERE status = ? AND (claimed_at IS NULL OR claimed_at < ?)`,
+		RecipientUnknown, "the send was interrupted, the template may have been sent", RecipientSending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Transition applies the action to the campaign. Cancelling a campaign cancels
+// its pending recipients, a campaign only completes once no recipient is pending
+// or sending.
+func (r *Repository) Transition(id, action string) (Campaign, error) {
+	t, ok := transitions[action]
+	if !ok {
+		return Campaign{}, fmt.Errorf("unknown campaign action %q", action)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	campaign, err := r.Get(id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	if !contains(t.from, campaign.Status) || (action == ActionComplete && campaign.Counts[RecipientPending]+campaign.Counts[RecipientSending] > 0) {
+		return Campaign{}, fmt.Errorf("%w: %s campaign %s is %s", ErrInvalidTransition, action, id, campaign.Status)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Campaign{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE campaigns SET status = ? WHERE id = ?`, t.to, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	if action == ActionCancel {
+		_, err = tx.Exec(`UPDATE campaign_recipients SET status = ? WHERE campaign_id = ? AND status = ?`, RecipientCancelled, id, RecipientPending)
+		if err != nil {
+			return Campaign{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	return r.Get(id)
+}
+
+func (r *Repository) recipients(query string, args ...interface{}) ([]Recipient, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var recipient Recipient
+		var parameters string
+		var sentAt sql.NullTime
+		err = rows.Scan(&recipient.CampaignID, &recipient.To, &parameters, &recipient.Status, &recipient.MessageID, &recipient.Error, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(parameters), &recipient.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		if sentAt.Valid {
+			recipient.SentAt = &sentAt.Time
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCampaign(row scanner) (Campaign, error) {
+	var campaign Campaign
+	err := row.Scan(&campaign.ID, &campaign.Name, &campaign.From, &campaign.Template, &campaign.TemplateLanguage, &campaign.Status, &campaign.ScheduledAt, &campaign.CreatedAt)
+	return campaign, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newID returns a random campaign ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
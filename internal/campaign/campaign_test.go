@@ -0,0 +1,205 @@
+package campaign
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "campaigns.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	return repository
+}
+
+func TestRepository_Campaign(t *testing.T) {
+	repository := newTestRepository(t)
+
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	campaign, err := repository.Create(Campaign{Name: "April results", From: "15550909792", Template: "results_ready", TemplateLanguage: "az", ScheduledAt: now.Add(time.Hour)}, []Recipient{
+		{To: "994503981865", Parameters: []string{"T.A"}},
+		{To: "994503981866", Parameters: []string{"R.M"}},
+		// listed twice
+		{To: "994503981865", Parameters: []string{"T.A"}},
+	})
+	if err != nil {
+		t.Fatalf("error creating campaign: %v", err)
+	}
+	if campaign.ID == "" || campaign.Status != StatusScheduled {
+		t.Fatalf("unexpected campaign %+v", campaign)
+	}
+
+	due, err := repository.Due(now)
+	if err != nil || len(due) != 0 {
+		t.Fatalf("expected no due campaign before the scheduled time, got %+v %v", due, err)
+	}
+	due, err = repository.Due(now.Add(time.Hour))
+	if err != nil || len(due) != 1 || due[0].ID != campaign.ID {
+		t.Fatalf("expected the campaign to be due, got %+v %v", due, err)
+	}
+
+	_, err = repository.Transition(campaign.ID, ActionStart)
+	if err != nil {
+		t.Fatalf("error starting campaign: %v", err)
+	}
+	_, err = repository.Transition(campaign.ID, ActionComplete)
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected a campaign with pending recipients not to complete, got %v", err)
+	}
+
+	pending, err := repository.Pending(campaign.ID, 10)
+	if err != nil || len(pending) != 2 || pending[0].To != "994503981865" || pending[0].Parameters[0] != "T.A" {
+		t.Fatalf("expected the two recipients in order, got %+v %v", pending, err)
+	}
+
+	sentAt := now.Add(time.Hour)
+	for i, status := range []string{RecipientSent, RecipientFailed} {
+		recipient := pending[i]
+		claimed, err := repository.Claim(recipient)
+		if err != nil || !claimed {
+			t.Fatalf("expected the recipient to be claimed, got %v %v", claimed, err)
+		}
+		recipient.Status = status
+		recipient.MessageID = "wamid.1"
+		recipient.SentAt = &sentAt
+		err = repository.UpdateRecipient(recipient)
+		if err != nil {
+			t.Fatalf("error updating recipient: %v", err)
+		}
+	}
+
+	campaign, err = repository.Transition(campaign.ID, ActionComplete)
+	if err != nil {
+		t.Fatalf("error completing campaign: %v", err)
+	}
+	if campaign.Status != StatusCompleted || campaign.Counts[RecipientSent] != 1 || campaign.Counts[RecipientFailed] != 1 {
+		t.Errorf("unexpected completed campaign %+v", campaign)
+	}
+
+	recipients, err := repository.Recipients(campaign.ID)
+	if err != nil || len(recipients) != 2 || recipients[0].SentAt == nil || !recipients[0].SentAt.Equal(sentAt) {
+		t.Errorf("unexpected recipients %+v %v", recipients, err)
+	}
+}
+
+func TestRepository_Transition(t *testing.T) {
+	repository := newTestRepository(t)
+
+	campaign, err := repository.Create(Campaign{Name: "April results", Template: "results_ready", ScheduledAt: time.Now()}, []Recipient{{To: "994503981865"}})
+	if err != nil {
+		t.Fatalf("error creating campaign: %v", err)
+	}
+
+	steps := []struct {
+		action string
+		status string
+		err    error
+	}{
+		{ActionResume, "", ErrInvalidTransition},
+		{ActionPause, StatusPaused, nil},
+		{ActionStart, "", ErrInvalidTransition},
+		{ActionResume, StatusScheduled, nil},
+		{ActionStart, StatusRunning, nil},
+		{ActionCancel, StatusCancelled, nil},
+		{ActionResume, "", ErrInvalidTransition},
+	}
+	for _, step := range steps {
+		updated, err := repository.Transition(campaign.ID, step.action)
+		if step.err != nil {
+			if !errors.Is(err, step.err) {
+				t.Errorf("%s: expected %v, got %v", step.action, step.err, err)
+			}
+			continue
+		}
+		if err != nil || updated.Status != step.status {
+			t.Errorf("%s: expected %s, got %+v %v", step.action, step.status, updated, err)
+		}
+	}
+
+	campaign, err = repository.Get(campaign.ID)
+	if err != nil || campaign.Counts[RecipientCancelled] != 1 {
+		t.Errorf("expected the pending recipient to be cancelled, got %+v %v", campaign, err)
+	}
+
+	claimed, err := repository.Claim(Recipient{CampaignID: campaign.ID, To: "994503981865"})
+	if err != nil || claimed {
+		t.Errorf("expected no recipient of a cancelled campaign to be claimed, got %v %v", claimed, err)
+	}
+
+	_, err = repository.Transition("unknown", ActionPause)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepository_ExpireClaims(t *testing.T) {
+	repository := newTestRepository(t)
+
+	campaign, err := repository.Create(Campaign{Name: "April results", Template: "results_ready", ScheduledAt: time.Now()}, []Recipient{{To: "994503981865"}})
+	if err != nil {
+		t.Fatalf("error creating campaign: %v", err)
+	}
+	_, err = repository.Transition(campaign.ID, ActionStart)
+	if err != nil {
+		t.Fatalf("error starting campaign: %v", err)
+	}
+	claimed, err := repository.Claim(Recipient{CampaignID: campaign.ID, To: "994503981865"})
+	if err != nil || !claimed {
+		t.Fatalf("expected the recipient to be claimed, got %v %v", claimed, err)
+	}
+
+	_, err = repository.Transition(campaign.ID, ActionComplete)
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected a campaign with a sending recipient not to complete, got %v", err)
+	}
+
+	expired, err := repository.ExpireClaims(time.Now().Add(-time.Hour))
+	if err != nil || expired != 0 {
+		t.Errorf("expected a recent claim not to expire, got %d %v", expired, err)
+	}
+	expired, err = repository.ExpireClaims(time.Now().Add(time.Minute))
+	if err != nil || expired != 1 {
+		t.Fatalf("expected the claim to expire, got %d %v", expired, err)
+	}
+
+	campaign, err = repository.Transition(campaign.ID, ActionComplete)
+	if err != nil {
+		t.Fatalf("error completing campaign: %v", err)
+	}
+	if campaign.Counts[RecipientUnknown] != 1 {
+		t.Errorf("expected the expired recipient to be unknown, got %+v", campaign)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(100)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if !limiter.Wait(nil) {
+			t.Fatalf("expected the limiter to wait")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected 5 messages to take at least 40ms at 100 per second, took %v", elapsed)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	limiter.Backoff(time.Minute)
+	if limiter.Wait(stop) {
+		t.Errorf("expected the wait to stop")
+	}
+}
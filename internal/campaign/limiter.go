@@ -0,0 +1,64 @@
+package campaign
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRate is the throughput of a business number on the lowest tier of the
+// Cloud API, the numbers upgraded to a higher tier send up to 1000 messages per second
+const DefaultRate = 80
+
+// Limiter spaces the sends so no more than the rate of messages are sent per second
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a limiter of rate messages per second, DefaultRate if the rate isn't positive
+func NewLimiter(rate int) *Limiter {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+
+	return &Limiter{interval: time.Second / time.Duration(rate)}
+}
+
+// Wait blocks until the next message may be sent.
+// It returns false if stop is closed while waiting.
+func (l *Limiter) Wait(stop <-chan struct{}) bool {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Backoff delays the next message, e.g. when the Cloud API reports the rate limit was hit
+func (l *Limiter) Backoff(delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := time.Now().Add(delay)
+	if at.After(l.next) {
+		l.next = at
+	}
+}
@@ -0,0 +1,172 @@
+package catalog
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	KeyResultsReady     = "results_ready"
+	KeyResultsReadyDate = "results_ready_date"
+	KeyResultsNotReady  = "results_not_ready"
+	KeyHelp             = "help"
+
+	KeySharePhone       = "share_phone"
+	KeySharePhoneButton = "share_phone_button"
+	KeyPhoneUnavailable = "phone_unavailable"
+
+	KeyLanguageName         = "language_name"
+	KeyChooseLanguage       = "choose_language"
+	KeyChooseLanguageButton = "choose_language_button"
+	KeyLanguageChanged      = "language_changed"
+
+	KeyOptedOut = "opted_out"
+	KeyOptedIn  = "opted_in"
+
+	KeyHandoffStarted = "handoff_started"
+
+	KeyUnsupportedMessage = "unsupported_message"
+
+	KeyEmailSubject = "email_subject"
+	KeyEmailBody    = "email_body"
+
+	keyDateFormat = "date_format"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Catalog holds the reply texts of every language.
+// The texts may contain placeholders like {name} and {date}.
+type Catalog struct {
+	defaultLanguage string
+	messages        map[string]map[string]string
+}
+
+// Default returns the catalog shipped with the service
+func Default(defaultLanguage string) (*Catalog, error) {
+	return load(locales, "locales", defaultLanguage)
+}
+
+// Load reads the catalog from the <language>.json files of the dir
+func Load(dir, defaultLanguage string) (*Catalog, error) {
+	return load(os.DirFS(dir), ".", defaultLanguage)
+}
+
+func load(fsys fs.FS, dir, defaultLanguage string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{
+		defaultLanguage: defaultLanguage,
+		messages:        map[string]map[string]string{},
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var messages map[string]string
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", file, err)
+		}
+
+		language := strings.TrimSuffix(path.Base(file), ".json")
+		c.messages[language] = messages
+	}
+
+	if _, ok := c.messages[defaultLanguage]; !ok {
+		return nil, fmt.Errorf("no messages found for the default language %s", defaultLanguage)
+	}
+
+	return c, nil
+}
+
+// DefaultLanguage returns the language used when no other language is known
+func (c *Catalog) DefaultLanguage() string {
+	return c.defaultLanguage
+}
+
+// Languages returns the languages of the catalog sorted by code
+func (c *Catalog) Languages() []string {
+	languages := make([]string, 0, len(c.messages))
+	for language := range c.messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// Supports tells whether the catalog has messages in the language
+func (c *Catalog) Supports(language string) bool {
+	_, ok := c.messages[language]
+	return ok
+}
+
+// Language returns the first supported language of the candidates, or the default language
+func (c *Catalog) Language(candidates ...string) string {
+	for _, language := range candidates {
+		if c.Supports(language) {
+			return language
+		}
+	}
+
+	return c.defaultLanguage
+}
+
+// Text returns the message of the key in the language with the placeholders replaced.
+// Messages missing in the language are taken from the default language.
+func (c *Catalog) Text(language, key string, params map[string]string) string {
+	message, ok := c.messages[language][key]
+	if !ok {
+		message, ok = c.messages[c.defaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+
+	for name, value := range params {
+		message = strings.ReplaceAll(message, "{"+name+"}", value)
+	}
+
+	return message
+}
+
+// FormatDate formats the date the way it is written in the language
+func (c *Catalog) FormatDate(language string, date time.Time) string {
+	layout := c.Text(language, keyDateFormat, nil)
+	if layout == keyDateFormat {
+		layout = "2006-01-02"
+	}
+
+	return date.Format(layout)
+}
+
+// Detect guesses the language of the text from its letters.
+// It returns an empty string when the letters are not conclusive.
+func Detect(text string) string {
+	for _, r := range text {
+		switch {
+		case r == 'ə' || r == 'Ə':
+			return "az"
+		case unicode.Is(unicode.Cyrillic, r):
+			return "ru"
+		}
+	}
+
+	return ""
+}
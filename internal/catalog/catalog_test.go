@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefault_Text(t *testing.T) {
+	c, err := Default("az")
+	if err != nil {
+		t.Fatalf("error loading catalog: %v", err)
+	}
+
+	for _, language := range []string{"az", "en", "ru", "tr"} {
+		if !c.Supports(language) {
+			t.Errorf("expected %s to be supported", language)
+		}
+	}
+
+	text := c.Text("en", KeyResultsNotReady, map[string]string{"name": "T.A"})
+	if text != "Dear T.A, your analysis results are not ready yet." {
+		t.Errorf("unexpected text: %s", text)
+	}
+
+	date := c.FormatDate("az", time.Date(2023, 4, 19, 0, 0, 0, 0, time.UTC))
+	text = c.Text("az", KeyResultsReadyDate, map[string]string{"name": "T.A", "date": date})
+	if text != "Hörmətli T.A. 19.04.2023 tarixli analiz nəticələriniz hazırdır." {
+		t.Errorf("unexpected text: %s", text)
+	}
+}
+
+func TestLoad_FallbackToDefaultLanguage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"az.json": `{"help": "Kömək", "results_ready": "Hazırdır"}`,
+		"en.json": `{"help": "Help"}`,
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Unable to write %s: %v", name, err)
+		}
+	}
+
+	c, err := Load(dir, "az")
+	if err != nil {
+		t.Fatalf("error loading catalog: %v", err)
+	}
+
+	tests := []struct {
+		language, key, text string
+	}{
+		{language: "en", key: KeyHelp, text: "Help"},
+		{language: "en", key: KeyResultsReady, text: "Hazırdır"},
+		{language: "de", key: KeyHelp, text: "Kömək"},
+		{language: "en", key: "unknown", text: "unknown"},
+	}
+	for _, tt := range tests {
+		if text := c.Text(tt.language, tt.key, nil); text != tt.text {
+			t.Errorf("%s/%s: expected %q, got %q", tt.language, tt.key, tt.text, text)
+		}
+	}
+
+	if language := c.Language("", "de", "en"); language != "en" {
+		t.Errorf("expected en, got %s", language)
+	}
+	if language := c.Language("de"); language != "az" {
+		t.Errorf("expected the default language, got %s", language)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]string{
+		"Nəticələrim":    "az",
+		"Здравствуйте":   "ru",
+		"Hello":          "",
+		"Salam, netice?": "",
+	}
+	for text, language := range tests {
+		if detected := Detect(text); detected != language {
+			t.Errorf("%q: expected %q, got %q", text, language, detected)
+		}
+	}
+}
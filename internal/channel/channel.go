@@ -0,0 +1,126 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WhatsApp is the channel of the contacts keyed by their bare WhatsApp ID
+const WhatsApp = "whatsapp"
+
+var (
+	ErrUnknownChannel = errors.New("unknown channel")
+	// ErrUnauthorized is returned by the providers for webhook requests failing verification
+	ErrUnauthorized = errors.New("webhook request not authorized")
+	// ErrPhoneRequestUnsupported is returned by the providers of the channels
+	// which can't ask the contact for their phone number
+	ErrPhoneRequestUnsupported = errors.New("channel can't request the phone number")
+)
+
+// Provider sends and receives the messages of a channel
+type Provider interface {
+	// Name is the name of the channel, e.g. "telegram"
+	Name() string
+	// Send translates the message to the API of the channel and sends it
+	Send(message OutboundMessage) error
+	// ParseUpdate verifies a webhook request of the channel and translates its messages
+	ParseUpdate(r *http.Request) ([]InboundMessage, error)
+}
+
+// Button is a reply button, pressing it sends the ID back as the payload
+type Button struct {
+	ID    string
+	Title string
+}
+
+// Address returns the contact ID of a chat on a channel, e.g. "telegram:1234".
+// The contacts of the other channels are kept apart from the WhatsApp contacts
+// which are keyed by their bare WhatsApp ID.
+func Address(channel, chatID string) string {
+	if channel == WhatsApp {
+		return chatID
+	}
+
+	return channel + ":" + chatID
+}
+
+// NormalizePhone returns the phone number as the digits of the international
+// number, like the WhatsApp IDs, e.g. "+994 50 398 18 65" is "994503981865".
+// It returns an empty string if the number isn't a plausible phone number.
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')':
+			return -1
+		}
+		return 'x'
+	}, phone)
+
+	if strings.Contains(digits, "x") || len(digits) < 7 || len(digits) > 15 {
+		return ""
+	}
+
+	return digits
+}
+
+// Split returns the channel and the chat ID of a contact ID
+func Split(contactID string) (string, string) {
+	i := strings.Index(contactID, ":")
+	if i < 0 {
+		return WhatsApp, contactID
+	}
+
+	return contactID[:i], contactID[i+1:]
+}
+
+// Registry keeps the providers by the name of their channel
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: map[string]Provider{},
+	}
+}
+
+// Register adds the provider, a provider of the same channel is replaced
+func (r *Registry) Register(provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[provider.Name()] = provider
+}
+
+func (r *Registry) Get(name string) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, name)
+	}
+
+	return provider, nil
+}
+
+// Names returns the sorted names of the registered channels
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,98 @@
+package channel
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type fakeProvider struct {
+	name string
+}
+
+func (p fakeProvider) Name() string { return p.name }
+
+func (p fakeProvider) Send(message OutboundMessage) error { return nil }
+
+func (p fakeProvider) ParseUpdate(r *http.Request) ([]InboundMessage, error) { return nil, nil }
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		channel, chatID, address string
+	}{
+		{WhatsApp, "994503981865", "994503981865"},
+		{"telegram", "123456789", "telegram:123456789"},
+		{"viber", "01234567890A=", "viber:01234567890A="},
+	}
+
+	for _, tt := range tests {
+		address := Address(tt.channel, tt.chatID)
+		if address != tt.address {
+			t.Errorf("Address(%q, %q) = %q, want %q", tt.channel, tt.chatID, address, tt.address)
+		}
+
+		channel, chatID := Split(address)
+		if channel != tt.channel || chatID != tt.chatID {
+			t.Errorf("Split(%q) = %q, %q", address, channel, chatID)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(fakeProvider{name: "telegram"})
+	registry.Register(fakeProvider{name: "sms"})
+
+	provider, err := registry.Get("telegram")
+	if err != nil || provider.Name() != "telegram" {
+		t.Errorf("expected the telegram provider, got %v %v", provider, err)
+	}
+
+	_, err = registry.Get("fax")
+	if !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("expected ErrUnknownChannel, got %v", err)
+	}
+
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"sms", "telegram"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+}
+
+func TestInboundMessage(t *testing.T) {
+	message := InboundMessage{Channel: "viber", SenderID: "01234567890A=", Media: []MediaRef{{URL: "https://example.com/p.jpg"}}}
+	if message.ContactID() != "viber:01234567890A=" {
+		t.Errorf("unexpected contact ID %q", message.ContactID())
+	}
+	if message.MediaRef() != "https://example.com/p.jpg" {
+		t.Errorf("expected the URL of the media, got %q", message.MediaRef())
+	}
+
+	message = InboundMessage{Channel: WhatsApp, SenderID: "994503981865", Media: []MediaRef{{ID: "media-1", URL: "https://example.com/p.jpg"}}}
+	if message.ContactID() != "994503981865" || message.MediaRef() != "media-1" {
+		t.Errorf("unexpected WhatsApp message %q %q", message.ContactID(), message.MediaRef())
+	}
+}
+
+func TestKeyboardText(t *testing.T) {
+	text := KeyboardText("Choose a language", []Button{{ID: "language:az", Title: "Azərbaycan"}, {ID: "language:en", Title: "English"}})
+	if text != "Choose a language\n- Azərbaycan\n- English" {
+		t.Errorf("unexpected text %q", text)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := map[string]string{
+		"+994 50 398 18 65": "994503981865",
+		"994503981865":      "994503981865",
+		"+1 (555) 090-9792": "15550909792",
+		"12345":             "",
+		"994503981865; ok":  "",
+		"":                  "",
+	}
+	for phone, want := range tests {
+		if got := NormalizePhone(phone); got != want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
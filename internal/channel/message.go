@@ -0,0 +1,95 @@
+package channel
+
+import (
+	"strings"
+	"time"
+)
+
+// The types of the outbound messages, the inbound messages keep the WhatsApp
+// message types, e.g. "text", "image" or "interactive"
+const (
+	MessageTypeText     = "text"
+	MessageTypeDocument = "document"
+	// MessageTypeKeyboard is a text with reply buttons
+	MessageTypeKeyboard = "keyboard"
+	// MessageTypePhoneRequest is a text with a button sharing the phone
+	// number of the contact, the title of the first button is its label
+	MessageTypePhoneRequest = "phone_request"
+)
+
+// MediaRef refers to a media of a message, either by the ID the channel
+// gives it or by its URL
+type MediaRef struct {
+	ID       string
+	URL      string
+	MimeType string
+	Caption  string
+}
+
+// InboundMessage is a message received on a channel. The providers translate
+// the webhook requests of their channel to it so the bot logic, the message
+// log and the contacts are the same for every channel.
+type InboundMessage struct {
+	Channel string
+	// SenderID is the address of the sender on the channel, the answers are sent to it
+	SenderID    string
+	ContactName string
+	// Recipient is the address of the business the message was sent to,
+	// e.g. the WhatsApp phone number ID
+	Recipient string
+	MessageID string
+	Type      string
+	Text      string
+	// Payload is the data of the button the sender pressed
+	Payload string
+	Media   []MediaRef
+	// ReplyTo is the ID of the message the sender quoted
+	ReplyTo string
+	// Phone is the phone number the sender shared with the button of a
+	// phone request, only set when the channel vouches it is their own
+	Phone string
+	Time  time.Time
+}
+
+// ContactID returns the ID the sender is known by in the stores
+func (m InboundMessage) ContactID() string {
+	return Address(m.Channel, m.SenderID)
+}
+
+// MediaRef returns the reference of the first media of the message,
+// the ID if the channel gives one or else the URL
+func (m InboundMessage) MediaRef() string {
+	if len(m.Media) == 0 {
+		return ""
+	}
+	if m.Media[0].ID != "" {
+		return m.Media[0].ID
+	}
+
+	return m.Media[0].URL
+}
+
+// OutboundMessage is a message to send on a channel, the providers
+// translate it to the API of their channel
+type OutboundMessage struct {
+	// RecipientID is the address of the recipient on the channel
+	RecipientID string
+	Type        string
+	// Text is the caption of a document
+	Text        string
+	DocumentURL string
+	Buttons     []Button
+	// ReplyTo is the ID of the message the answer quotes
+	ReplyTo string
+}
+
+// KeyboardText returns the text with the titles of the buttons for the
+// channels without reply buttons, the contact answers with a title
+func KeyboardText(text string, buttons []Button) string {
+	lines := []string{text}
+	for _, button := range buttons {
+		lines = append(lines, "- "+button.Title)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,133 @@
+package consent
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// Entry records a change of the consent of a contact
+type Entry struct {
+	WaID      string
+	OptIn     contacts.OptIn
+	MessageID string
+	At        time.Time
+}
+
+// Ledger keeps the history of the consents
+type Ledger interface {
+	Record(entry Entry) error
+	// Status returns the latest consent of the contact, OptInUnknown if none was recorded
+	Status(waID string) (contacts.OptIn, error)
+	History(waID string) ([]Entry, error)
+}
+
+// MemoryLedger keeps the consents in memory
+type MemoryLedger struct {
+	mu      sync.Mutex
+	entries map[string][]Entry
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		entries: map[string][]Entry{},
+	}
+}
+
+func (l *MemoryLedger) Record(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[entry.WaID] = append(l.entries[entry.WaID], entry)
+	return nil
+}
+
+func (l *MemoryLedger) Status(waID string) (contacts.OptIn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var latest Entry
+	for _, entry := range l.entries[waID] {
+		// Webhooks are not delivered in order, the latest message wins
+		if !entry.At.Before(latest.At) {
+			latest = entry
+		}
+	}
+
+	return latest.OptIn, nil
+}
+
+func (l *MemoryLedger) History(waID string) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	history := make([]Entry, len(l.entries[waID]))
+	copy(history, l.entries[waID])
+	return history, nil
+}
+
+var migrations = []string{
+	`CREATE TABLE consents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		wa_id TEXT NOT NULL,
+		opt_in TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX consents_wa_id ON consents (wa_id, at);`,
+}
+
+// Repository keeps the consents in SQLite, so an opt-out survives a restart
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "consent", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Record(entry Entry) error {
+	_, err := r.db.Exec(`INSERT INTO consents (wa_id, opt_in, message_id, at) VALUES (?, ?, ?, ?)`,
+		entry.WaID, entry.OptIn, entry.MessageID, entry.At.UTC())
+	return err
+}
+
+func (r *Repository) Status(waID string) (contacts.OptIn, error) {
+	// Webhooks are not delivered in order, the latest message wins
+	var optIn contacts.OptIn
+	err := r.db.QueryRow(`SELECT opt_in FROM consents WHERE wa_id = ? ORDER BY at DESC, id DESC LIMIT 1`, waID).Scan(&optIn)
+	if err == sql.ErrNoRows {
+		return contacts.OptInUnknown, nil
+	}
+
+	return optIn, err
+}
+
+func (r *Repository) History(waID string) ([]Entry, error) {
+	rows, err := r.db.Query(`SELECT wa_id, opt_in, message_id, at FROM consents WHERE wa_id = ? ORDER BY id`, waID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		err = rows.Scan(&entry.WaID, &entry.OptIn, &entry.MessageID, &entry.At)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,74 @@
+package consent
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/contacts"
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func TestMemoryLedger_Status(t *testing.T) {
+	testLedgerStatus(t, NewMemoryLedger())
+}
+
+func TestRepository_Status(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "consent.db")
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	testLedgerStatus(t, repository)
+	db.Close()
+
+	// The opt-out is still there after a restart
+	db, err = storage.Open(path)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+	repository, err = NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	status, err := repository.Status("994503981865")
+	if err != nil || status != contacts.OptInRevoked {
+		t.Errorf("expected opted out after reopening, got %s: %v", status, err)
+	}
+}
+
+func testLedgerStatus(t *testing.T, ledger Ledger) {
+	at := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+
+	status, err := ledger.Status("994503981865")
+	if err != nil || status != contacts.OptInUnknown {
+		t.Fatalf("expected unknown consent, got %s: %v", status, err)
+	}
+
+	entries := []Entry{
+		{WaID: "994503981865", OptIn: contacts.OptInRevoked, MessageID: "wamid.2", At: at.Add(time.Minute)},
+		// START sent before the STOP but delivered after it
+		{WaID: "994503981865", OptIn: contacts.OptInGranted, MessageID: "wamid.1", At: at},
+	}
+	for _, entry := range entries {
+		err = ledger.Record(entry)
+		if err != nil {
+			t.Fatalf("error recording consent: %v", err)
+		}
+	}
+
+	status, err = ledger.Status("994503981865")
+	if err != nil || status != contacts.OptInRevoked {
+		t.Errorf("expected opted out, got %s: %v", status, err)
+	}
+
+	history, err := ledger.History("994503981865")
+	if err != nil || len(history) != 2 {
+		t.Errorf("expected 2 entries, got %+v: %v", history, err)
+	}
+}
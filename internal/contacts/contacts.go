@@ -0,0 +1,274 @@
+package contacts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// OptIn is the consent of a contact to receive messages
+type OptIn string
+
+const (
+	OptInUnknown OptIn = ""
+	OptInGranted OptIn = "opted_in"
+	OptInRevoked OptIn = "opted_out"
+)
+
+// ErrEmailRegistered is returned when another address is already registered for the contact
+var ErrEmailRegistered = errors.New("another email address is registered")
+
+// ServiceWindow is how long free-form messages may be sent to a contact
+// after the last message of the contact, templates are needed afterwards
+const ServiceWindow = 24 * time.Hour
+
+// Contact is a patient writing to the service, keyed by WhatsApp ID
+type Contact struct {
+	WaID     string
+	Name     string
+	Language string
+	// Email receives the results besides the chat, registered when the results are uploaded
+	Email string
+	// Phone is the phone number the contact of another channel shared,
+	// the results are kept by phone number. It is empty for the WhatsApp
+	// contacts, their ID is their phone number.
+	Phone     string
+	OptIn     OptIn
+	FirstSeen time.Time
+	// LastSeen is the time of the last inbound message of the contact
+	LastSeen time.Time
+}
+
+// WindowOpen tells whether the customer service window of the contact is open at the time
+func (c Contact) WindowOpen(at time.Time) bool {
+	if c.LastSeen.IsZero() {
+		return false
+	}
+
+	return at.Sub(c.LastSeen) < ServiceWindow
+}
+
+// Store persists the contacts
+type Store interface {
+	Get(waID string) (Contact, bool, error)
+	// Touch records that the contact wrote at the given time under the profile name
+	Touch(waID, name string, at time.Time) (Contact, error)
+	SetLanguage(waID, language string) error
+	SetOptIn(waID string, optIn OptIn) error
+	// SetEmail registers the address of the contact, it never replaces another registered address
+	SetEmail(waID, email string) error
+	// ReplaceEmail registers the address of the contact in place of the registered one,
+	// e.g. when the lab corrects a wrong address
+	ReplaceEmail(waID, email string) error
+	// SetPhone links the contact to the phone number the channel verified
+	SetPhone(waID, phone string) error
+}
+
+// MemoryStore keeps the contacts in memory
+type MemoryStore struct {
+	mu       sync.Mutex
+	contacts map[string]Contact
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		contacts: map[string]Contact{},
+	}
+}
+
+func (s *MemoryStore) Get(waID string) (Contact, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact, ok := s.contacts[waID]
+	return contact, ok, nil
+}
+
+func (s *MemoryStore) Touch(waID, name string, at time.Time) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact, ok := s.contacts[waID]
+	if !ok {
+		contact = Contact{WaID: waID, FirstSeen: at}
+	}
+
+	if name != "" {
+		contact.Name = name
+	}
+	if at.Before(contact.FirstSeen) {
+		contact.FirstSeen = at
+	}
+	if at.After(contact.LastSeen) {
+		contact.LastSeen = at
+	}
+
+	s.contacts[waID] = contact
+	return contact, nil
+}
+
+func (s *MemoryStore) SetLanguage(waID, language string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact := s.contacts[waID]
+	contact.WaID = waID
+	contact.Language = language
+	s.contacts[waID] = contact
+	return nil
+}
+
+func (s *MemoryStore) SetOptIn(waID string, optIn OptIn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact := s.contacts[waID]
+	contact.WaID = waID
+	contact.OptIn = optIn
+	s.contacts[waID] = contact
+	return nil
+}
+
+func (s *MemoryStore) SetEmail(waID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact := s.contacts[waID]
+	if contact.Email != "" && contact.Email != email {
+		return fmt.Errorf("%w for %s", ErrEmailRegistered, waID)
+	}
+
+	contact.WaID = waID
+	contact.Email = email
+	s.contacts[waID] = contact
+	return nil
+}
+
+func (s *MemoryStore) ReplaceEmail(waID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact := s.contacts[waID]
+	contact.WaID = waID
+	contact.Email = email
+	s.contacts[waID] = contact
+	return nil
+}
+
+func (s *MemoryStore) SetPhone(waID, phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact := s.contacts[waID]
+	contact.WaID = waID
+	contact.Phone = phone
+	s.contacts[waID] = contact
+	return nil
+}
+
+var migrations = []string{
+	`CREATE TABLE contacts (
+		wa_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		language TEXT NOT NULL DEFAULT '',
+		opt_in TEXT NOT NULL DEFAULT '',
+		first_seen TIMESTAMP,
+		last_seen TIMESTAMP
+	);`,
+	`ALTER TABLE contacts ADD COLUMN phone TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE contacts ADD COLUMN email TEXT NOT NULL DEFAULT '';`,
+}
+
+// Repository keeps the contacts in SQLite
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "contacts", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Get(waID string) (Contact, bool, error) {
+	contact := Contact{WaID: waID}
+	var firstSeen, lastSeen sql.NullTime
+	err := r.db.QueryRow(`SELECT name, language, email, phone, opt_in, first_seen, last_seen FROM contacts WHERE wa_id = ?`, waID).
+		Scan(&contact.Name, &contact.Language, &contact.Email, &contact.Phone, &contact.OptIn, &firstSeen, &lastSeen)
+	if err == sql.ErrNoRows {
+		return Contact{}, false, nil
+	}
+	if err != nil {
+		return Contact{}, false, err
+	}
+
+	contact.FirstSeen = firstSeen.Time
+	contact.LastSeen = lastSeen.Time
+	return contact, true, nil
+}
+
+func (r *Repository) Touch(waID, name string, at time.Time) (Contact, error) {
+	// A webhook delivered late must not move the last seen time backwards
+	_, err := r.db.Exec(`INSERT INTO contacts (wa_id, name, first_seen, last_seen) VALUES (?, ?, ?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET
+			name = CASE WHEN excluded.name != '' THEN excluded.name ELSE name END,
+			first_seen = CASE WHEN first_seen IS NULL OR excluded.first_seen < first_seen THEN excluded.first_seen ELSE first_seen END,
+			last_seen = CASE WHEN last_seen IS NULL OR excluded.last_seen > last_seen THEN excluded.last_seen ELSE last_seen END`,
+		waID, name, at.UTC(), at.UTC())
+	if err != nil {
+		return Contact{}, err
+	}
+
+	contact, _, err := r.Get(waID)
+	return contact, err
+}
+
+func (r *Repository) SetLanguage(waID, language string) error {
+	_, err := r.db.Exec(`INSERT INTO contacts (wa_id, language) VALUES (?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET language = excluded.language`, waID, language)
+	return err
+}
+
+func (r *Repository) SetOptIn(waID string, optIn OptIn) error {
+	_, err := r.db.Exec(`INSERT INTO contacts (wa_id, opt_in) VALUES (?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET opt_in = excluded.opt_in`, waID, optIn)
+	return err
+}
+
+func (r *Repository) SetEmail(waID, email string) error {
+	result, err := r.db.Exec(`INSERT INTO contacts (wa_id, email) VALUES (?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET email = excluded.email WHERE email = '' OR email = excluded.email`, waID, email)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w for %s", ErrEmailRegistered, waID)
+	}
+
+	return nil
+}
+
+func (r *Repository) ReplaceEmail(waID, email string) error {
+	_, err := r.db.Exec(`INSERT INTO contacts (wa_id, email) VALUES (?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET email = excluded.email`, waID, email)
+	return err
+}
+
+func (r *Repository) SetPhone(waID, phone string) error {
+	_, err := r.db.Exec(`INSERT INTO contacts (wa_id, phone) VALUES (?, ?)
+		ON CONFLICT (wa_id) DO UPDATE SET phone = excluded.phone`, waID, phone)
+	return err
+}
@@ -0,0 +1,159 @@
+package contacts
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	return repository
+}
+
+func TestMemoryStore_Touch(t *testing.T) {
+	testStoreTouch(t, NewMemoryStore())
+}
+
+func TestRepository_Touch(t *testing.T) {
+	testStoreTouch(t, newTestRepository(t))
+}
+
+func testStoreTouch(t *testing.T, store Store) {
+	first := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+
+	_, err := store.Touch("994503981865", "T.A", first)
+	if err != nil {
+		t.Fatalf("error touching contact: %v", err)
+	}
+
+	err = store.SetLanguage("994503981865", "en")
+	if err != nil {
+		t.Fatalf("error setting language: %v", err)
+	}
+
+	// A webhook delivered late must not move the last seen time backwards
+	contact, err := store.Touch("994503981865", "", first.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("error touching contact: %v", err)
+	}
+	contact, err = store.Touch("994503981865", "Tabriz", first.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error touching contact: %v", err)
+	}
+
+	if contact.Name != "Tabriz" || contact.Language != "en" {
+		t.Errorf("unexpected contact: %+v", contact)
+	}
+	if !contact.FirstSeen.Equal(first.Add(-time.Minute)) || !contact.LastSeen.Equal(first.Add(time.Hour)) {
+		t.Errorf("unexpected seen times: %v - %v", contact.FirstSeen, contact.LastSeen)
+	}
+
+	stored, ok, err := store.Get("994503981865")
+	if err != nil || !ok {
+		t.Fatalf("expected contact to be stored: %v", err)
+	}
+	if stored != contact {
+		t.Errorf("expected %+v, got %+v", contact, stored)
+	}
+}
+
+func TestContact_WindowOpen(t *testing.T) {
+	lastSeen := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	contact := Contact{WaID: "994503981865", LastSeen: lastSeen}
+
+	tests := []struct {
+		at   time.Time
+		open bool
+	}{
+		{lastSeen.Add(time.Minute), true},
+		{lastSeen.Add(23*time.Hour + 59*time.Minute), true},
+		{lastSeen.Add(24 * time.Hour), false},
+		{lastSeen.Add(48 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		if open := contact.WindowOpen(tt.at); open != tt.open {
+			t.Errorf("WindowOpen(%v) = %v, want %v", tt.at, open, tt.open)
+		}
+	}
+
+	if (Contact{WaID: "994503981865"}).WindowOpen(lastSeen) {
+		t.Errorf("expected the window of a contact which never wrote to be closed")
+	}
+}
+
+func TestMemoryStore_SetEmail(t *testing.T) {
+	testStoreSetEmail(t, NewMemoryStore())
+}
+
+func TestRepository_SetEmail(t *testing.T) {
+	testStoreSetEmail(t, newTestRepository(t))
+}
+
+func testStoreSetEmail(t *testing.T, store Store) {
+	err := store.SetEmail("994503981865", "patient@example.com")
+	if err != nil {
+		t.Fatalf("error setting email: %v", err)
+	}
+	err = store.SetEmail("994503981865", "patient@example.com")
+	if err != nil {
+		t.Fatalf("expected the same address to be accepted again: %v", err)
+	}
+
+	err = store.SetEmail("994503981865", "someone@example.com")
+	if !errors.Is(err, ErrEmailRegistered) {
+		t.Fatalf("expected ErrEmailRegistered, got %v", err)
+	}
+
+	contact, _, _ := store.Get("994503981865")
+	if contact.Email != "patient@example.com" {
+		t.Errorf("expected the registered address to stay, got %q", contact.Email)
+	}
+
+	err = store.ReplaceEmail("994503981865", "someone@example.com")
+	if err != nil {
+		t.Fatalf("error replacing email: %v", err)
+	}
+	contact, _, _ = store.Get("994503981865")
+	if contact.Email != "someone@example.com" {
+		t.Errorf("expected the address to be replaced, got %q", contact.Email)
+	}
+}
+
+func TestMemoryStore_SetPhone(t *testing.T) {
+	testStoreSetPhone(t, NewMemoryStore())
+}
+
+func TestRepository_SetPhone(t *testing.T) {
+	testStoreSetPhone(t, newTestRepository(t))
+}
+
+func testStoreSetPhone(t *testing.T, store Store) {
+	_, err := store.Touch("telegram:987654321", "Tabriz", time.Unix(1681899808, 0).UTC())
+	if err != nil {
+		t.Fatalf("error touching contact: %v", err)
+	}
+
+	err = store.SetPhone("telegram:987654321", "994503981865")
+	if err != nil {
+		t.Fatalf("error setting phone: %v", err)
+	}
+
+	contact, _, _ := store.Get("telegram:987654321")
+	if contact.Phone != "994503981865" || contact.Name != "Tabriz" {
+		t.Errorf("expected the phone to be linked, got %+v", contact)
+	}
+}
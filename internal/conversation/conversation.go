@@ -0,0 +1,377 @@
+package conversation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// State is the step a conversation with a contact is in
+type State string
+
+const (
+	StateIdle State = "idle"
+)
+
+var (
+	ErrUnknownState = errors.New("no handler registered for state")
+	// ErrConflict is returned when the session changed while the handler ran,
+	// the state returned by the handler isn't saved
+	ErrConflict = errors.New("session changed while handling the event")
+)
+
+// Event is an inbound message driving a conversation forward.
+// The Payload is the ID of the button or list row the contact picked.
+type Event struct {
+	ContactID string
+	Name      string
+	Recipient string
+	MessageID string
+	Type      string
+	Text      string
+	Payload   string
+	// ReplyTo is the ID of the message the contact quoted
+	ReplyTo string
+	// Phone is the phone number the contact shared through the channel
+	Phone string
+	Time  time.Time
+}
+
+// Session is the conversation state of a contact.
+// The Version is incremented each time the session is saved.
+type Session struct {
+	ContactID string
+	State     State
+	Context   map[string]string
+	StartedAt time.Time
+	UpdatedAt time.Time
+	Version   int
+}
+
+// Get returns a context variable of the session
+func (s *Session) Get(key string) string {
+	return s.Context[key]
+}
+
+// Set stores a context variable in the session
+func (s *Session) Set(key, value string) {
+	if s.Context == nil {
+		s.Context = map[string]string{}
+	}
+	s.Context[key] = value
+}
+
+func (s Session) copy() Session {
+	context := make(map[string]string, len(s.Context))
+	for k, v := range s.Context {
+		context[k] = v
+	}
+	s.Context = context
+	return s
+}
+
+// Store persists the sessions keyed by contact ID
+type Store interface {
+	Get(contactID string) (Session, bool, error)
+	Save(session Session) error
+	Delete(contactID string) error
+}
+
+// MemoryStore keeps the sessions in memory
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]Session{},
+	}
+}
+
+func (s *MemoryStore) Get(contactID string) (Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[contactID]
+	return session.copy(), ok, nil
+}
+
+func (s *MemoryStore) Save(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ContactID] = session.copy()
+	return nil
+}
+
+func (s *MemoryStore) Delete(contactID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, contactID)
+	return nil
+}
+
+var migrations = []string{
+	`CREATE TABLE conversation_sessions (
+		contact_id TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		context TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		version INTEGER NOT NULL
+	);`,
+}
+
+// Repository keeps the sessions in SQLite, so a dialog goes on after a restart
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "conversation", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Get(contactID string) (Session, bool, error) {
+	var session Session
+	var context string
+	err := r.db.QueryRow(`SELECT contact_id, state, context, started_at, updated_at, version FROM conversation_sessions WHERE contact_id = ?`, contactID).
+		Scan(&session.ContactID, &session.State, &context, &session.StartedAt, &session.UpdatedAt, &session.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	err = json.Unmarshal([]byte(context), &session.Context)
+	if err != nil {
+		return Session{}, false, fmt.Errorf("invalid context of session %s: %w", contactID, err)
+	}
+
+	return session, true, nil
+}
+
+func (r *Repository) Save(session Session) error {
+	context, err := json.Marshal(session.Context)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`INSERT INTO conversation_sessions (contact_id, state, context, started_at, updated_at, version) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (contact_id) DO UPDATE SET state = excluded.state, context = excluded.context,
+			started_at = excluded.started_at, updated_at = excluded.updated_at, version = excluded.version`,
+		session.ContactID, string(session.State), string(context), session.StartedAt.UTC(), session.UpdatedAt.UTC(), session.Version)
+	return err
+}
+
+func (r *Repository) Delete(contactID string) error {
+	_, err := r.db.Exec(`DELETE FROM conversation_sessions WHERE contact_id = ?`, contactID)
+	return err
+}
+
+// Handler handles an event received in a state and returns the next state
+type Handler func(session *Session, event Event) (State, error)
+
+type stateConfig struct {
+	handler Handler
+	timeout time.Duration
+}
+
+// Machine drives the sessions from one state to another.
+// The sessions of a contact are loaded and saved under a lock of the contact,
+// the handlers run without it, so they may send messages and call the Machine.
+// The events of a contact are dispatched one at a time under another lock, so
+// two messages sent at once don't both get an answer from the same state.
+type Machine struct {
+	store       Store
+	initial     State
+	states      map[State]stateConfig
+	now         func() time.Time
+	locks       contactLocks
+	dispatching contactLocks
+}
+
+// NewMachine returns a Machine starting new sessions in the initial state
+func NewMachine(store Store, initial State) *Machine {
+	return &Machine{
+		store:       store,
+		initial:     initial,
+		states:      map[State]stateConfig{},
+		now:         time.Now,
+		locks:       contactLocks{locks: map[string]*contactLock{}},
+		dispatching: contactLocks{locks: map[string]*contactLock{}},
+	}
+}
+
+// Handle registers the handler of a state.
+// A session staying longer than the timeout in the state is reset to the
+// initial state with an empty context. A zero timeout never expires.
+func (m *Machine) Handle(state State, timeout time.Duration, handler Handler) {
+	m.states[state] = stateConfig{handler: handler, timeout: timeout}
+}
+
+// Session returns the current session of the contact
+func (m *Machine) Session(contactID string) (Session, error) {
+	defer m.locks.lock(contactID)()
+
+	return m.load(contactID)
+}
+
+// Transition moves the session of the contact to the state
+// without handling an event, e.g. when staff takes over the conversation.
+func (m *Machine) Transition(contactID string, state State) error {
+	if _, ok := m.states[state]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownState, state)
+	}
+
+	defer m.locks.lock(contactID)()
+
+	session, err := m.load(contactID)
+	if err != nil {
+		return err
+	}
+
+	session.State = state
+	return m.save(&session)
+}
+
+// Reset drops the session of the contact
+func (m *Machine) Reset(contactID string) error {
+	defer m.locks.lock(contactID)()
+
+	return m.store.Delete(contactID)
+}
+
+// Dispatch handles the event in the current state of the session
+// and saves the session in the state returned by the handler.
+// The handler of the next event of the contact waits for it, so a handler
+// must not dispatch an event of its own contact.
+// When the session changed while the handler ran, e.g. staff closed a
+// handoff meanwhile, the change is kept and ErrConflict is returned.
+func (m *Machine) Dispatch(event Event) (Session, error) {
+	defer m.dispatching.lock(event.ContactID)()
+
+	session, err := m.Session(event.ContactID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	config, ok := m.states[session.State]
+	if !ok {
+		return session, fmt.Errorf("%w: %s", ErrUnknownState, session.State)
+	}
+
+	next, err := config.handler(&session, event)
+	if err != nil {
+		return session, err
+	}
+
+	if _, ok := m.states[next]; !ok {
+		return session, fmt.Errorf("%w: %s", ErrUnknownState, next)
+	}
+
+	defer m.locks.lock(event.ContactID)()
+
+	current, err := m.load(event.ContactID)
+	if err != nil {
+		return session, err
+	}
+	if current.Version != session.Version {
+		return current, fmt.Errorf("%w: %s", ErrConflict, event.ContactID)
+	}
+
+	session.State = next
+	err = m.save(&session)
+	if err != nil {
+		return session, err
+	}
+
+	return session, nil
+}
+
+// save stores the session as its next version
+func (m *Machine) save(session *Session) error {
+	session.UpdatedAt = m.now()
+	session.Version++
+	return m.store.Save(*session)
+}
+
+func (m *Machine) load(contactID string) (Session, error) {
+	now := m.now()
+	session, ok, err := m.store.Get(contactID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if ok && !m.expired(session, now) {
+		return session, nil
+	}
+
+	// A session replacing an expired one keeps counting its versions
+	return Session{
+		ContactID: contactID,
+		State:     m.initial,
+		Context:   map[string]string{},
+		StartedAt: now,
+		UpdatedAt: now,
+		Version:   session.Version,
+	}, nil
+}
+
+func (m *Machine) expired(session Session, now time.Time) bool {
+	config, ok := m.states[session.State]
+	if !ok || config.timeout == 0 {
+		return false
+	}
+
+	return now.Sub(session.UpdatedAt) > config.timeout
+}
+
+// contactLocks hands out a mutex per contact, so the sessions of
+// different contacts don't wait for each other
+type contactLocks struct {
+	mu    sync.Mutex
+	locks map[string]*contactLock
+}
+
+type contactLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks the contact and returns the function unlocking it
+func (l *contactLocks) lock(contactID string) func() {
+	l.mu.Lock()
+	cl, ok := l.locks[contactID]
+	if !ok {
+		cl = &contactLock{}
+		l.locks[contactID] = cl
+	}
+	cl.waiters++
+	l.mu.Unlock()
+
+	cl.Lock()
+	return func() {
+		cl.Unlock()
+
+		l.mu.Lock()
+		cl.waiters--
+		if cl.waiters == 0 {
+			delete(l.locks, contactID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,305 @@
+package conversation
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+const stateAskName State = "ask_name"
+
+func newTestMachine(now *time.Time) *Machine {
+	return newTestMachineWithStore(NewMemoryStore(), now)
+}
+
+func newTestMachineWithStore(store Store, now *time.Time) *Machine {
+	m := NewMachine(store, StateIdle)
+	m.now = func() time.Time { return *now }
+
+	m.Handle(StateIdle, 0, func(s *Session, e Event) (State, error) {
+		return stateAskName, nil
+	})
+	m.Handle(stateAskName, 5*time.Minute, func(s *Session, e Event) (State, error) {
+		s.Set("name", e.Text)
+		return StateIdle, nil
+	})
+	return m
+}
+
+func TestMachine_Dispatch(t *testing.T) {
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	m := newTestMachine(&now)
+
+	session, err := m.Dispatch(Event{ContactID: "994503981865", Text: "hi"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+	if session.State != stateAskName {
+		t.Errorf("expected state %s, got %s", stateAskName, session.State)
+	}
+
+	now = now.Add(time.Minute)
+	session, err = m.Dispatch(Event{ContactID: "994503981865", Text: "T.A"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+	if session.State != StateIdle || session.Get("name") != "T.A" {
+		t.Errorf("unexpected session: %+v", session)
+	}
+
+	stored, err := m.Session("994503981865")
+	if err != nil {
+		t.Fatalf("error loading session: %v", err)
+	}
+	if stored.Get("name") != "T.A" {
+		t.Errorf("expected context to be persisted, got %+v", stored.Context)
+	}
+}
+
+func TestMachine_Timeout(t *testing.T) {
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	m := newTestMachine(&now)
+
+	_, err := m.Dispatch(Event{ContactID: "994503981865", Text: "hi"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+
+	// The session expired in ask_name, so the event is handled as a new conversation
+	now = now.Add(10 * time.Minute)
+	session, err := m.Dispatch(Event{ContactID: "994503981865", Text: "T.A"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+	if session.State != stateAskName || session.Get("name") != "" {
+		t.Errorf("expected session to restart, got %+v", session)
+	}
+}
+
+func TestMachine_UnknownState(t *testing.T) {
+	now := time.Now()
+	m := newTestMachine(&now)
+
+	err := m.Transition("994503981865", "unknown")
+	if !errors.Is(err, ErrUnknownState) {
+		t.Errorf("expected ErrUnknownState, got %v", err)
+	}
+}
+
+func TestMachine_HandlerCallsMachine(t *testing.T) {
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	m := newTestMachine(&now)
+
+	// A handler may load and move the session of its own contact, e.g. when
+	// staff closes a handoff while the bot is still answering
+	m.Handle(StateIdle, 0, func(s *Session, e Event) (State, error) {
+		_, err := m.Session(e.ContactID)
+		if err != nil {
+			return s.State, err
+		}
+		return stateAskName, m.Transition(e.ContactID, stateAskName)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Dispatch(Event{ContactID: "994503981865", Text: "hi"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch deadlocked")
+	}
+
+	session, err := m.Session("994503981865")
+	if err != nil || session.State != stateAskName || session.Version != 1 {
+		t.Errorf("expected the transition to be kept, got %+v: %v", session, err)
+	}
+}
+
+func TestMachine_ContactsInParallel(t *testing.T) {
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	m := newTestMachine(&now)
+
+	// The handler of a contact waits for the handler of another contact
+	answered := make(chan struct{})
+	m.Handle(StateIdle, 0, func(s *Session, e Event) (State, error) {
+		if e.ContactID == "994503981865" {
+			<-answered
+		} else {
+			close(answered)
+		}
+		return stateAskName, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Dispatch(Event{ContactID: "994503981865", Text: "hi"})
+		done <- err
+	}()
+
+	_, err := m.Dispatch(Event{ContactID: "4917635163191", Text: "hi"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("error dispatching event: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch of the first contact didn't finish")
+	}
+}
+
+func TestMachine_ContactSerialized(t *testing.T) {
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	m := newTestMachine(&now)
+
+	// The first event of the contact is still being answered when the second arrives
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var states []State
+	m.Handle(StateIdle, 0, func(s *Session, e Event) (State, error) {
+		states = append(states, s.State)
+		close(started)
+		<-release
+		return stateAskName, nil
+	})
+	m.Handle(stateAskName, 5*time.Minute, func(s *Session, e Event) (State, error) {
+		states = append(states, s.State)
+		return StateIdle, nil
+	})
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := m.Dispatch(Event{ContactID: "994503981865", Text: "hi"})
+		done <- err
+	}()
+	<-started
+	go func() {
+		_, err := m.Dispatch(Event{ContactID: "994503981865", Text: "T.A"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the second event to wait for the first, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("error dispatching event: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Dispatch didn't finish")
+		}
+	}
+	if len(states) != 2 || states[0] != StateIdle || states[1] != stateAskName {
+		t.Errorf("expected the second event to be handled in the state of the first, got %v", states)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversation.db")
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	testStore(t, repository)
+
+	// A dialog goes on after a restart
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	_, err = newTestMachineWithStore(repository, &now).Dispatch(Event{ContactID: "4917635163191", Text: "hi"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+	db.Close()
+
+	db, err = storage.Open(path)
+	if err != nil {
+		t.Fatalf("error reopening database: %v", err)
+	}
+	defer db.Close()
+	repository, err = NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	now = now.Add(time.Minute)
+	session, err := newTestMachineWithStore(repository, &now).Dispatch(Event{ContactID: "4917635163191", Text: "T.A"})
+	if err != nil {
+		t.Fatalf("error dispatching event: %v", err)
+	}
+	if session.State != StateIdle || session.Get("name") != "T.A" || session.Version != 2 {
+		t.Errorf("expected the dialog to go on, got %+v", session)
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	_, ok, err := store.Get("994503981865")
+	if err != nil || ok {
+		t.Fatalf("expected no session, got %v %v", ok, err)
+	}
+
+	at := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	session := Session{ContactID: "994503981865", State: stateAskName, StartedAt: at, UpdatedAt: at, Version: 1}
+	session.Set("name", "T.A")
+	err = store.Save(session)
+	if err != nil {
+		t.Fatalf("error saving session: %v", err)
+	}
+
+	session.State = StateIdle
+	session.Set("language", "az")
+	session.UpdatedAt = at.Add(time.Minute)
+	session.Version = 2
+	err = store.Save(session)
+	if err != nil {
+		t.Fatalf("error saving session: %v", err)
+	}
+
+	stored, ok, err := store.Get("994503981865")
+	if err != nil || !ok {
+		t.Fatalf("expected the session, got %v %v", ok, err)
+	}
+	if stored.State != StateIdle || stored.Get("name") != "T.A" || stored.Get("language") != "az" || stored.Version != 2 ||
+		!stored.StartedAt.Equal(at) || !stored.UpdatedAt.Equal(at.Add(time.Minute)) {
+		t.Errorf("unexpected session %+v", stored)
+	}
+
+	// The stored session doesn't change with the copy of the caller
+	stored.Set("name", "R.M")
+	stored, _, _ = store.Get("994503981865")
+	if stored.Get("name") != "T.A" {
+		t.Errorf("expected the stored context to be kept, got %+v", stored.Context)
+	}
+
+	err = store.Delete("994503981865")
+	if err != nil {
+		t.Fatalf("error deleting session: %v", err)
+	}
+	_, ok, err = store.Get("994503981865")
+	if err != nil || ok {
+		t.Errorf("expected the session to be deleted, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,344 @@
+package handoff
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// Direction tells who wrote a message of a handed off conversation
+type Direction string
+
+const (
+	DirectionInbound  Direction = "inbound"
+	DirectionOutbound Direction = "outbound"
+)
+
+var (
+	ErrNotFound = errors.New("handoff not found")
+	ErrClosed   = errors.New("handoff is closed")
+)
+
+// Message is a message exchanged while staff handles the conversation
+type Message struct {
+	Direction Direction `json:"direction"`
+	MessageID string    `json:"message_id,omitempty"`
+	Text      string    `json:"text"`
+	At        time.Time `json:"at"`
+}
+
+// Conversation is a conversation handed off from the bot to staff.
+// The Recipient is the business number the contact wrote to.
+type Conversation struct {
+	WaID      string    `json:"wa_id"`
+	Name      string    `json:"name"`
+	Recipient string    `json:"recipient"`
+	Open      bool      `json:"open"`
+	OpenedAt  time.Time `json:"opened_at"`
+	ClosedAt  time.Time `json:"closed_at,omitempty"`
+	Messages  []Message `json:"messages,omitempty"`
+}
+
+// Store keeps the handed off conversations, one per contact
+type Store interface {
+	// Open starts a handoff, an already open handoff is kept as it is
+	Open(waID, name, recipient string, at time.Time) (Conversation, error)
+	Append(waID string, message Message) error
+	Close(waID string, at time.Time) (Conversation, error)
+	Get(waID string) (Conversation, error)
+	// ListOpen returns the open handoffs without their messages, oldest first
+	ListOpen() ([]Conversation, error)
+}
+
+// MemoryStore keeps the handoffs in memory
+type MemoryStore struct {
+	mu            sync.Mutex
+	conversations map[string]Conversation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		conversations: map[string]Conversation{},
+	}
+}
+
+func (s *MemoryStore) Open(waID, name, recipient string, at time.Time) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[waID]
+	if ok && conversation.Open {
+		return conversation, nil
+	}
+
+	conversation = Conversation{
+		WaID:      waID,
+		Name:      name,
+		Recipient: recipient,
+		Open:      true,
+		OpenedAt:  at,
+	}
+	s.conversations[waID] = conversation
+	return conversation, nil
+}
+
+func (s *MemoryStore) Append(waID string, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[waID]
+	if !ok {
+		return ErrNotFound
+	}
+	if !conversation.Open {
+		return ErrClosed
+	}
+
+	conversation.Messages = append(conversation.Messages, message)
+	s.conversations[waID] = conversation
+	return nil
+}
+
+func (s *MemoryStore) Close(waID string, at time.Time) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[waID]
+	if !ok {
+		return Conversation{}, ErrNotFound
+	}
+	if !conversation.Open {
+		return conversation, ErrClosed
+	}
+
+	conversation.Open = false
+	conversation.ClosedAt = at
+	s.conversations[waID] = conversation
+	return conversation, nil
+}
+
+func (s *MemoryStore) Get(waID string) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[waID]
+	if !ok {
+		return Conversation{}, ErrNotFound
+	}
+
+	messages := make([]Message, len(conversation.Messages))
+	copy(messages, conversation.Messages)
+	conversation.Messages = messages
+	return conversation, nil
+}
+
+func (s *MemoryStore) ListOpen() ([]Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	open := []Conversation{}
+	for _, conversation := range s.conversations {
+		if conversation.Open {
+			conversation.Messages = nil
+			open = append(open, conversation)
+		}
+	}
+
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].OpenedAt.Before(open[j].OpenedAt)
+	})
+	return open, nil
+}
+
+var migrations = []string{
+	`CREATE TABLE handoffs (
+		wa_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		open BOOLEAN NOT NULL,
+		opened_at TIMESTAMP NOT NULL,
+		closed_at TIMESTAMP
+	);
+	CREATE TABLE handoff_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		wa_id TEXT NOT NULL REFERENCES handoffs (wa_id) ON DELETE CASCADE,
+		direction TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		text TEXT NOT NULL,
+		at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX handoff_messages_wa_id ON handoff_messages (wa_id);`,
+}
+
+// Repository keeps the handoffs in SQLite, so staff finds them again after a restart
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "handoff", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Open(waID, name, recipient string, at time.Time) (Conversation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	conversation, err := r.get(tx, waID)
+	if err == nil && conversation.Open {
+		return conversation, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Conversation{}, err
+	}
+
+	// A new handoff of the contact starts without the messages of the closed one
+	_, err = tx.Exec(`DELETE FROM handoffs WHERE wa_id = ?`, waID)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO handoffs (wa_id, name, recipient, open, opened_at) VALUES (?, ?, ?, TRUE, ?)`,
+		waID, name, recipient, at.UTC())
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return Conversation{WaID: waID, Name: name, Recipient: recipient, Open: true, OpenedAt: at}, nil
+}
+
+func (r *Repository) Append(waID string, message Message) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var open bool
+	err = tx.QueryRow(`SELECT open FROM handoffs WHERE wa_id = ?`, waID).Scan(&open)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !open {
+		return ErrClosed
+	}
+
+	_, err = tx.Exec(`INSERT INTO handoff_messages (wa_id, direction, message_id, text, at) VALUES (?, ?, ?, ?, ?)`,
+		waID, message.Direction, message.MessageID, message.Text, message.At.UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) Close(waID string, at time.Time) (Conversation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	conversation, err := r.get(tx, waID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if !conversation.Open {
+		return conversation, ErrClosed
+	}
+
+	_, err = tx.Exec(`UPDATE handoffs SET open = FALSE, closed_at = ? WHERE wa_id = ?`, at.UTC(), waID)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation.Open = false
+	conversation.ClosedAt = at
+	return conversation, nil
+}
+
+func (r *Repository) Get(waID string) (Conversation, error) {
+	return r.get(r.db, waID)
+}
+
+func (r *Repository) ListOpen() ([]Conversation, error) {
+	rows, err := r.db.Query(`SELECT wa_id, name, recipient, opened_at FROM handoffs WHERE open ORDER BY opened_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	open := []Conversation{}
+	for rows.Next() {
+		conversation := Conversation{Open: true}
+		err = rows.Scan(&conversation.WaID, &conversation.Name, &conversation.Recipient, &conversation.OpenedAt)
+		if err != nil {
+			return nil, err
+		}
+		open = append(open, conversation)
+	}
+
+	return open, rows.Err()
+}
+
+// querier is implemented by both the database and its transactions
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (r *Repository) get(q querier, waID string) (Conversation, error) {
+	conversation := Conversation{WaID: waID}
+	var closedAt sql.NullTime
+	err := q.QueryRow(`SELECT name, recipient, open, opened_at, closed_at FROM handoffs WHERE wa_id = ?`, waID).
+		Scan(&conversation.Name, &conversation.Recipient, &conversation.Open, &conversation.OpenedAt, &closedAt)
+	if err == sql.ErrNoRows {
+		return Conversation{}, ErrNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+	conversation.ClosedAt = closedAt.Time
+
+	rows, err := q.Query(`SELECT direction, message_id, text, at FROM handoff_messages WHERE wa_id = ? ORDER BY id`, waID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message Message
+		err = rows.Scan(&message.Direction, &message.MessageID, &message.Text, &message.At)
+		if err != nil {
+			return Conversation{}, err
+		}
+		conversation.Messages = append(conversation.Messages, message)
+	}
+
+	return conversation, rows.Err()
+}
@@ -0,0 +1,87 @@
+package handoff
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func TestMemoryStore_Lifecycle(t *testing.T) {
+	testStoreLifecycle(t, NewMemoryStore())
+}
+
+func TestRepository_Lifecycle(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "handoff.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+	testStoreLifecycle(t, repository)
+
+	// Opening the closed handoff again starts an empty one
+	_, err = repository.Open("994503981865", "T.A", "15550909792", time.Now())
+	if err != nil {
+		t.Fatalf("error opening handoff: %v", err)
+	}
+	conversation, err := repository.Get("994503981865")
+	if err != nil || !conversation.Open || len(conversation.Messages) != 0 {
+		t.Errorf("unexpected handoff: %+v: %v", conversation, err)
+	}
+}
+
+func testStoreLifecycle(t *testing.T, store Store) {
+	at := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+
+	_, err := store.Open("994503981865", "T.A", "15550909792", at)
+	if err != nil {
+		t.Fatalf("error opening handoff: %v", err)
+	}
+	_, err = store.Open("4917635163191", "B", "15550909792", at.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("error opening handoff: %v", err)
+	}
+
+	err = store.Append("994503981865", Message{Direction: DirectionInbound, Text: "Salam", At: at})
+	if err != nil {
+		t.Fatalf("error appending message: %v", err)
+	}
+
+	open, err := store.ListOpen()
+	if err != nil {
+		t.Fatalf("error listing handoffs: %v", err)
+	}
+	if len(open) != 2 || open[0].WaID != "4917635163191" {
+		t.Errorf("expected open handoffs oldest first, got %+v", open)
+	}
+
+	_, err = store.Close("994503981865", at.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("error closing handoff: %v", err)
+	}
+
+	err = store.Append("994503981865", Message{Direction: DirectionOutbound, Text: "Salam", At: at})
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	conversation, err := store.Get("994503981865")
+	if err != nil {
+		t.Fatalf("error loading handoff: %v", err)
+	}
+	if conversation.Open || len(conversation.Messages) != 1 {
+		t.Errorf("unexpected handoff: %+v", conversation)
+	}
+
+	_, err = store.Get("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package idempotency
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// TTL is how long a key is remembered, a request repeated later is handled again
+const TTL = 24 * time.Hour
+
+var ErrNotFound = errors.New("idempotency key not found")
+
+// Record is the outcome of the request made with a key. The request is in
+// progress until the message ID of the sent message is stored.
+type Record struct {
+	Key         string
+	RequestHash string
+	MessageID   string
+	CreatedAt   time.Time
+}
+
+// Done tells whether the request of the key completed
+func (r Record) Done() bool {
+	return r.MessageID != ""
+}
+
+// Store remembers the keys of the requests so a retried request isn't handled twice
+type Store interface {
+	// Begin claims the key for the request. The key is claimed if it is new
+	// or expired, otherwise the record of the earlier request is returned.
+	Begin(key, requestHash string, at time.Time) (Record, bool, error)
+	// Complete stores the message ID the request of the key sent
+	Complete(key, messageID string) error
+	// Release forgets the key of a failed request so it can be retried
+	Release(key string) error
+}
+
+// MemoryStore keeps the keys in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]Record{},
+	}
+}
+
+func (s *MemoryStore) Begin(key, requestHash string, at time.Time) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if ok && at.Sub(record.CreatedAt) < TTL {
+		return record, false, nil
+	}
+
+	s.records[key] = Record{Key: key, RequestHash: requestHash, CreatedAt: at}
+	return Record{}, true, nil
+}
+
+func (s *MemoryStore) Complete(key, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	record.MessageID = messageID
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+var migrations = []string{
+	`CREATE TABLE idempotency_keys (
+		key TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		message_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	);`,
+}
+
+// Repository stores the keys in SQLite so the retries are recognized after a restart
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "idempotency", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Begin claims the key in a single statement, so of two concurrent requests
+// with the same key only one is handled
+func (r *Repository) Begin(key, requestHash string, at time.Time) (Record, bool, error) {
+	res, err := r.db.Exec(`INSERT INTO idempotency_keys (key, request_hash, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET request_hash = excluded.request_hash, message_id = '', created_at = excluded.created_at
+		WHERE idempotency_keys.created_at < ?`,
+		key, requestHash, at.UTC(), at.Add(-TTL).UTC())
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return Record{}, false, err
+	}
+	if claimed > 0 {
+		return Record{}, true, nil
+	}
+
+	var record Record
+	err = r.db.QueryRow(`SELECT key, request_hash, message_id, created_at FROM idempotency_keys WHERE key = ?`, key).
+		Scan(&record.Key, &record.RequestHash, &record.MessageID, &record.CreatedAt)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return record, false, nil
+}
+
+func (r *Repository) Complete(key, messageID string) error {
+	res, err := r.db.Exec(`UPDATE idempotency_keys SET message_id = ? WHERE key = ?`, messageID, key)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *Repository) Release(key string) error {
+	_, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func TestStores(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": repository,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			at := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+
+			_, claimed, err := store.Begin("key-1", "hash-1", at)
+			if err != nil || !claimed {
+				t.Fatalf("expected the new key to be claimed, got %v %v", claimed, err)
+			}
+
+			record, claimed, err := store.Begin("key-1", "hash-1", at.Add(time.Second))
+			if err != nil || claimed || record.Done() || record.RequestHash != "hash-1" {
+				t.Fatalf("expected the request in progress, got %+v %v %v", record, claimed, err)
+			}
+
+			err = store.Complete("key-1", "wamid.1")
+			if err != nil {
+				t.Fatalf("error completing key: %v", err)
+			}
+			record, claimed, err = store.Begin("key-1", "hash-1", at.Add(time.Hour))
+			if err != nil || claimed || record.MessageID != "wamid.1" {
+				t.Errorf("expected the completed request, got %+v %v %v", record, claimed, err)
+			}
+
+			_, claimed, err = store.Begin("key-1", "hash-2", at.Add(TTL+time.Second))
+			if err != nil || !claimed {
+				t.Errorf("expected the expired key to be claimed again, got %v %v", claimed, err)
+			}
+
+			err = store.Release("key-1")
+			if err != nil {
+				t.Fatalf("error releasing key: %v", err)
+			}
+			_, claimed, err = store.Begin("key-1", "hash-3", at.Add(TTL+time.Minute))
+			if err != nil || !claimed {
+				t.Errorf("expected the released key to be claimed, got %v %v", claimed, err)
+			}
+
+			err = store.Complete("key-2", "wamid.2")
+			if err != ErrNotFound {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package intent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+)
+
+const (
+	IntentResult   = "result"
+	IntentHelp     = "help"
+	IntentStop     = "stop"
+	IntentStart    = "start"
+	IntentOperator = "operator"
+	IntentLanguage = "language"
+)
+
+// Rule maps the keywords and patterns of a language to an intent.
+// A keyword matches a word of the text starting with it, so suffixed words
+// like "neticelerim" match "netice". The keywords of a word rule only match
+// whole words, so "dil" doesn't match "diləyirəm" and "agent" doesn't match "agenda".
+// Patterns are case insensitive regexes.
+// The keywords of an exact rule only match the whole text, ignoring the case
+// and the punctuation, so "STOP" opts out but "results won't stop loading" doesn't.
+type Rule struct {
+	Intent   string   `json:"intent"`
+	Language string   `json:"language"`
+	Keywords []string `json:"keywords"`
+	Patterns []string `json:"patterns"`
+	Exact    bool     `json:"exact,omitempty"`
+	Word     bool     `json:"word,omitempty"`
+}
+
+// Match is the intent recognized in a text
+type Match struct {
+	Intent   string
+	Language string
+}
+
+// Handler handles an event matching an intent and returns the next conversation state
+type Handler func(session *conversation.Session, event conversation.Event, match Match) (conversation.State, error)
+
+type compiledRule struct {
+	Rule
+	keywords [][]string
+	patterns []*regexp.Regexp
+}
+
+// Router dispatches the events to the handler of the intent matching their text
+type Router struct {
+	rules    []compiledRule
+	handlers map[string]Handler
+	fallback Handler
+}
+
+// NewRouter returns a Router matching the rules in order, the first matching rule wins
+func NewRouter(rules []Rule) (*Router, error) {
+	r := &Router{
+		handlers: map[string]Handler{},
+	}
+
+	for _, rule := range rules {
+		compiled := compiledRule{Rule: rule}
+		for _, keyword := range rule.Keywords {
+			words := tokenize(keyword)
+			if len(words) > 0 {
+				compiled.keywords = append(compiled.keywords, words)
+			}
+		}
+
+		for _, pattern := range rule.Patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q of intent %s: %w", pattern, rule.Intent, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// LoadRules reads the rules from a JSON file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Handle registers the handler of the intent
+func (r *Router) Handle(intent string, handler Handler) {
+	r.handlers[intent] = handler
+}
+
+// Fallback registers the handler of the events no registered intent matches
+func (r *Router) Fallback(handler Handler) {
+	r.fallback = handler
+}
+
+// Match returns the intent of the text
+func (r *Router) Match(text string) (Match, bool) {
+	words := tokenize(text)
+	for _, rule := range r.rules {
+		if rule.match(text, words) {
+			return Match{Intent: rule.Intent, Language: rule.Language}, true
+		}
+	}
+
+	return Match{}, false
+}
+
+// Dispatch handles the event with the handler of the matching intent.
+// Buttons and list rows can carry the name of an intent as their ID,
+// such a payload takes precedence over the text.
+// It can be registered as the conversation handler of a state.
+func (r *Router) Dispatch(session *conversation.Session, event conversation.Event) (conversation.State, error) {
+	match, ok := r.Match(event.Text)
+	if handler, found := r.handlers[event.Payload]; found && event.Payload != "" {
+		match.Intent = event.Payload
+		return handler(session, event, match)
+	}
+
+	if ok {
+		if handler, ok := r.handlers[match.Intent]; ok {
+			return handler(session, event, match)
+		}
+	}
+
+	if r.fallback == nil {
+		return session.State, nil
+	}
+
+	return r.fallback(session, event, match)
+}
+
+func (r compiledRule) match(text string, words []string) bool {
+	for _, keyword := range r.keywords {
+		if r.Exact && equalPhrase(words, keyword) {
+			return true
+		}
+		if !r.Exact && containsPhrase(words, keyword, !r.Word) {
+			return true
+		}
+	}
+
+	for _, re := range r.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsPhrase tells whether the words contain the phrase,
+// the last word of the phrase may be followed by a suffix when suffixed is set.
+func containsPhrase(words, phrase []string, suffixed bool) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matched := true
+		for j, word := range phrase {
+			candidate := words[i+j]
+			if suffixed && j == len(phrase)-1 {
+				matched = strings.HasPrefix(candidate, word)
+			} else {
+				matched = candidate == word
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equalPhrase tells whether the words are exactly the phrase
+func equalPhrase(words, phrase []string) bool {
+	if len(words) != len(phrase) {
+		return false
+	}
+
+	for i, word := range phrase {
+		if words[i] != word {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// DefaultRules returns the rules used when no rules file is configured
+func DefaultRules() []Rule {
+	return []Rule{
+		{Intent: IntentStop, Language: "en", Keywords: []string{"stop", "unsubscribe"}, Exact: true},
+		{Intent: IntentStop, Language: "az", Keywords: []string{"dayandır", "dayandir"}, Exact: true},
+		{Intent: IntentStop, Language: "ru", Keywords: []string{"стоп", "отписаться"}, Exact: true},
+		{Intent: IntentStop, Language: "tr", Keywords: []string{"durdur", "iptal", "abonelikten"}, Exact: true},
+
+		{Intent: IntentStart, Language: "en", Keywords: []string{"start", "subscribe"}, Exact: true},
+		{Intent: IntentStart, Language: "tr", Keywords: []string{"başlat", "baslat"}, Exact: true},
+		{Intent: IntentStart, Language: "az", Keywords: []string{"başla", "basla"}, Exact: true},
+		{Intent: IntentStart, Language: "ru", Keywords: []string{"старт", "подписаться"}, Exact: true},
+
+		{Intent: IntentOperator, Language: "az", Keywords: []string{"operator", "əməkdaş", "emekdas"}},
+		{Intent: IntentOperator, Language: "en", Keywords: []string{"agent", "human", "support"}, Word: true},
+		{Intent: IntentOperator, Language: "ru", Keywords: []string{"оператор", "сотрудник"}},
+		{Intent: IntentOperator, Language: "tr", Keywords: []string{"operatör", "temsilci"}},
+
+		{Intent: IntentLanguage, Language: "az", Keywords: []string{"dil"}, Word: true},
+		{Intent: IntentLanguage, Language: "en", Keywords: []string{"language"}},
+		{Intent: IntentLanguage, Language: "ru", Keywords: []string{"язык"}},
+
+		{Intent: IntentHelp, Language: "az", Keywords: []string{"kömək", "komek"}},
+		{Intent: IntentHelp, Language: "en", Keywords: []string{"help", "menu"}},
+		{Intent: IntentHelp, Language: "ru", Keywords: []string{"помощь", "меню"}},
+		{Intent: IntentHelp, Language: "tr", Keywords: []string{"yardım", "yardim"}},
+
+		{Intent: IntentResult, Language: "az", Keywords: []string{"nəticə", "netice", "analiz"}},
+		{Intent: IntentResult, Language: "en", Keywords: []string{"result", "report"}, Patterns: []string{`\blab\s+results?\b`}},
+		{Intent: IntentResult, Language: "ru", Keywords: []string{"результат", "анализ"}},
+		{Intent: IntentResult, Language: "tr", Keywords: []string{"sonuç", "sonuc", "tahlil"}},
+	}
+}
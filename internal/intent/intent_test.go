@@ -0,0 +1,114 @@
+package intent
+
+import (
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/conversation"
+)
+
+func TestRouter_Match(t *testing.T) {
+	router, err := NewRouter(DefaultRules())
+	if err != nil {
+		t.Fatalf("error creating router: %v", err)
+	}
+
+	tests := []struct {
+		text     string
+		intent   string
+		language string
+		ok       bool
+	}{
+		{text: "netice", intent: IntentResult, language: "az", ok: true},
+		{text: "Salam, neticelerim hazirdir?", intent: IntentResult, language: "az", ok: true},
+		{text: "Nəticə", intent: IntentResult, language: "az", ok: true},
+		{text: "Where are my lab results?", intent: IntentResult, language: "en", ok: true},
+		{text: "RESULT", intent: IntentResult, language: "en", ok: true},
+		{text: "Результаты анализов", intent: IntentResult, language: "ru", ok: true},
+		{text: "Sonuçlarım", intent: IntentResult, language: "tr", ok: true},
+		{text: "help!", intent: IntentHelp, language: "en", ok: true},
+		{text: "Помощь", intent: IntentHelp, language: "ru", ok: true},
+		{text: "STOP", intent: IntentStop, language: "en", ok: true},
+		{text: " Stop. ", intent: IntentStop, language: "en", ok: true},
+		{text: "stop sending results", intent: IntentResult, language: "en", ok: true},
+		{text: "results won't stop loading", intent: IntentResult, language: "en", ok: true},
+		{text: "СТОП", intent: IntentStop, language: "ru", ok: true},
+		{text: "Dayandır", intent: IntentStop, language: "az", ok: true},
+		{text: "START", intent: IntentStart, language: "en", ok: true},
+		{text: "başla", intent: IntentStart, language: "az", ok: true},
+		{text: "Başlat", intent: IntentStart, language: "tr", ok: true},
+		{text: "operator", intent: IntentOperator, language: "az", ok: true},
+		{text: "I want to talk to a human", intent: IntentOperator, language: "en", ok: true},
+		{text: "Change language", intent: IntentLanguage, language: "en", ok: true},
+		{text: "Язык", intent: IntentLanguage, language: "ru", ok: true},
+		{text: "nonstop", ok: false},
+		{text: "randevumu iptal etmek istiyorum", ok: false},
+		{text: "started", ok: false},
+		{text: "başladı", ok: false},
+		{text: "dil", intent: IntentLanguage, language: "az", ok: true},
+		{text: "Can I talk to an agent?", intent: IntentOperator, language: "en", ok: true},
+		{text: "Üzr diləyirəm", ok: false},
+		{text: "Is PDF supported?", ok: false},
+		{text: "What's on the agenda?", ok: false},
+		{text: "salam", ok: false},
+		{text: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			match, ok := router.Match(tt.text)
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, ok)
+			}
+			if match.Intent != tt.intent || match.Language != tt.language {
+				t.Errorf("expected %s/%s, got %s/%s", tt.intent, tt.language, match.Intent, match.Language)
+			}
+		})
+	}
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	router, err := NewRouter(DefaultRules())
+	if err != nil {
+		t.Fatalf("error creating router: %v", err)
+	}
+
+	var handled string
+	router.Handle(IntentHelp, func(session *conversation.Session, event conversation.Event, match Match) (conversation.State, error) {
+		handled = match.Intent
+		return conversation.StateIdle, nil
+	})
+	router.Fallback(func(session *conversation.Session, event conversation.Event, match Match) (conversation.State, error) {
+		handled = "fallback"
+		return conversation.StateIdle, nil
+	})
+
+	tests := []struct {
+		text    string
+		payload string
+		handled string
+	}{
+		{text: "help", handled: IntentHelp},
+		{text: "Menu", payload: IntentHelp, handled: IntentHelp},
+		{text: "Yes", payload: "unknown", handled: "fallback"},
+		{text: "netice", handled: "fallback"},
+		{text: "salam", handled: "fallback"},
+	}
+
+	for _, tt := range tests {
+		handled = ""
+		_, err := router.Dispatch(&conversation.Session{}, conversation.Event{Text: tt.text, Payload: tt.payload})
+		if err != nil {
+			t.Fatalf("error dispatching %q: %v", tt.text, err)
+		}
+		if handled != tt.handled {
+			t.Errorf("%q: expected %s handler, got %s", tt.text, tt.handled, handled)
+		}
+	}
+}
+
+func TestNewRouter_InvalidPattern(t *testing.T) {
+	_, err := NewRouter([]Rule{{Intent: IntentHelp, Patterns: []string{"("}}})
+	if err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,341 @@
+package messagelog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+	StatusFailed    = "failed"
+)
+
+// statusRanks orders the statuses, a status never moves to a lower rank.
+// A message is either read or failed, so they share the last rank.
+var statusRanks = map[string]int{
+	StatusSent:      1,
+	StatusDelivered: 2,
+	StatusRead:      3,
+	StatusFailed:    3,
+}
+
+var (
+	ErrNotFound = errors.New("message not found")
+)
+
+// InboundRecord is a message received from a contact.
+// The MediaRef is the provider ID of the attached media, if any.
+type InboundRecord struct {
+	WaMID     string    `json:"wamid"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Type      string    `json:"type"`
+	Body      string    `json:"body"`
+	MediaRef  string    `json:"media_ref,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// OutboundRecord is a message sent to a contact.
+// The ProviderID is empty when the provider rejected the message.
+// The Summary is shortened for the reports, the Body keeps the full text
+// or the document link. A template sent instead of a message because the
+// window was closed Replaces the type of that message and keeps its Body.
+type OutboundRecord struct {
+	ProviderID string    `json:"provider_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Type       string    `json:"type"`
+	Summary    string    `json:"summary"`
+	Body       string    `json:"-"`
+	Replaces   string    `json:"replaces,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+
+	// FallbackAt is the time the message was sent again on the fallback channel
+	FallbackAt *time.Time `json:"fallback_at,omitempty"`
+}
+
+// StatusUpdate is a status webhook of an outbound message
+type StatusUpdate struct {
+	ProviderID string
+	Status     string
+	Timestamp  time.Time
+	Error      string
+}
+
+var migrations = []string{
+	`CREATE TABLE inbound_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		wamid TEXT NOT NULL UNIQUE,
+		sender TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		type TEXT NOT NULL,
+		body TEXT NOT NULL,
+		media_ref TEXT NOT NULL,
+		timestamp TIMESTAMP NOT NULL,
+		received_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX inbound_messages_sender ON inbound_messages (sender);
+	CREATE TABLE outbound_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		type TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX outbound_messages_provider_id ON outbound_messages (provider_id);
+	CREATE INDEX outbound_messages_recipient ON outbound_messages (recipient);`,
+	`ALTER TABLE outbound_messages ADD COLUMN sent_at TIMESTAMP;
+	ALTER TABLE outbound_messages ADD COLUMN delivered_at TIMESTAMP;
+	ALTER TABLE outbound_messages ADD COLUMN read_at TIMESTAMP;
+	ALTER TABLE outbound_messages ADD COLUMN failed_at TIMESTAMP;`,
+	`ALTER TABLE outbound_messages ADD COLUMN fallback_at TIMESTAMP;`,
+	`ALTER TABLE outbound_messages ADD COLUMN body TEXT NOT NULL DEFAULT '';
+	ALTER TABLE outbound_messages ADD COLUMN replaces TEXT NOT NULL DEFAULT '';`,
+}
+
+// statusColumns are the columns holding the time each status was reached
+var statusColumns = map[string]string{
+	StatusSent:      "sent_at",
+	StatusDelivered: "delivered_at",
+	StatusRead:      "read_at",
+	StatusFailed:    "failed_at",
+}
+
+const outboundColumns = `provider_id, sender, recipient, type, summary, body, replaces, status, error, created_at, sent_at, delivered_at, read_at, failed_at, fallback_at`
+
+// Repository stores the messages in SQLite
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "messagelog", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// SaveInbound stores the inbound message, webhooks delivered twice are stored once
+func (r *Repository) SaveInbound(record InboundRecord) error {
+	_, err := r.db.Exec(`INSERT INTO inbound_messages (wamid, sender, recipient, type, body, media_ref, timestamp, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (wamid) DO NOTHING`,
+		record.WaMID, record.From, record.To, record.Type, record.Body, record.MediaRef, record.Timestamp.UTC(), time.Now().UTC())
+	return err
+}
+
+func (r *Repository) SaveOutbound(record OutboundRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.Exec(`INSERT INTO outbound_messages (provider_id, sender, recipient, type, summary, body, replaces, status, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ProviderID, record.From, record.To, record.Type, record.Summary, record.Body, record.Replaces, record.Status, record.Error, record.CreatedAt.UTC())
+	return err
+}
+
+// SetReplaced records that the template with the provider ID was sent instead
+// of the message of the type with the body, so the fallback can send that message
+func (r *Repository) SetReplaced(providerID, messageType, body string) error {
+	result, err := r.db.Exec(`UPDATE outbound_messages SET replaces = ?, body = ? WHERE provider_id = ?`,
+		messageType, body, providerID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Inbound returns the inbound messages of the contact, oldest first
+func (r *Repository) Inbound(from string) ([]InboundRecord, error) {
+	rows, err := r.db.Query(`SELECT wamid, sender, recipient, type, body, media_ref, timestamp
+		FROM inbound_messages WHERE sender = ? ORDER BY timestamp, id`, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []InboundRecord{}
+	for rows.Next() {
+		var record InboundRecord
+		err = rows.Scan(&record.WaMID, &record.From, &record.To, &record.Type, &record.Body, &record.MediaRef, &record.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// Outbound returns the outbound message with the provider ID
+func (r *Repository) Outbound(providerID string) (OutboundRecord, error) {
+	row := r.db.QueryRow(`SELECT `+outboundColumns+` FROM outbound_messages WHERE provider_id = ?`, providerID)
+	record, err := scanOutbound(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return record, ErrNotFound
+	}
+
+	return record, err
+}
+
+// OutboundTo returns the outbound messages of the type sent to the recipient, newest first.
+// All the types are returned when messageType is empty.
+func (r *Repository) OutboundTo(recipient, messageType string) ([]OutboundRecord, error) {
+	rows, err := r.db.Query(`SELECT `+outboundColumns+` FROM outbound_messages
+		WHERE recipient = ? AND (? = '' OR type = ?) ORDER BY created_at DESC, id DESC`, recipient, messageType, messageType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []OutboundRecord{}
+	for rows.Next() {
+		record, err := scanOutbound(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// Undelivered returns the messages accepted by the provider between after and before
+// which are neither delivered nor sent on the fallback channel, oldest first
+func (r *Repository) Undelivered(after, before time.Time) ([]OutboundRecord, error) {
+	rows, err := r.db.Query(`SELECT `+outboundColumns+` FROM outbound_messages
+		WHERE status = ? AND provider_id != '' AND fallback_at IS NULL AND created_at >= ? AND created_at < ?
+		ORDER BY created_at, id`, StatusSent, after.UTC(), before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []OutboundRecord{}
+	for rows.Next() {
+		record, err := scanOutbound(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// MarkFallback records that the message is sent on the fallback channel.
+// It returns false when the message was already marked, so it is sent once.
+func (r *Repository) MarkFallback(providerID string, at time.Time) (bool, error) {
+	result, err := r.db.Exec(`UPDATE outbound_messages SET fallback_at = ? WHERE provider_id = ? AND fallback_at IS NULL`,
+		at.UTC(), providerID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// UpdateStatus applies the status webhook to the outbound message.
+// Webhooks are not delivered in order, so the status never moves back,
+// e.g. a delivered webhook arriving after the read webhook only records its time.
+func (r *Repository) UpdateStatus(update StatusUpdate) error {
+	column, ok := statusColumns[update.Status]
+	if !ok {
+		return fmt.Errorf("unknown status %q", update.Status)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(`SELECT status FROM outbound_messages WHERE provider_id = ?`, update.ProviderID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE outbound_messages SET `+column+` = COALESCE(`+column+`, ?) WHERE provider_id = ?`,
+		update.Timestamp.UTC(), update.ProviderID)
+	if err != nil {
+		return err
+	}
+
+	if statusRanks[update.Status] > statusRanks[current] {
+		_, err = tx.Exec(`UPDATE outbound_messages SET status = ?, error = CASE WHEN ? = '' THEN error ELSE ? END WHERE provider_id = ?`,
+			update.Status, update.Error, update.Error, update.ProviderID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOutbound(row scanner) (OutboundRecord, error) {
+	var record OutboundRecord
+	var sentAt, deliveredAt, readAt, failedAt, fallbackAt sql.NullTime
+	err := row.Scan(&record.ProviderID, &record.From, &record.To, &record.Type, &record.Summary, &record.Body, &record.Replaces, &record.Status, &record.Error,
+		&record.CreatedAt, &sentAt, &deliveredAt, &readAt, &failedAt, &fallbackAt)
+	if err != nil {
+		return record, err
+	}
+
+	record.SentAt = nullTime(sentAt)
+	record.DeliveredAt = nullTime(deliveredAt)
+	record.ReadAt = nullTime(readAt)
+	record.FailedAt = nullTime(failedAt)
+	record.FallbackAt = nullTime(fallbackAt)
+	return record, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
@@ -0,0 +1,231 @@
+package messagelog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	return repository
+}
+
+func TestRepository_SaveInbound(t *testing.T) {
+	repository := newTestRepository(t)
+	record := InboundRecord{
+		WaMID:     "wamid.1",
+		From:      "994503981865",
+		To:        "15550909792",
+		Type:      "text",
+		Body:      "netice",
+		Timestamp: time.Unix(1681899808, 0).UTC(),
+	}
+
+	// The same webhook delivered twice
+	for i := 0; i < 2; i++ {
+		err := repository.SaveInbound(record)
+		if err != nil {
+			t.Fatalf("error saving inbound message: %v", err)
+		}
+	}
+
+	records, err := repository.Inbound("994503981865")
+	if err != nil {
+		t.Fatalf("error loading inbound messages: %v", err)
+	}
+	if len(records) != 1 || records[0] != record {
+		t.Errorf("expected %+v, got %+v", record, records)
+	}
+}
+
+func TestRepository_SaveOutbound(t *testing.T) {
+	repository := newTestRepository(t)
+	record := OutboundRecord{
+		ProviderID: "wamid.2",
+		From:       "15550909792",
+		To:         "994503981865",
+		Type:       "document",
+		Summary:    "https://example.com/api/v1/994503981865/document",
+		Body:       "https://example.com/api/v1/994503981865/document",
+		Status:     StatusSent,
+		CreatedAt:  time.Unix(1681899808, 0).UTC(),
+	}
+
+	err := repository.SaveOutbound(record)
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	stored, err := repository.Outbound("wamid.2")
+	if err != nil {
+		t.Fatalf("error loading outbound message: %v", err)
+	}
+	if stored != record {
+		t.Errorf("expected %+v, got %+v", record, stored)
+	}
+
+	_, err = repository.Outbound("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepository_UpdateStatus(t *testing.T) {
+	repository := newTestRepository(t)
+	sent := time.Unix(1681899808, 0).UTC()
+	err := repository.SaveOutbound(OutboundRecord{
+		ProviderID: "wamid.2",
+		From:       "15550909792",
+		To:         "994503981865",
+		Type:       "document",
+		Status:     StatusSent,
+		CreatedAt:  sent,
+	})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	// The delivered webhook arrives after the read webhook
+	updates := []StatusUpdate{
+		{ProviderID: "wamid.2", Status: StatusSent, Timestamp: sent},
+		{ProviderID: "wamid.2", Status: StatusRead, Timestamp: sent.Add(2 * time.Minute)},
+		{ProviderID: "wamid.2", Status: StatusDelivered, Timestamp: sent.Add(time.Minute)},
+		{ProviderID: "wamid.2", Status: StatusRead, Timestamp: sent.Add(3 * time.Minute)},
+	}
+	for _, update := range updates {
+		err = repository.UpdateStatus(update)
+		if err != nil {
+			t.Fatalf("error updating status: %v", err)
+		}
+	}
+
+	records, err := repository.OutboundTo("994503981865", "document")
+	if err != nil {
+		t.Fatalf("error loading outbound messages: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %+v", records)
+	}
+
+	record := records[0]
+	if record.Status != StatusRead {
+		t.Errorf("expected status read, got %s", record.Status)
+	}
+	if record.DeliveredAt == nil || !record.DeliveredAt.Equal(sent.Add(time.Minute)) {
+		t.Errorf("unexpected delivered time: %v", record.DeliveredAt)
+	}
+	if record.ReadAt == nil || !record.ReadAt.Equal(sent.Add(2*time.Minute)) {
+		t.Errorf("expected the first read time to be kept, got %v", record.ReadAt)
+	}
+
+	err = repository.UpdateStatus(StatusUpdate{ProviderID: "unknown", Status: StatusRead, Timestamp: sent})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepository_UpdateStatus_Failed(t *testing.T) {
+	repository := newTestRepository(t)
+	sent := time.Unix(1681899808, 0).UTC()
+	err := repository.SaveOutbound(OutboundRecord{ProviderID: "wamid.3", To: "994503981865", Type: "text", Status: StatusSent, CreatedAt: sent})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	err = repository.UpdateStatus(StatusUpdate{ProviderID: "wamid.3", Status: StatusFailed, Timestamp: sent, Error: "131026: Message undeliverable"})
+	if err != nil {
+		t.Fatalf("error updating status: %v", err)
+	}
+
+	record, err := repository.Outbound("wamid.3")
+	if err != nil {
+		t.Fatalf("error loading outbound message: %v", err)
+	}
+	if record.Status != StatusFailed || record.Error != "131026: Message undeliverable" || record.FailedAt == nil {
+		t.Errorf("unexpected record: %+v", record)
+	}
+}
+
+func TestRepository_Undelivered(t *testing.T) {
+	repository := newTestRepository(t)
+	sent := time.Unix(1681899808, 0).UTC()
+	for _, record := range []OutboundRecord{
+		{ProviderID: "wamid.1", To: "994503981865", Type: "text", Status: StatusSent, CreatedAt: sent},
+		{ProviderID: "wamid.2", To: "994503981865", Type: "text", Status: StatusSent, CreatedAt: sent.Add(time.Minute)},
+		{ProviderID: "wamid.3", To: "994503981865", Type: "text", Status: StatusSent, CreatedAt: sent.Add(time.Hour)},
+		{ProviderID: "wamid.4", To: "994503981865", Type: "text", Status: StatusSent, CreatedAt: sent.Add(-48 * time.Hour)},
+		{To: "994503981865", Type: "text", Status: StatusFailed, CreatedAt: sent},
+	} {
+		err := repository.SaveOutbound(record)
+		if err != nil {
+			t.Fatalf("error saving outbound message: %v", err)
+		}
+	}
+
+	err := repository.UpdateStatus(StatusUpdate{ProviderID: "wamid.2", Status: StatusDelivered, Timestamp: sent.Add(2 * time.Minute)})
+	if err != nil {
+		t.Fatalf("error updating status: %v", err)
+	}
+
+	undelivered, err := repository.Undelivered(sent.Add(-24*time.Hour), sent.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("error loading undelivered messages: %v", err)
+	}
+	if len(undelivered) != 1 || undelivered[0].ProviderID != "wamid.1" {
+		t.Fatalf("expected wamid.1 to be undelivered, got %+v", undelivered)
+	}
+
+	marked, err := repository.MarkFallback("wamid.1", sent.Add(30*time.Minute))
+	if err != nil || !marked {
+		t.Fatalf("expected the message to be marked, got %v %v", marked, err)
+	}
+	marked, err = repository.MarkFallback("wamid.1", sent.Add(31*time.Minute))
+	if err != nil || marked {
+		t.Errorf("expected the message to be marked once, got %v %v", marked, err)
+	}
+
+	undelivered, err = repository.Undelivered(sent.Add(-24*time.Hour), sent.Add(30*time.Minute))
+	if err != nil || len(undelivered) != 0 {
+		t.Errorf("expected no undelivered messages after the fallback, got %+v %v", undelivered, err)
+	}
+}
+
+func TestRepository_SetReplaced(t *testing.T) {
+	repository := newTestRepository(t)
+	err := repository.SaveOutbound(OutboundRecord{ProviderID: "wamid.5", To: "994503981865", Type: "template", Summary: "results_ready", Status: StatusSent})
+	if err != nil {
+		t.Fatalf("error saving outbound message: %v", err)
+	}
+
+	err = repository.SetReplaced("wamid.5", "text", "Your results are ready")
+	if err != nil {
+		t.Fatalf("error recording the replaced message: %v", err)
+	}
+
+	record, err := repository.Outbound("wamid.5")
+	if err != nil {
+		t.Fatalf("error loading outbound message: %v", err)
+	}
+	if record.Replaces != "text" || record.Body != "Your results are ready" || record.Summary != "results_ready" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+
+	err = repository.SetReplaced("unknown", "text", "Your results are ready")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
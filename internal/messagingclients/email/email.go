@@ -0,0 +1,207 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// The TLS modes of the SMTP connection
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS, the server must support it
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, usually on port 465
+	TLSImplicit = "tls"
+	// TLSNone sends in plain text, only for local relays
+	TLSNone = "none"
+)
+
+// sendTimeout bounds the whole SMTP exchange of a message, a stalled server
+// must not hold the caller forever
+const sendTimeout = time.Minute
+
+var ErrStartTLSNotSupported = errors.New("smtp server doesn't support STARTTLS")
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an email with a plain text body
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Sender sends the emails through an SMTP server
+type Sender struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	TLS      string
+	// TLSConfig is used for the TLS connection, the server name is verified by default
+	TLSConfig *tls.Config
+}
+
+// NewSender returns a sender using the TLS mode, plain auth is used when a username is given
+func NewSender(addr, username, password, from, tlsMode string) *Sender {
+	if tlsMode == "" {
+		tlsMode = TLSStartTLS
+	}
+
+	return &Sender{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		From:     from,
+		TLS:      tlsMode,
+	}
+}
+
+// Send sends the message to all its recipients
+func (s *Sender) Send(message Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+
+	var conn net.Conn
+	if s.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", s.Addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", s.Addr, 10*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(sendTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return ErrStartTLSNotSupported
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(s.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range message.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(s.compose(message))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// compose returns the MIME message, the attachments are base64 encoded parts
+func (s *Sender) compose(message Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(message.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+
+	if len(message.Attachments) == 0 {
+		fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n")
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&b, []byte(message.Body))
+		return b.Bytes()
+	}
+
+	boundary := newBoundary()
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: base64\r\n\r\n")
+	writeBase64(&b, []byte(message.Body))
+
+	for _, attachment := range message.Attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s\r\n", mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename}))
+		fmt.Fprintf(&b, "Content-Disposition: %s\r\n", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&b, attachment.Data)
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+
+	return b.Bytes()
+}
+
+// writeBase64 writes the data base64 encoded in lines of 76 characters
+func writeBase64(b *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+}
+
+func newBoundary() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return fmt.Sprintf("%x", buf[:])
+}
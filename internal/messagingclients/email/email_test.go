@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// smtpServer is an in-process SMTP server keeping the received messages
+type smtpServer struct {
+	listener net.Listener
+	startTLS bool
+	messages chan []byte
+}
+
+func newSMTPServer(t *testing.T, startTLS bool) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpServer{listener: listener, startTLS: startTLS, messages: make(chan []byte, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			if s.startTLS {
+				reply("250-localhost")
+				reply("250 STARTTLS")
+			} else {
+				reply("250 localhost")
+			}
+		case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.messages <- data.Bytes()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSender_Send(t *testing.T) {
+	server := newSMTPServer(t, false)
+	sender := NewSender(server.listener.Addr().String(), "", "", "lab@example.com", TLSNone)
+
+	pdf := []byte("%PDF-1.4 results")
+	err := sender.Send(Message{
+		To:          []string{"patient@example.com"},
+		Subject:     "Analiz nəticələriniz",
+		Body:        "Hörmətli T.A. Analiz nəticələriniz hazırdır.",
+		Attachments: []Attachment{{Filename: "994503981865.pdf", ContentType: "application/pdf", Data: pdf}},
+	})
+	if err != nil {
+		t.Fatalf("error sending email: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(<-server.messages))
+	if err != nil {
+		t.Fatalf("error reading email: %v", err)
+	}
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "Analiz nəticələriniz" || msg.Header.Get("To") != "patient@example.com" {
+		t.Errorf("unexpected headers %+v", msg.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	var parts [][]byte
+	var filename string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		parts = append(parts, data)
+		if part.FileName() != "" {
+			filename = part.FileName()
+		}
+	}
+
+	if len(parts) != 2 || string(parts[0]) != "Hörmətli T.A. Analiz nəticələriniz hazırdır." || !bytes.Equal(parts[1], pdf) {
+		t.Errorf("unexpected parts %q", parts)
+	}
+	if filename != "994503981865.pdf" {
+		t.Errorf("unexpected attachment name %q", filename)
+	}
+}
+
+func TestSender_StartTLSRequired(t *testing.T) {
+	server := newSMTPServer(t, false)
+	sender := NewSender(server.listener.Addr().String(), "lab", "secret", "lab@example.com", TLSStartTLS)
+
+	err := sender.Send(Message{To: []string{"patient@example.com"}, Subject: "Results", Body: "Ready"})
+	if !errors.Is(err, ErrStartTLSNotSupported) {
+		t.Errorf("expected ErrStartTLSNotSupported, got %v", err)
+	}
+}
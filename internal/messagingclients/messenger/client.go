@@ -0,0 +1,352 @@
+package messenger
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+const (
+	// ChannelMessenger is the channel of the Facebook page conversations
+	ChannelMessenger = "messenger"
+	// ChannelInstagram is the channel of the Instagram direct messages
+	ChannelInstagram = "instagram"
+
+	// Endpoint is the endpoint of the Send API
+	Endpoint = "https://graph.facebook.com/v16.0/"
+
+	// SignatureHeader carries the HMAC-SHA256 of the webhook body keyed by the app secret
+	SignatureHeader = "X-Hub-Signature-256"
+
+	// maxQuickReplies is the most quick replies a message can have
+	maxQuickReplies = 13
+)
+
+// Objects maps the objects of the Meta webhooks to their channel
+var Objects = map[string]string{
+	"page":      ChannelMessenger,
+	"instagram": ChannelInstagram,
+}
+
+// Client answers the Messenger or Instagram conversations of a page through
+// the Send API. The contacts are addressed by their page-scoped ID.
+type Client struct {
+	channel   string
+	PageToken string
+	AppSecret string
+	endpoint  string
+	client    *http.Client
+}
+
+// NewClient returns a client of the channel, ChannelMessenger or ChannelInstagram,
+// sending with the page access token. The webhook requests must be signed
+// with the appSecret, every request is rejected if it is empty.
+func NewClient(channel, pageToken, appSecret, endpoint string) *Client {
+	return &Client{
+		channel:   channel,
+		PageToken: pageToken,
+		AppSecret: appSecret,
+		endpoint:  endpoint,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Name() string {
+	return c.channel
+}
+
+type Recipient struct {
+	ID string `json:"id"`
+}
+
+type AttachmentPayload struct {
+	URL        string `json:"url"`
+	IsReusable bool   `json:"is_reusable,omitempty"`
+}
+
+type Attachment struct {
+	Type    string            `json:"type"`
+	Payload AttachmentPayload `json:"payload"`
+}
+
+// QuickReply is a button under the message, the "user_phone_number"
+// quick reply offers the phone number of the Facebook profile
+type QuickReply struct {
+	ContentType string `json:"content_type"`
+	Title       string `json:"title,omitempty"`
+	Payload     string `json:"payload,omitempty"`
+}
+
+type OutboundMessage struct {
+	Text         string       `json:"text,omitempty"`
+	Attachment   *Attachment  `json:"attachment,omitempty"`
+	QuickReplies []QuickReply `json:"quick_replies,omitempty"`
+}
+
+type SendRequest struct {
+	Recipient     Recipient       `json:"recipient"`
+	MessagingType string          `json:"messaging_type"`
+	Message       OutboundMessage `json:"message"`
+}
+
+type SendResponse struct {
+	RecipientID string `json:"recipient_id"`
+	MessageID   string `json:"message_id"`
+	Error       *struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// SendText answers with a text, the Send API can't quote a message
+func (c *Client) SendText(recipientID, text, replyTo string) error {
+	return c.send(recipientID, OutboundMessage{Text: text})
+}
+
+// SendDocument sends the document at the URL as a file followed by the caption
+func (c *Client) SendDocument(recipientID, documentURL, caption, replyTo string) error {
+	err := c.send(recipientID, OutboundMessage{Attachment: &Attachment{
+		Type:    "file",
+		Payload: AttachmentPayload{URL: documentURL},
+	}})
+	if err != nil {
+		return err
+	}
+
+	if caption == "" {
+		return nil
+	}
+
+	return c.SendText(recipientID, caption, replyTo)
+}
+
+// Send translates the message to the Send API
+func (c *Client) Send(message channel.OutboundMessage) error {
+	switch message.Type {
+	case channel.MessageTypeDocument:
+		return c.SendDocument(message.RecipientID, message.DocumentURL, message.Text, message.ReplyTo)
+	case channel.MessageTypeKeyboard:
+		return c.SendKeyboard(message.RecipientID, message.Text, message.Buttons, message.ReplyTo)
+	case channel.MessageTypePhoneRequest:
+		return c.SendPhoneRequest(message.RecipientID, message.Text, message.ReplyTo)
+	}
+
+	return c.SendText(message.RecipientID, message.Text, message.ReplyTo)
+}
+
+// SendPhoneRequest sends the text with the quick reply sharing the phone number
+// of the profile. Instagram has no such quick reply.
+func (c *Client) SendPhoneRequest(recipientID, text, replyTo string) error {
+	if c.channel != ChannelMessenger {
+		return fmt.Errorf("%s: %w", c.channel, channel.ErrPhoneRequestUnsupported)
+	}
+
+	return c.send(recipientID, OutboundMessage{
+		Text:         text,
+		QuickReplies: []QuickReply{{ContentType: "user_phone_number"}},
+	})
+}
+
+// SendKeyboard sends the text with the buttons as quick replies
+func (c *Client) SendKeyboard(recipientID, text string, buttons []channel.Button, replyTo string) error {
+	message := OutboundMessage{Text: text}
+	for i, button := range buttons {
+		if i == maxQuickReplies {
+			break
+		}
+		message.QuickReplies = append(message.QuickReplies, QuickReply{
+			ContentType: "text",
+			Title:       button.Title,
+			Payload:     button.ID,
+		})
+	}
+
+	return c.send(recipientID, message)
+}
+
+func (c *Client) send(recipientID string, message OutboundMessage) error {
+	payload, err := json.Marshal(SendRequest{
+		Recipient:     Recipient{ID: recipientID},
+		MessagingType: "RESPONSE",
+		Message:       message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+"me/messages", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.PageToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response SendResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("%s send: %s: %w", c.channel, resp.Status, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s send: %d: %s", c.channel, response.Error.Code, response.Error.Message)
+	}
+
+	return nil
+}
+
+type Webhook struct {
+	Object string  `json:"object"`
+	Entry  []Entry `json:"entry"`
+}
+
+type Entry struct {
+	ID        string      `json:"id"`
+	Time      int64       `json:"time"`
+	Messaging []Messaging `json:"messaging"`
+}
+
+// Messaging is an event of a conversation, a message or a pressed button
+type Messaging struct {
+	Sender    Recipient        `json:"sender"`
+	Recipient Recipient        `json:"recipient"`
+	Timestamp int64            `json:"timestamp"`
+	Message   *InboundMessage  `json:"message"`
+	Postback  *InboundPostback `json:"postback"`
+}
+
+type InboundMessage struct {
+	MID         string `json:"mid"`
+	Text        string `json:"text"`
+	IsEcho      bool   `json:"is_echo"`
+	Attachments []struct {
+		Type    string `json:"type"`
+		Payload struct {
+			URL string `json:"url"`
+		} `json:"payload"`
+	} `json:"attachments"`
+	QuickReply *struct {
+		Payload string `json:"payload"`
+	} `json:"quick_reply"`
+	ReplyTo *struct {
+		MID string `json:"mid"`
+	} `json:"reply_to"`
+}
+
+type InboundPostback struct {
+	MID     string `json:"mid"`
+	Title   string `json:"title"`
+	Payload string `json:"payload"`
+}
+
+// attachmentTypes maps the attachment types to the WhatsApp message types
+var attachmentTypes = map[string]string{
+	"image":    "image",
+	"audio":    "audio",
+	"video":    "video",
+	"file":     "document",
+	"location": "location",
+}
+
+// ParseUpdate verifies the signature of the webhook and translates the messages
+// and postbacks. The echoes of the messages sent by the page are skipped.
+func (c *Client) ParseUpdate(r *http.Request) ([]channel.InboundMessage, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AppSecret == "" || !c.validSignature(body, r.Header.Get(SignatureHeader)) {
+		return nil, channel.ErrUnauthorized
+	}
+
+	var webhook Webhook
+	err = json.Unmarshal(body, &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s webhook: %w", c.channel, err)
+	}
+
+	var messages []channel.InboundMessage
+	for _, entry := range webhook.Entry {
+		for _, event := range entry.Messaging {
+			m := channel.InboundMessage{
+				Channel:   c.channel,
+				SenderID:  event.Sender.ID,
+				Recipient: event.Recipient.ID,
+				Type:      "text",
+				Time:      time.UnixMilli(event.Timestamp),
+			}
+
+			switch {
+			case event.Message != nil && !event.Message.IsEcho:
+				message := event.Message
+				m.MessageID = message.MID
+				m.Text = message.Text
+				if message.QuickReply != nil {
+					m.Payload = message.QuickReply.Payload
+					m.Phone = c.sharedPhone(message)
+				}
+				if message.ReplyTo != nil {
+					m.ReplyTo = message.ReplyTo.MID
+				}
+				for _, attachment := range message.Attachments {
+					m.Media = append(m.Media, channel.MediaRef{URL: attachment.Payload.URL})
+				}
+				if len(message.Attachments) > 0 && message.Text == "" {
+					m.Type = "unsupported"
+					if messageType, ok := attachmentTypes[message.Attachments[0].Type]; ok {
+						m.Type = messageType
+					}
+				}
+			case event.Postback != nil:
+				m.MessageID = event.Postback.MID
+				m.Type = "interactive"
+				m.Text = event.Postback.Title
+				m.Payload = event.Postback.Payload
+			default:
+				continue
+			}
+
+			messages = append(messages, m)
+		}
+	}
+
+	return messages, nil
+}
+
+// sharedPhone returns the phone number of the user_phone_number quick reply,
+// it is sent as both the text and the payload. The payloads of the other
+// quick replies are our button IDs, and a typed text has no payload.
+func (c *Client) sharedPhone(message *InboundMessage) string {
+	if c.channel != ChannelMessenger || message.Text != message.QuickReply.Payload {
+		return ""
+	}
+
+	return channel.NormalizePhone(message.QuickReply.Payload)
+}
+
+// validSignature tells whether the signature is the HMAC-SHA256 of the body keyed by the app secret
+func (c *Client) validSignature(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.AppSecret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
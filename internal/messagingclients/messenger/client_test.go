@@ -0,0 +1,200 @@
+package messenger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSendDocument_Success(t *testing.T) {
+	var payloads []SendRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/messages" || r.Header.Get("Authorization") != "Bearer token" {
+			fmt.Fprint(w, `{"error":{"message":"Invalid OAuth access token.","code":190}}`)
+			return
+		}
+		var payload SendRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		fmt.Fprint(w, `{"recipient_id":"6054128371239","message_id":"m_AG5Hz2Uq7tuwNEhXfYYKj8mJEM"}`)
+	}))
+	defer server.Close()
+
+	client := NewClient(ChannelMessenger, "token", "", server.URL+"/")
+	err := client.SendDocument("6054128371239", "https://example.com/api/v1/994503981865/document", "Your results", "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("expected the file and the caption, got %+v", payloads)
+	}
+	file := payloads[0]
+	if file.Recipient.ID != "6054128371239" || file.MessagingType != "RESPONSE" || file.Message.Attachment == nil || file.Message.Attachment.Type != "file" {
+		t.Errorf("unexpected file message %+v", file)
+	}
+	if payloads[1].Message.Text != "Your results" {
+		t.Errorf("unexpected caption message %+v", payloads[1])
+	}
+}
+
+func TestSendKeyboard_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"error":{"message":"This message is sent outside of allowed window.","code":10}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient(ChannelInstagram, "token", "", server.URL+"/")
+	err := client.SendKeyboard("6054128371239", "Choose", []channel.Button{{ID: "language:en", Title: "English"}}, "")
+	if err == nil || !strings.Contains(err.Error(), "outside of allowed window") {
+		t.Errorf("expected the error message, got %v", err)
+	}
+}
+
+func TestSend_PhoneRequest(t *testing.T) {
+	var payload SendRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"recipient_id":"6054128371239","message_id":"m_1"}`)
+	}))
+	defer server.Close()
+
+	message := channel.OutboundMessage{
+		RecipientID: "6054128371239",
+		Type:        channel.MessageTypePhoneRequest,
+		Text:        "Please share your phone number",
+		Buttons:     []channel.Button{{ID: "share_phone", Title: "Share phone number"}},
+	}
+	err := NewClient(ChannelMessenger, "token", "", server.URL+"/").Send(message)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	replies := payload.Message.QuickReplies
+	if len(replies) != 1 || replies[0].ContentType != "user_phone_number" {
+		t.Errorf("expected the phone number quick reply, got %+v", payload)
+	}
+
+	err = NewClient(ChannelInstagram, "token", "", server.URL+"/").Send(message)
+	if !errors.Is(err, channel.ErrPhoneRequestUnsupported) {
+		t.Errorf("expected ErrPhoneRequestUnsupported on instagram, got %v", err)
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	client := NewClient(ChannelMessenger, "token", "secret", Endpoint)
+
+	tests := []struct {
+		name    string
+		body    string
+		sign    string
+		updates []channel.InboundMessage
+		err     error
+	}{
+		{
+			name:    "text",
+			body:    `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":1681899808000,"message":{"mid":"m_1","text":"netice","reply_to":{"mid":"m_0"}}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "6054128371239", Recipient: "1029384756", MessageID: "m_1", Type: "text", Text: "netice", ReplyTo: "m_0"}},
+		},
+		{
+			name:    "quick reply",
+			body:    `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":1681899808000,"message":{"mid":"m_2","text":"English","quick_reply":{"payload":"language:en"}}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "6054128371239", Recipient: "1029384756", MessageID: "m_2", Type: "text", Text: "English", Payload: "language:en"}},
+		},
+		{
+			name:    "shared phone",
+			body:    `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":1681899808000,"message":{"mid":"m_7","text":"+994503981865","quick_reply":{"payload":"+994503981865"}}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "6054128371239", Recipient: "1029384756", MessageID: "m_7", Type: "text", Text: "+994503981865", Payload: "+994503981865", Phone: "994503981865"}},
+		},
+		{
+			name:    "typed phone",
+			body:    `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":1681899808000,"message":{"mid":"m_8","text":"+994503981865"}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "6054128371239", Recipient: "1029384756", MessageID: "m_8", Type: "text", Text: "+994503981865"}},
+		},
+		{
+			name:    "postback",
+			body:    `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"recipient":{"id":"1029384756"},"timestamp":1681899808000,"postback":{"mid":"m_3","title":"English","payload":"language:en"}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "6054128371239", Recipient: "1029384756", MessageID: "m_3", Type: "interactive", Text: "English", Payload: "language:en"}},
+		},
+		{
+			name:    "image",
+			body:    `{"object":"instagram","entry":[{"id":"17841400000000","time":1681899808000,"messaging":[{"sender":{"id":"3412908765"},"recipient":{"id":"17841400000000"},"timestamp":1681899808000,"message":{"mid":"m_4","attachments":[{"type":"image","payload":{"url":"https://example.com/p.jpg"}}]}}]}]}`,
+			sign:    "secret",
+			updates: []channel.InboundMessage{{Channel: ChannelMessenger, SenderID: "3412908765", Recipient: "17841400000000", MessageID: "m_4", Type: "image", Media: []channel.MediaRef{{URL: "https://example.com/p.jpg"}}}},
+		},
+		{
+			name: "echo",
+			body: `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"1029384756"},"recipient":{"id":"6054128371239"},"timestamp":1681899808000,"message":{"mid":"m_5","text":"Salam","is_echo":true}}]}]}`,
+			sign: "secret",
+		},
+		{
+			name: "forged",
+			body: `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"timestamp":1681899808000,"message":{"mid":"m_6","text":"netice"}}]}]}`,
+			sign: "guess",
+			err:  channel.ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader(tt.body))
+			r.Header.Set(SignatureHeader, sign(tt.sign, tt.body))
+
+			updates, err := client.ParseUpdate(r)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error parsing webhook: %v", err)
+			}
+			if len(updates) != len(tt.updates) {
+				t.Fatalf("expected %+v, got %+v", tt.updates, updates)
+			}
+			for i := range updates {
+				if updates[i].Time.Unix() != 1681899808 {
+					t.Errorf("unexpected time %v", updates[i].Time)
+				}
+				updates[i].Time = tt.updates[i].Time
+				if !reflect.DeepEqual(updates[i], tt.updates[i]) {
+					t.Errorf("expected %+v, got %+v", tt.updates[i], updates[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseUpdate_NoAppSecret(t *testing.T) {
+	client := NewClient(ChannelMessenger, "token", "", Endpoint)
+
+	body := `{"object":"page","entry":[{"id":"1029384756","time":1681899808000,"messaging":[{"sender":{"id":"6054128371239"},"timestamp":1681899808000,"message":{"mid":"m_1","text":"+994503981865","quick_reply":{"payload":"+994503981865"}}}]}]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/hook", strings.NewReader(body))
+	r.Header.Set(SignatureHeader, sign("", body))
+
+	_, err := client.ParseUpdate(r)
+	if !errors.Is(err, channel.ErrUnauthorized) {
+		t.Errorf("expected %v without an app secret, got %v", channel.ErrUnauthorized, err)
+	}
+}
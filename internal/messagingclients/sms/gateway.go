@@ -0,0 +1,138 @@
+package sms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+// Channel is the name of the SMS channel
+const Channel = "sms"
+
+// ErrInboundNotSupported is returned for webhook requests, the gateway only sends
+var ErrInboundNotSupported = errors.New("sms gateway doesn't receive messages")
+
+// Gateway sends text messages through an HTTP SMS gateway.
+//
+// The URL and body templates may contain the {to} and {text} placeholders.
+// The values are escaped for the URL and for the body, a body starting with
+// "{" is sent as JSON and any other body as a form. The request is a GET
+// when the body template is empty.
+type Gateway struct {
+	URLTemplate  string
+	AuthHeader   string
+	AuthValue    string
+	BodyTemplate string
+	client       *http.Client
+}
+
+// NewGateway returns a gateway sending the requests built from the templates.
+// The authHeader is set to authValue on every request unless it is empty.
+func NewGateway(urlTemplate, authHeader, authValue, bodyTemplate string) *Gateway {
+	return &Gateway{
+		URLTemplate:  urlTemplate,
+		AuthHeader:   authHeader,
+		AuthValue:    authValue,
+		BodyTemplate: bodyTemplate,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (g *Gateway) Name() string {
+	return Channel
+}
+
+// SendText sends the text to the phone number, SMS can't quote a message
+func (g *Gateway) SendText(to, text, replyTo string) error {
+	req, err := g.request(to, text)
+	if err != nil {
+		return err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("sms gateway: %s: %s", resp.Status, body)
+	}
+
+	return nil
+}
+
+// SendDocument sends the caption and the URL of the document as a text
+func (g *Gateway) SendDocument(to, documentURL, caption, replyTo string) error {
+	text := documentURL
+	if caption != "" {
+		text = caption + "\n" + documentURL
+	}
+
+	return g.SendText(to, text, replyTo)
+}
+
+// Send sends the message as a text, the buttons are listed under the text
+func (g *Gateway) Send(message channel.OutboundMessage) error {
+	switch message.Type {
+	case channel.MessageTypeDocument:
+		return g.SendDocument(message.RecipientID, message.DocumentURL, message.Text, message.ReplyTo)
+	case channel.MessageTypeKeyboard:
+		return g.SendText(message.RecipientID, channel.KeyboardText(message.Text, message.Buttons), message.ReplyTo)
+	}
+
+	return g.SendText(message.RecipientID, message.Text, message.ReplyTo)
+}
+
+func (g *Gateway) ParseUpdate(r *http.Request) ([]channel.InboundMessage, error) {
+	return nil, ErrInboundNotSupported
+}
+
+// request builds the gateway request of the message
+func (g *Gateway) request(to, text string) (*http.Request, error) {
+	u := fill(g.URLTemplate, to, text, url.QueryEscape)
+
+	var req *http.Request
+	var err error
+	if g.BodyTemplate == "" {
+		req, err = http.NewRequest(http.MethodGet, u, nil)
+	} else if strings.HasPrefix(strings.TrimSpace(g.BodyTemplate), "{") {
+		req, err = http.NewRequest(http.MethodPost, u, strings.NewReader(fill(g.BodyTemplate, to, text, jsonEscape)))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodPost, u, strings.NewReader(fill(g.BodyTemplate, to, text, url.QueryEscape)))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if g.AuthHeader != "" {
+		req.Header.Set(g.AuthHeader, g.AuthValue)
+	}
+
+	return req, nil
+}
+
+// fill replaces the placeholders of the template with the escaped values
+func fill(template, to, text string, escape func(string) string) string {
+	return strings.NewReplacer("{to}", escape(to), "{text}", escape(text)).Replace(template)
+}
+
+// jsonEscape escapes the value for a JSON string, without the quotes
+func jsonEscape(value string) string {
+	b, _ := json.Marshal(value)
+	return string(b[1 : len(b)-1])
+}
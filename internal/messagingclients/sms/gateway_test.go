@@ -0,0 +1,92 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGateway_SendText(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlTemplate string
+		body        string
+		check       func(t *testing.T, r *http.Request, body []byte)
+	}{
+		{
+			name:        "json",
+			urlTemplate: "/send",
+			body:        `{"to":"+{to}","message":"{text}"}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				var payload map[string]string
+				err := json.Unmarshal(body, &payload)
+				if err != nil {
+					t.Fatalf("invalid JSON body %s: %v", body, err)
+				}
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+				}
+				if payload["to"] != "+994503981865" || payload["message"] != "Dear \"T.A\",\nyour results are ready" {
+					t.Errorf("unexpected payload %+v", payload)
+				}
+			},
+		},
+		{
+			name:        "form",
+			urlTemplate: "/send",
+			body:        "to={to}&text={text}",
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				r.ParseForm()
+				if r.PostForm.Get("to") != "994503981865" || r.PostForm.Get("text") != "Dear \"T.A\",\nyour results are ready" {
+					t.Errorf("unexpected form %+v", r.PostForm)
+				}
+			},
+		},
+		{
+			name:        "query",
+			urlTemplate: "/send?to={to}&text={text}",
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if r.Method != http.MethodGet || r.URL.Query().Get("text") != "Dear \"T.A\",\nyour results are ready" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Api-Key") != "key" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				tt.check(t, r, body)
+			}))
+			defer server.Close()
+
+			gateway := NewGateway(server.URL+tt.urlTemplate, "X-Api-Key", "key", tt.body)
+			err := gateway.SendText("994503981865", "Dear \"T.A\",\nyour results are ready", "")
+			if err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGateway_SendText_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPaymentRequired)
+	}))
+	defer server.Close()
+
+	gateway := NewGateway(server.URL+"/send?to={to}&text={text}", "", "", "")
+	err := gateway.SendText("994503981865", "Hello", "")
+	if err == nil {
+		t.Errorf("expected an error for a rejected message")
+	}
+}
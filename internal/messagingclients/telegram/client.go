@@ -0,0 +1,330 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+const (
+	// Channel is the name of the Telegram channel
+	Channel = "telegram"
+
+	// Endpoint is the endpoint of the Telegram Bot API
+	Endpoint = "https://api.telegram.org/"
+
+	// SecretTokenHeader carries the secret token set with setWebhook
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// Client sends messages through the Telegram Bot API and parses the updates
+// posted to the webhook. The contacts are addressed by their chat ID.
+type Client struct {
+	Token       string
+	SecretToken string
+	endpoint    string
+	client      *http.Client
+}
+
+// NewClient returns a client of the bot with the token. The webhook requests
+// must carry the secretToken given to setWebhook, every update is rejected if it is empty.
+func NewClient(token, secretToken, endpoint string) *Client {
+	return &Client{
+		Token:       token,
+		SecretToken: secretToken,
+		endpoint:    endpoint,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Name() string {
+	return Channel
+}
+
+type SendMessageRequest struct {
+	ChatID           string               `json:"chat_id"`
+	Text             string               `json:"text"`
+	ReplyToMessageID int64                `json:"reply_to_message_id,omitempty"`
+	ReplyMarkup      *ReplyKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// KeyboardButton is a button of a custom keyboard, pressing a
+// RequestContact button shares the phone number of the user
+type KeyboardButton struct {
+	Text           string `json:"text"`
+	RequestContact bool   `json:"request_contact,omitempty"`
+}
+
+type ReplyKeyboardMarkup struct {
+	Keyboard        [][]KeyboardButton `json:"keyboard"`
+	ResizeKeyboard  bool               `json:"resize_keyboard,omitempty"`
+	OneTimeKeyboard bool               `json:"one_time_keyboard,omitempty"`
+}
+
+type SendDocumentRequest struct {
+	ChatID           string `json:"chat_id"`
+	Document         string `json:"document"`
+	Caption          string `json:"caption,omitempty"`
+	ReplyToMessageID int64  `json:"reply_to_message_id,omitempty"`
+}
+
+// Response is the envelope of the Bot API responses
+type Response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// SendText sends a text message, replyTo is the ID of the quoted message
+func (c *Client) SendText(chatID, text, replyTo string) error {
+	return c.call("sendMessage", SendMessageRequest{
+		ChatID:           chatID,
+		Text:             text,
+		ReplyToMessageID: messageID(replyTo),
+	})
+}
+
+// SendPhoneRequest sends the text with a keyboard button sharing the phone number of the user
+func (c *Client) SendPhoneRequest(chatID, text, button, replyTo string) error {
+	return c.call("sendMessage", SendMessageRequest{
+		ChatID:           chatID,
+		Text:             text,
+		ReplyToMessageID: messageID(replyTo),
+		ReplyMarkup: &ReplyKeyboardMarkup{
+			Keyboard:        [][]KeyboardButton{{{Text: button, RequestContact: true}}},
+			ResizeKeyboard:  true,
+			OneTimeKeyboard: true,
+		},
+	})
+}
+
+// SendDocument sends the document at the URL, Telegram downloads it
+func (c *Client) SendDocument(chatID, documentURL, caption, replyTo string) error {
+	return c.call("sendDocument", SendDocumentRequest{
+		ChatID:           chatID,
+		Document:         documentURL,
+		Caption:          caption,
+		ReplyToMessageID: messageID(replyTo),
+	})
+}
+
+// Send translates the message to the Bot API, the buttons are listed under the text
+func (c *Client) Send(message channel.OutboundMessage) error {
+	switch message.Type {
+	case channel.MessageTypeDocument:
+		return c.SendDocument(message.RecipientID, message.DocumentURL, message.Text, message.ReplyTo)
+	case channel.MessageTypeKeyboard:
+		return c.SendText(message.RecipientID, channel.KeyboardText(message.Text, message.Buttons), message.ReplyTo)
+	case channel.MessageTypePhoneRequest:
+		var button string
+		if len(message.Buttons) > 0 {
+			button = message.Buttons[0].Title
+		}
+		return c.SendPhoneRequest(message.RecipientID, message.Text, button, message.ReplyTo)
+	}
+
+	return c.SendText(message.RecipientID, message.Text, message.ReplyTo)
+}
+
+// messageID returns the numeric message ID, Telegram ignores a zero ID
+func messageID(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// call calls the Bot API method with the JSON payload
+func (c *Client) call(method string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%sbot%s/%s", c.endpoint, c.Token, method)
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var response Response
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return fmt.Errorf("telegram %s: %s: %w", method, resp.Status, err)
+	}
+	if !response.OK {
+		return fmt.Errorf("telegram %s: %d: %s", method, response.ErrorCode, response.Description)
+	}
+
+	return nil
+}
+
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+// Contact is a shared contact, the UserID is set when it is a Telegram user
+type Contact struct {
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	UserID      int64  `json:"user_id"`
+}
+
+type File struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+}
+
+type Message struct {
+	MessageID      int64    `json:"message_id"`
+	From           *User    `json:"from"`
+	Chat           Chat     `json:"chat"`
+	Date           int64    `json:"date"`
+	Text           string   `json:"text"`
+	Caption        string   `json:"caption"`
+	Document       *File    `json:"document"`
+	Photo          []File   `json:"photo"`
+	Audio          *File    `json:"audio"`
+	Voice          *File    `json:"voice"`
+	Video          *File    `json:"video"`
+	Sticker        *File    `json:"sticker"`
+	Contact        *Contact `json:"contact"`
+	ReplyToMessage *Message `json:"reply_to_message"`
+}
+
+// CallbackQuery is sent when the user presses an inline keyboard button
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type Update struct {
+	UpdateID      int64          `json:"update_id"`
+	Message       *Message       `json:"message"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+// ParseUpdate verifies the secret token of the webhook request and translates the update.
+// Updates other than messages and button presses are skipped.
+func (c *Client) ParseUpdate(r *http.Request) ([]channel.InboundMessage, error) {
+	if c.SecretToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretTokenHeader)), []byte(c.SecretToken)) != 1 {
+		return nil, channel.ErrUnauthorized
+	}
+
+	var update Update
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		return nil, fmt.Errorf("invalid telegram update: %w", err)
+	}
+
+	switch {
+	case update.Message != nil:
+		return []channel.InboundMessage{inboundMessage(update.Message)}, nil
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		query := update.CallbackQuery
+		m := inboundMessage(query.Message)
+		m.ContactName = name(&query.From)
+		m.Type = "interactive"
+		m.Payload = query.Data
+		m.Media = nil
+		m.Time = time.Now()
+		return []channel.InboundMessage{m}, nil
+	}
+
+	return nil, nil
+}
+
+// inboundMessage translates a message, the media are typed like the
+// WhatsApp messages and carry their caption as the text
+func inboundMessage(message *Message) channel.InboundMessage {
+	m := channel.InboundMessage{
+		Channel:     Channel,
+		SenderID:    strconv.FormatInt(message.Chat.ID, 10),
+		ContactName: name(message.From),
+		MessageID:   strconv.FormatInt(message.MessageID, 10),
+		Type:        "text",
+		Text:        message.Text,
+		Time:        time.Unix(message.Date, 0),
+	}
+
+	var file *File
+	switch {
+	case message.Document != nil:
+		m.Type, file = "document", message.Document
+	case len(message.Photo) > 0:
+		// the last photo is the largest size
+		m.Type, file = "image", &message.Photo[len(message.Photo)-1]
+	case message.Audio != nil:
+		m.Type, file = "audio", message.Audio
+	case message.Voice != nil:
+		m.Type, file = "audio", message.Voice
+	case message.Video != nil:
+		m.Type, file = "video", message.Video
+	case message.Sticker != nil:
+		m.Type, file = "sticker", message.Sticker
+	case message.Contact != nil:
+		m.Type = "contacts"
+		m.Phone = ownPhone(message)
+	case message.Text == "":
+		m.Type = "unsupported"
+	}
+	if m.Type != "text" {
+		m.Text = message.Caption
+	}
+	if file != nil {
+		m.Media = []channel.MediaRef{{ID: file.FileID, MimeType: file.MimeType, Caption: message.Caption}}
+	}
+
+	if message.ReplyToMessage != nil {
+		m.ReplyTo = strconv.FormatInt(message.ReplyToMessage.MessageID, 10)
+	}
+
+	return m
+}
+
+// ownPhone returns the phone number of the shared contact if it is the
+// sender's own, i.e. shared with the request contact button. Any other
+// contact card may carry the number of someone else.
+func ownPhone(message *Message) string {
+	if message.From == nil || message.Contact.UserID != message.From.ID {
+		return ""
+	}
+
+	return channel.NormalizePhone(message.Contact.PhoneNumber)
+}
+
+func name(user *User) string {
+	if user == nil {
+		return ""
+	}
+	if user.LastName == "" {
+		return user.FirstName
+	}
+
+	return user.FirstName + " " + user.LastName
+}
@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+func TestSendText_Success(t *testing.T) {
+	var payload SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot123:abc/sendMessage" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"ok":false,"error_code":404,"description":"Not Found"}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":43}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("123:abc", "", server.URL+"/")
+	err := client.SendText("987654321", "Your results are ready", "42")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if payload.ChatID != "987654321" || payload.Text != "Your results are ready" || payload.ReplyToMessageID != 42 {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestSend_PhoneRequest(t *testing.T) {
+	var payload SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":43}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("123:abc", "", server.URL+"/")
+	err := client.Send(channel.OutboundMessage{
+		RecipientID: "987654321",
+		Type:        channel.MessageTypePhoneRequest,
+		Text:        "Please share your phone number",
+		Buttons:     []channel.Button{{ID: "share_phone", Title: "Share phone number"}},
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if payload.ReplyMarkup == nil || len(payload.ReplyMarkup.Keyboard) != 1 {
+		t.Fatalf("expected a keyboard, got %+v", payload)
+	}
+	button := payload.ReplyMarkup.Keyboard[0][0]
+	if button.Text != "Share phone number" || !button.RequestContact {
+		t.Errorf("expected a request contact button, got %+v", button)
+	}
+}
+
+func TestSendDocument_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("123:abc", "", server.URL+"/")
+	err := client.SendDocument("987654321", "https://example.com/api/v1/987654321/document", "Results", "")
+	if err == nil || !strings.Contains(err.Error(), "bot was blocked") {
+		t.Errorf("expected the description in the error, got %v", err)
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	client := NewClient("123:abc", "secret", Endpoint)
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		update channel.InboundMessage
+		err    error
+	}{
+		{
+			name:   "text",
+			secret: "secret",
+			body:   `{"update_id":1,"message":{"message_id":42,"from":{"id":987654321,"first_name":"Tabriz","last_name":"A"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"text":"result","reply_to_message":{"message_id":40,"chat":{"id":987654321},"date":1681899800}}}`,
+			update: channel.InboundMessage{Channel: Channel, SenderID: "987654321", ContactName: "Tabriz A", MessageID: "42", Type: "text", Text: "result", ReplyTo: "40"},
+		},
+		{
+			name:   "photo",
+			secret: "secret",
+			body:   `{"update_id":2,"message":{"message_id":43,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"photo":[{"file_id":"f1"}],"caption":"my result"}}`,
+			update: channel.InboundMessage{Channel: Channel, SenderID: "987654321", ContactName: "Tabriz", MessageID: "43", Type: "image", Text: "my result", Media: []channel.MediaRef{{ID: "f1", Caption: "my result"}}},
+		},
+		{
+			name:   "own contact",
+			secret: "secret",
+			body:   `{"update_id":3,"message":{"message_id":44,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"contact":{"phone_number":"+994503981865","first_name":"Tabriz","user_id":987654321}}}`,
+			update: channel.InboundMessage{Channel: Channel, SenderID: "987654321", ContactName: "Tabriz", MessageID: "44", Type: "contacts", Phone: "994503981865"},
+		},
+		{
+			name:   "contact of someone else",
+			secret: "secret",
+			body:   `{"update_id":4,"message":{"message_id":45,"from":{"id":987654321,"first_name":"Tabriz"},"chat":{"id":987654321,"type":"private"},"date":1681899808,"contact":{"phone_number":"+4917635163191","first_name":"Someone","user_id":123456789}}}`,
+			update: channel.InboundMessage{Channel: Channel, SenderID: "987654321", ContactName: "Tabriz", MessageID: "45", Type: "contacts"},
+		},
+		{
+			name:   "wrong secret",
+			secret: "guess",
+			body:   `{"update_id":3}`,
+			err:    channel.ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(tt.body))
+			r.Header.Set(SecretTokenHeader, tt.secret)
+
+			updates, err := client.ParseUpdate(r)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error parsing update: %v", err)
+			}
+			if len(updates) != 1 {
+				t.Fatalf("expected one update, got %+v", updates)
+			}
+
+			update := updates[0]
+			if update.Time.Unix() != 1681899808 {
+				t.Errorf("unexpected time %v", update.Time)
+			}
+			update.Time = tt.update.Time
+			if !reflect.DeepEqual(update, tt.update) {
+				t.Errorf("expected %+v, got %+v", tt.update, update)
+			}
+		})
+	}
+}
+
+func TestParseUpdate_NoSecretToken(t *testing.T) {
+	client := NewClient("123:abc", "", Endpoint)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/telegram/hook", strings.NewReader(`{"update_id":1,"message":{"message_id":42,"chat":{"id":987654321},"date":1681899808,"text":"result"}}`))
+	_, err := client.ParseUpdate(r)
+	if !errors.Is(err, channel.ErrUnauthorized) {
+		t.Errorf("expected %v without a secret token, got %v", channel.ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,317 @@
+package viber
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+const (
+	// Channel is the name of the Viber channel
+	Channel = "viber"
+
+	// Endpoint is the endpoint of the Viber REST bot API
+	Endpoint = "https://chatapi.viber.com/pa/"
+
+	// SignatureHeader carries the HMAC-SHA256 of the webhook body keyed by the token
+	SignatureHeader = "X-Viber-Content-Signature"
+
+	// keyboardTrackingData marks the messages sent while a keyboard is shown,
+	// Viber echoes it back so the text of the answer is the pressed button
+	keyboardTrackingData = "keyboard"
+)
+
+// Client sends messages through the Viber bot API and parses the callbacks
+// posted to the webhook. The contacts are addressed by their Viber user ID.
+type Client struct {
+	Token      string
+	SenderName string
+	endpoint   string
+	client     *http.Client
+}
+
+// NewClient returns a client of the bot with the token, the messages are
+// sent under the senderName
+func NewClient(token, senderName, endpoint string) *Client {
+	return &Client{
+		Token:      token,
+		SenderName: senderName,
+		endpoint:   endpoint,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Name() string {
+	return Channel
+}
+
+type Sender struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type KeyboardButton struct {
+	ActionType string `json:"ActionType"`
+	ActionBody string `json:"ActionBody"`
+	Text       string `json:"Text"`
+	Columns    int    `json:"Columns,omitempty"`
+	Rows       int    `json:"Rows,omitempty"`
+}
+
+type Keyboard struct {
+	Type          string           `json:"Type"`
+	DefaultHeight bool             `json:"DefaultHeight"`
+	Buttons       []KeyboardButton `json:"Buttons"`
+}
+
+type SendMessageRequest struct {
+	Receiver     string    `json:"receiver"`
+	Type         string    `json:"type"`
+	Sender       Sender    `json:"sender"`
+	Text         string    `json:"text,omitempty"`
+	Media        string    `json:"media,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	FileName     string    `json:"file_name,omitempty"`
+	TrackingData string    `json:"tracking_data,omitempty"`
+	Keyboard     *Keyboard `json:"keyboard,omitempty"`
+}
+
+type SendMessageResponse struct {
+	Status        int    `json:"status"`
+	StatusMessage string `json:"status_message"`
+	MessageToken  int64  `json:"message_token"`
+}
+
+// SendText sends a text message, Viber can't quote a message
+func (c *Client) SendText(receiver, text, replyTo string) error {
+	return c.send(SendMessageRequest{
+		Receiver: receiver,
+		Type:     "text",
+		Sender:   Sender{Name: c.SenderName},
+		Text:     text,
+	})
+}
+
+// SendDocument sends the document at the URL as a file followed by the caption.
+// Viber needs the size of the file, it is taken from a HEAD request.
+func (c *Client) SendDocument(receiver, documentURL, caption, replyTo string) error {
+	size, err := c.size(documentURL)
+	if err != nil {
+		return err
+	}
+
+	err = c.send(SendMessageRequest{
+		Receiver: receiver,
+		Type:     "file",
+		Sender:   Sender{Name: c.SenderName},
+		Media:    documentURL,
+		Size:     size,
+		FileName: fileName(documentURL),
+	})
+	if err != nil {
+		return err
+	}
+
+	if caption == "" {
+		return nil
+	}
+
+	return c.SendText(receiver, caption, replyTo)
+}
+
+// Send translates the message to the Viber messages. The phone number can't
+// be requested: the contact shared by the share phone button looks like any
+// contact card sent while the request is shown, so whose number it is is unknown.
+func (c *Client) Send(message channel.OutboundMessage) error {
+	switch message.Type {
+	case channel.MessageTypeDocument:
+		return c.SendDocument(message.RecipientID, message.DocumentURL, message.Text, message.ReplyTo)
+	case channel.MessageTypeKeyboard:
+		return c.SendKeyboard(message.RecipientID, message.Text, message.Buttons, message.ReplyTo)
+	case channel.MessageTypePhoneRequest:
+		return fmt.Errorf("%s: %w", Channel, channel.ErrPhoneRequestUnsupported)
+	}
+
+	return c.SendText(message.RecipientID, message.Text, message.ReplyTo)
+}
+
+// SendKeyboard sends the text with a reply button for each of the buttons
+func (c *Client) SendKeyboard(receiver, text string, buttons []channel.Button, replyTo string) error {
+	keyboard := &Keyboard{Type: "keyboard", DefaultHeight: true}
+	for _, button := range buttons {
+		keyboard.Buttons = append(keyboard.Buttons, KeyboardButton{
+			ActionType: "reply",
+			ActionBody: button.ID,
+			Text:       button.Title,
+		})
+	}
+
+	return c.send(SendMessageRequest{
+		Receiver:     receiver,
+		Type:         "text",
+		Sender:       Sender{Name: c.SenderName},
+		Text:         text,
+		TrackingData: keyboardTrackingData,
+		Keyboard:     keyboard,
+	})
+}
+
+func (c *Client) send(data SendMessageRequest) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+"send_message", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Viber-Auth-Token", c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response SendMessageResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("viber send_message: %s: %w", resp.Status, err)
+	}
+	if response.Status != 0 {
+		return fmt.Errorf("viber send_message: %d: %s", response.Status, response.StatusMessage)
+	}
+
+	return nil
+}
+
+// size returns the content length of the document
+func (c *Client) size(documentURL string) (int64, error) {
+	resp, err := c.client.Head(documentURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		return 0, fmt.Errorf("size of %s unknown: %s", documentURL, resp.Status)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// fileName returns the name of the file the URL points to, with the .pdf
+// extension Viber needs to show the documents served without one
+func fileName(documentURL string) string {
+	name := path.Base(documentURL)
+	if path.Ext(name) == "" {
+		name += ".pdf"
+	}
+
+	return name
+}
+
+type User struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Language string `json:"language"`
+}
+
+type Message struct {
+	Type         string `json:"type"`
+	Text         string `json:"text"`
+	Media        string `json:"media"`
+	FileName     string `json:"file_name"`
+	TrackingData string `json:"tracking_data"`
+}
+
+// Callback is a webhook request of Viber
+type Callback struct {
+	Event        string  `json:"event"`
+	Timestamp    int64   `json:"timestamp"`
+	MessageToken int64   `json:"message_token"`
+	Sender       User    `json:"sender"`
+	Message      Message `json:"message"`
+}
+
+// messageTypes maps the Viber message types to the WhatsApp message types
+var messageTypes = map[string]string{
+	"text":     "text",
+	"url":      "text",
+	"picture":  "image",
+	"video":    "video",
+	"file":     "document",
+	"sticker":  "sticker",
+	"contact":  "contacts",
+	"location": "location",
+}
+
+// ParseUpdate verifies the signature of the callback and translates the messages.
+// The other events, e.g. delivered or subscribed, are skipped.
+func (c *Client) ParseUpdate(r *http.Request) ([]channel.InboundMessage, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.validSignature(body, r.Header.Get(SignatureHeader)) {
+		return nil, channel.ErrUnauthorized
+	}
+
+	var callback Callback
+	err = json.Unmarshal(body, &callback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid viber callback: %w", err)
+	}
+
+	if callback.Event != "message" {
+		return nil, nil
+	}
+
+	messageType, ok := messageTypes[callback.Message.Type]
+	if !ok {
+		messageType = "unsupported"
+	}
+
+	message := channel.InboundMessage{
+		Channel:     Channel,
+		SenderID:    callback.Sender.ID,
+		ContactName: callback.Sender.Name,
+		MessageID:   fmt.Sprintf("%d", callback.MessageToken),
+		Type:        messageType,
+		Text:        callback.Message.Text,
+		Time:        time.UnixMilli(callback.Timestamp),
+	}
+	if callback.Message.TrackingData == keyboardTrackingData {
+		message.Payload = callback.Message.Text
+	}
+	if callback.Message.Media != "" {
+		message.Media = []channel.MediaRef{{URL: callback.Message.Media, Caption: callback.Message.Text}}
+	}
+
+	return []channel.InboundMessage{message}, nil
+}
+
+// validSignature tells whether the signature is the HMAC-SHA256 of the body keyed by the token
+func (c *Client) validSignature(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.Token))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
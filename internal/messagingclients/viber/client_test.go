@@ -0,0 +1,162 @@
+package viber
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/channel"
+)
+
+func sign(token, body string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSendDocument_Success(t *testing.T) {
+	var payloads []SendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/api/v1/994503981865/document":
+			w.Header().Set("Content-Length", "2048")
+		case r.URL.Path == "/send_message" && r.Header.Get("X-Viber-Auth-Token") == "token":
+			var payload SendMessageRequest
+			json.NewDecoder(r.Body).Decode(&payload)
+			payloads = append(payloads, payload)
+			fmt.Fprint(w, `{"status":0,"status_message":"ok","message_token":5741311803571721087}`)
+		default:
+			fmt.Fprint(w, `{"status":2,"status_message":"invalidAuthToken"}`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("token", "Lab", server.URL+"/")
+	err := client.SendDocument("01234567890A=", server.URL+"/api/v1/994503981865/document", "Your results", "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("expected the file and the caption, got %+v", payloads)
+	}
+	file := payloads[0]
+	if file.Type != "file" || file.Size != 2048 || file.FileName != "document.pdf" || file.Sender.Name != "Lab" {
+		t.Errorf("unexpected file message %+v", file)
+	}
+	if payloads[1].Type != "text" || payloads[1].Text != "Your results" {
+		t.Errorf("unexpected caption message %+v", payloads[1])
+	}
+}
+
+func TestSendKeyboard_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":6,"status_message":"receiverNotSubscribed"}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("token", "Lab", server.URL+"/")
+	err := client.SendKeyboard("01234567890A=", "Choose", []channel.Button{{ID: "language:en", Title: "English"}}, "")
+	if err == nil || !strings.Contains(err.Error(), "receiverNotSubscribed") {
+		t.Errorf("expected the status message in the error, got %v", err)
+	}
+}
+
+func TestSend_PhoneRequest(t *testing.T) {
+	client := NewClient("token", "Lab", Endpoint)
+	err := client.Send(channel.OutboundMessage{
+		RecipientID: "01234567890A=",
+		Type:        channel.MessageTypePhoneRequest,
+		Text:        "Please share your phone number",
+		Buttons:     []channel.Button{{ID: "share_phone", Title: "Share phone number"}},
+	})
+	if !errors.Is(err, channel.ErrPhoneRequestUnsupported) {
+		t.Errorf("expected %v, got %v", channel.ErrPhoneRequestUnsupported, err)
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	client := NewClient("token", "Lab", Endpoint)
+
+	tests := []struct {
+		name    string
+		body    string
+		sign    string
+		updates []channel.InboundMessage
+		err     error
+	}{
+		{
+			name:    "text",
+			body:    `{"event":"message","timestamp":1681899808000,"message_token":4912661846655238145,"sender":{"id":"01234567890A=","name":"T.A","language":"az"},"message":{"type":"text","text":"netice"}}`,
+			sign:    "token",
+			updates: []channel.InboundMessage{{Channel: Channel, SenderID: "01234567890A=", ContactName: "T.A", MessageID: "4912661846655238145", Type: "text", Text: "netice"}},
+		},
+		{
+			name:    "keyboard reply",
+			body:    `{"event":"message","timestamp":1681899808000,"message_token":4912661846655238146,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"text","text":"language:en","tracking_data":"keyboard"}}`,
+			sign:    "token",
+			updates: []channel.InboundMessage{{Channel: Channel, SenderID: "01234567890A=", ContactName: "T.A", MessageID: "4912661846655238146", Type: "text", Text: "language:en", Payload: "language:en"}},
+		},
+		{
+			name:    "picture",
+			body:    `{"event":"message","timestamp":1681899808000,"message_token":4912661846655238147,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"picture","media":"https://example.com/p.jpg"}}`,
+			sign:    "token",
+			updates: []channel.InboundMessage{{Channel: Channel, SenderID: "01234567890A=", ContactName: "T.A", MessageID: "4912661846655238147", Type: "image", Media: []channel.MediaRef{{URL: "https://example.com/p.jpg"}}}},
+		},
+		{
+			name:    "contact card",
+			body:    `{"event":"message","timestamp":1681899808000,"message_token":4912661846655238149,"sender":{"id":"01234567890A=","name":"T.A"},"message":{"type":"contact","contact":{"name":"Someone","phone_number":"+4917635163191"}}}`,
+			sign:    "token",
+			updates: []channel.InboundMessage{{Channel: Channel, SenderID: "01234567890A=", ContactName: "T.A", MessageID: "4912661846655238149", Type: "contacts"}},
+		},
+		{
+			name: "delivered",
+			body: `{"event":"delivered","timestamp":1681899808000,"message_token":4912661846655238145,"user_id":"01234567890A="}`,
+			sign: "token",
+		},
+		{
+			name: "forged",
+			body: `{"event":"message","timestamp":1681899808000,"sender":{"id":"01234567890A="},"message":{"type":"text","text":"netice"}}`,
+			sign: "guess",
+			err:  channel.ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/channels/viber/hook", strings.NewReader(tt.body))
+			r.Header.Set(SignatureHeader, sign(tt.sign, tt.body))
+
+			updates, err := client.ParseUpdate(r)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error parsing callback: %v", err)
+			}
+			if len(updates) != len(tt.updates) {
+				t.Fatalf("expected %+v, got %+v", tt.updates, updates)
+			}
+			for i := range updates {
+				if updates[i].Time.Unix() != 1681899808 {
+					t.Errorf("unexpected time %v", updates[i].Time)
+				}
+				updates[i].Time = tt.updates[i].Time
+				if !reflect.DeepEqual(updates[i], tt.updates[i]) {
+					t.Errorf("expected %+v, got %+v", tt.updates[i], updates[i])
+				}
+			}
+		})
+	}
+}
@@ -5,15 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"strings"
 )
 
 const (
-	MessagingProduct    = "whatsapp"
-	MessageTypeTemplate = "template"
-	MessageTypeText     = "text"
-	MessageTypeDocument = "document"
+	MessagingProduct       = "whatsapp"
+	MessageTypeTemplate    = "template"
+	MessageTypeText        = "text"
+	MessageTypeDocument    = "document"
+	MessageTypeInteractive = "interactive"
+	MessageTypeLocation    = "location"
+	MessageTypeContacts    = "contacts"
+	MessageTypeReaction    = "reaction"
+
+	InteractiveTypeList = "list"
+
+	StatusRead          = "read"
+	TypingIndicatorText = "text"
 
 	RequestTypeIndividual = "individual"
 )
@@ -22,11 +32,29 @@ var (
 	phoneBusinessMap = map[string]string{"15550909792": "106189092448679"}
 )
 
+// SentMessage is a message sent by the client. The MessageID is empty and
+// the Error is set when the message was rejected.
+type SentMessage struct {
+	MessageID string
+	From      string
+	To        string
+	Type      string
+	// Body is the text, the document or the name of the template
+	Body  string
+	Error string
+}
+
+// Recorder stores the messages sent by the client
+type Recorder interface {
+	RecordSent(message SentMessage) error
+}
+
 type Client struct {
 	ClientID               string
 	AccessToken            string
 	BearerToken            string
 	sendingMessageEndpoint string
+	recorder               Recorder
 }
 
 func NewClient(clientID, accessToken, sendingMessageEndpoint, bearerToken string) Client {
@@ -38,20 +66,78 @@ func NewClient(clientID, accessToken, sendingMessageEndpoint, bearerToken string
 	}
 }
 
+// SetRecorder sets the recorder of the sent messages, nothing is recorded by default
+func (c *Client) SetRecorder(recorder Recorder) {
+	c.recorder = recorder
+}
+
+// MessageContext links a message to an earlier message of the conversation
+type MessageContext struct {
+	MessageID string `json:"message_id"`
+}
+
+// SendOptions are the optional fields of a sent message
+type SendOptions struct {
+	Context *MessageContext
+	// Parameters fill the placeholders of the body of a template
+	Parameters []string
+}
+
+// SendOption sets an optional field of a sent message
+type SendOption func(*SendOptions)
+
+// ReplyTo sends the message as a reply quoting the message with the ID
+func ReplyTo(messageID string) SendOption {
+	return func(o *SendOptions) {
+		if messageID != "" {
+			o.Context = &MessageContext{MessageID: messageID}
+		}
+	}
+}
+
+// TemplateParameters fills the {{1}}, {{2}}, ... placeholders of the body of the template
+func TemplateParameters(parameters ...string) SendOption {
+	return func(o *SendOptions) {
+		o.Parameters = parameters
+	}
+}
+
+// NewSendOptions returns the send options with the options applied
+func NewSendOptions(opts ...SendOption) SendOptions {
+	var options SendOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
 type TemplateLanguage struct {
 	Code string `json:"code"`
 }
 
+type TemplateParameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type TemplateComponent struct {
+	Type       string              `json:"type"`
+	Parameters []TemplateParameter `json:"parameters"`
+}
+
 type Template struct {
-	Name     string           `json:"name"`
-	Language TemplateLanguage `json:"language"`
+	Name       string              `json:"name"`
+	Language   TemplateLanguage    `json:"language"`
+	Components []TemplateComponent `json:"components,omitempty"`
 }
 
 type SendMessagePayload struct {
-	MessagingProduct string   `json:"messaging_product"`
-	To               string   `json:"to"`
-	Type             string   `json:"type"`
-	Template         Template `json:"template"`
+	MessagingProduct string          `json:"messaging_product"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Template         Template        `json:"template"`
+	Context          *MessageContext `json:"context,omitempty"`
 }
 
 type SendMessageResponse struct {
@@ -65,12 +151,27 @@ type SendMessageResponse struct {
 	} `json:"messages"`
 }
 
+// ResponseError is the error returned by the Graph API when a message is rejected,
+// the client returns it as the error of the send
+type ResponseError struct {
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	Code      int    `json:"code"`
+	ErrorData struct {
+		Details string `json:"details"`
+	} `json:"error_data"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("whatsapp: %d: %s", e.Code, e.Message)
+}
+
 func (c *Client) GetUrl(from string) string {
 	return fmt.Sprintf("%s%s/messages", c.sendingMessageEndpoint, phoneBusinessMap[from])
 }
 
-func (c *Client) SendMessage(from, to, templateName, languageCode string) (SendMessageResponse, error) {
-	url := c.GetUrl(from)
+func (c *Client) SendMessage(from, to, templateName, languageCode string, opts ...SendOption) (SendMessageResponse, error) {
+	options := NewSendOptions(opts...)
 	payload := SendMessagePayload{
 		MessagingProduct: MessagingProduct,
 		To:               to,
@@ -79,35 +180,18 @@ func (c *Client) SendMessage(from, to, templateName, languageCode string) (SendM
 			Name:     templateName,
 			Language: TemplateLanguage{Code: languageCode},
 		},
+		Context: options.Context,
 	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return SendMessageResponse{}, err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return SendMessageResponse{}, err
-	}
-
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.BearerToken))
-	req.Header.Add("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return SendMessageResponse{}, err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return SendMessageResponse{}, err
+	if len(options.Parameters) > 0 {
+		body := TemplateComponent{Type: "body"}
+		for _, parameter := range options.Parameters {
+			body.Parameters = append(body.Parameters, TemplateParameter{Type: "text", Text: parameter})
+		}
+		payload.Template.Components = []TemplateComponent{body}
 	}
 
 	var sendMessageResponse SendMessageResponse
-	err = json.Unmarshal(data, &sendMessageResponse)
+	err := c.send(from, to, MessageTypeTemplate, templateName, payload, &sendMessageResponse)
 	if err != nil {
 		return sendMessageResponse, err
 	}
@@ -121,90 +205,339 @@ type Text struct {
 }
 
 type SendMessageText struct {
-	MessagingProduct string `json:"messaging_product"`
-	RecipientType    string `json:"recipient_type"`
-	To               string `json:"to"`
-	Type             string `json:"type"`
-	Text             Text   `json:"text"`
+	MessagingProduct string          `json:"messaging_product"`
+	RecipientType    string          `json:"recipient_type"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Text             Text            `json:"text"`
+	Context          *MessageContext `json:"context,omitempty"`
 }
 
-func (c *Client) SendMessageText(from, message, recipientID string) (map[string]interface{}, error) {
-	url := c.GetUrl(from)
-
+func (c *Client) SendMessageText(from, message, recipientID string, opts ...SendOption) (map[string]interface{}, error) {
 	data := SendMessageText{
 		MessagingProduct: MessagingProduct,
 		RecipientType:    RequestTypeIndividual,
 		To:               recipientID,
 		Type:             MessageTypeText,
 		Text:             Text{PreviewURL: false, Body: message},
+		Context:          NewSendOptions(opts...).Context,
 	}
 
-	payload, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
+	return c.post(from, recipientID, MessageTypeText, message, data)
+}
+
+type Document struct {
+	Link    string `json:"link,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+type SendDocumentRequest struct {
+	MessagingProduct string          `json:"messaging_product"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Document         Document        `json:"document"`
+	Context          *MessageContext `json:"context,omitempty"`
+}
+
+func (c *Client) SendDocument(from, document, recipientID, caption string, link bool, opts ...SendOption) (map[string]interface{}, error) {
+	data := SendDocumentRequest{
+		MessagingProduct: MessagingProduct,
+		To:               recipientID,
+		Type:             MessageTypeDocument,
+		Context:          NewSendOptions(opts...).Context,
 	}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(payload)))
-	if err != nil {
-		return nil, err
+	if link {
+		data.Document = Document{Link: document, Caption: caption}
+	} else {
+		data.Document = Document{ID: document, Caption: caption}
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.BearerToken))
-	req.Header.Add("Content-Type", "application/json")
+	return c.post(from, recipientID, MessageTypeDocument, document, data)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+type ListSection struct {
+	Title string    `json:"title,omitempty"`
+	Rows  []ListRow `json:"rows"`
+}
+
+type InteractiveBody struct {
+	Text string `json:"text"`
+}
+
+type InteractiveAction struct {
+	Button   string        `json:"button,omitempty"`
+	Sections []ListSection `json:"sections,omitempty"`
+}
+
+type Interactive struct {
+	Type   string            `json:"type"`
+	Body   InteractiveBody   `json:"body"`
+	Action InteractiveAction `json:"action"`
+}
+
+type SendInteractiveRequest struct {
+	MessagingProduct string          `json:"messaging_product"`
+	RecipientType    string          `json:"recipient_type"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Interactive      Interactive     `json:"interactive"`
+	Context          *MessageContext `json:"context,omitempty"`
+}
+
+// SendInteractiveList sends a list message, the recipient picks one of the rows
+// after tapping the button and the ID of the row comes back in a list_reply.
+func (c *Client) SendInteractiveList(from, recipientID, body, button string, sections []ListSection, opts ...SendOption) (map[string]interface{}, error) {
+	data := SendInteractiveRequest{
+		MessagingProduct: MessagingProduct,
+		RecipientType:    RequestTypeIndividual,
+		To:               recipientID,
+		Type:             MessageTypeInteractive,
+		Interactive: Interactive{
+			Type:   InteractiveTypeList,
+			Body:   InteractiveBody{Text: body},
+			Action: InteractiveAction{Button: button, Sections: sections},
+		},
+		Context: NewSendOptions(opts...).Context,
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	return c.post(from, recipientID, MessageTypeInteractive, body, data)
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+type SendLocationRequest struct {
+	MessagingProduct string          `json:"messaging_product"`
+	RecipientType    string          `json:"recipient_type"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Location         Location        `json:"location"`
+	Context          *MessageContext `json:"context,omitempty"`
+}
+
+// SendLocation sends a pin on the map, e.g. the address of a branch
+func (c *Client) SendLocation(from, recipientID string, location Location, opts ...SendOption) (map[string]interface{}, error) {
+	data := SendLocationRequest{
+		MessagingProduct: MessagingProduct,
+		RecipientType:    RequestTypeIndividual,
+		To:               recipientID,
+		Type:             MessageTypeLocation,
+		Location:         location,
+		Context:          NewSendOptions(opts...).Context,
 	}
 
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return nil, err
+	summary := location.Name
+	if summary == "" {
+		summary = fmt.Sprintf("%f,%f", location.Latitude, location.Longitude)
 	}
 
-	return result, nil
+	return c.post(from, recipientID, MessageTypeLocation, summary, data)
 }
 
-type Document struct {
-	Link    string `json:"link,omitempty"`
-	ID      string `json:"id,omitempty"`
-	Caption string `json:"caption,omitempty"`
+type ContactName struct {
+	FormattedName string `json:"formatted_name"`
+	FirstName     string `json:"first_name,omitempty"`
+	LastName      string `json:"last_name,omitempty"`
 }
 
-type SendDocumentRequest struct {
+type ContactPhone struct {
+	Phone string `json:"phone"`
+	Type  string `json:"type,omitempty"`
+	WaID  string `json:"wa_id,omitempty"`
+}
+
+type ContactEmail struct {
+	Email string `json:"email"`
+	Type  string `json:"type,omitempty"`
+}
+
+type ContactAddress struct {
+	Street      string `json:"street,omitempty"`
+	City        string `json:"city,omitempty"`
+	Zip         string `json:"zip,omitempty"`
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+type ContactOrg struct {
+	Company    string `json:"company,omitempty"`
+	Department string `json:"department,omitempty"`
+	Title      string `json:"title,omitempty"`
+}
+
+type ContactURL struct {
+	URL  string `json:"url"`
+	Type string `json:"type,omitempty"`
+}
+
+// Contact is a contact card, the formatted name is required
+type Contact struct {
+	Name      ContactName      `json:"name"`
+	Phones    []ContactPhone   `json:"phones,omitempty"`
+	Emails    []ContactEmail   `json:"emails,omitempty"`
+	Addresses []ContactAddress `json:"addresses,omitempty"`
+	Org       *ContactOrg      `json:"org,omitempty"`
+	URLs      []ContactURL     `json:"urls,omitempty"`
+}
+
+type SendContactsRequest struct {
+	MessagingProduct string          `json:"messaging_product"`
+	RecipientType    string          `json:"recipient_type"`
+	To               string          `json:"to"`
+	Type             string          `json:"type"`
+	Contacts         []Contact       `json:"contacts"`
+	Context          *MessageContext `json:"context,omitempty"`
+}
+
+// SendContacts sends contact cards, e.g. the phone numbers of the staff
+func (c *Client) SendContacts(from, recipientID string, contacts []Contact, opts ...SendOption) (map[string]interface{}, error) {
+	data := SendContactsRequest{
+		MessagingProduct: MessagingProduct,
+		RecipientType:    RequestTypeIndividual,
+		To:               recipientID,
+		Type:             MessageTypeContacts,
+		Contacts:         contacts,
+		Context:          NewSendOptions(opts...).Context,
+	}
+
+	var names []string
+	for _, contact := range contacts {
+		names = append(names, contact.Name.FormattedName)
+	}
+
+	return c.post(from, recipientID, MessageTypeContacts, strings.Join(names, ", "), data)
+}
+
+type Reaction struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+type SendReactionRequest struct {
 	MessagingProduct string   `json:"messaging_product"`
+	RecipientType    string   `json:"recipient_type"`
 	To               string   `json:"to"`
 	Type             string   `json:"type"`
-	Document         Document `json:"document"`
+	Reaction         Reaction `json:"reaction"`
 }
 
-func (c *Client) SendDocument(from, document, recipientID, caption string, link bool) (map[string]interface{}, error) {
-	url := c.GetUrl(from)
-	data := SendDocumentRequest{
+// SendReaction reacts with the emoji to a message of the recipient,
+// an empty emoji removes the reaction. A reaction can't quote another message.
+func (c *Client) SendReaction(from, recipientID, messageID, emoji string) (map[string]interface{}, error) {
+	data := SendReactionRequest{
 		MessagingProduct: MessagingProduct,
+		RecipientType:    RequestTypeIndividual,
 		To:               recipientID,
-		Type:             MessageTypeDocument,
+		Type:             MessageTypeReaction,
+		Reaction:         Reaction{MessageID: messageID, Emoji: emoji},
 	}
 
-	if link {
-		data.Document = Document{Link: document, Caption: caption}
-	} else {
-		data.Document = Document{ID: document, Caption: caption}
+	return c.post(from, recipientID, MessageTypeReaction, emoji, data)
+}
+
+type TypingIndicator struct {
+	Type string `json:"type"`
+}
+
+type MarkAsReadRequest struct {
+	MessagingProduct string           `json:"messaging_product"`
+	Status           string           `json:"status"`
+	MessageID        string           `json:"message_id"`
+	TypingIndicator  *TypingIndicator `json:"typing_indicator,omitempty"`
+}
+
+type MarkAsReadResponse struct {
+	Success bool `json:"success"`
+}
+
+// MarkAsRead marks the message received by the business number as read,
+// the sender sees the blue ticks
+func (c *Client) MarkAsRead(from, messageID string) error {
+	return c.markAsRead(from, MarkAsReadRequest{
+		MessagingProduct: MessagingProduct,
+		Status:           StatusRead,
+		MessageID:        messageID,
+	})
+}
+
+// SendTypingIndicator marks the message as read and shows the sender that
+// an answer is being typed, until the answer is sent or for 25 seconds
+func (c *Client) SendTypingIndicator(from, messageID string) error {
+	return c.markAsRead(from, MarkAsReadRequest{
+		MessagingProduct: MessagingProduct,
+		Status:           StatusRead,
+		MessageID:        messageID,
+		TypingIndicator:  &TypingIndicator{Type: TypingIndicatorText},
+	})
+}
+
+func (c *Client) markAsRead(from string, data MarkAsReadRequest) error {
+	body, err := c.do(from, data)
+	if err != nil {
+		return fmt.Errorf("marking message %s as read: %w", data.MessageID, err)
+	}
+
+	var response MarkAsReadResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return err
+	}
+
+	if !response.Success {
+		return fmt.Errorf("marking message %s as read: %s", data.MessageID, body)
+	}
+
+	return nil
+}
+
+// post sends the payload to the messages endpoint of the business number
+// and returns the decoded response
+func (c *Client) post(from, to, messageType, text string, data interface{}) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := c.send(from, to, messageType, text, data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// send sends the payload to the messages endpoint of the business number,
+// records the outbound message and decodes the response into result
+func (c *Client) send(from, to, messageType, text string, data, result interface{}) error {
+	body, err := c.do(from, data)
+	if err != nil {
+		c.record(from, to, messageType, text, nil, err)
+		return err
 	}
 
+	c.record(from, to, messageType, text, body, nil)
+
+	return json.Unmarshal(body, result)
+}
+
+// do posts the payload to the messages endpoint of the business number and returns
+// the response body. A rejected request fails with the *ResponseError of the Graph API.
+func (c *Client) do(from string, data interface{}) ([]byte, error) {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(payload)))
+
+	req, err := http.NewRequest(http.MethodPost, c.GetUrl(from), bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
@@ -224,11 +557,47 @@ func (c *Client) SendDocument(from, document, recipientID, caption string, link
 		return nil, err
 	}
 
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return nil, err
+	var rejected struct {
+		Error *ResponseError `json:"error"`
+	}
+	json.Unmarshal(body, &rejected)
+	if rejected.Error != nil {
+		return nil, rejected.Error
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("whatsapp: %s: %s", resp.Status, body)
 	}
 
-	return result, nil
+	return body, nil
+}
+
+// record stores the outbound message with the ID the provider assigned to it
+func (c *Client) record(from, to, messageType, text string, body []byte, sendErr error) {
+	if c.recorder == nil {
+		return
+	}
+
+	message := SentMessage{
+		From: from,
+		To:   to,
+		Type: messageType,
+		Body: text,
+	}
+
+	var response SendMessageResponse
+	json.Unmarshal(body, &response)
+
+	switch {
+	case sendErr != nil:
+		message.Error = sendErr.Error()
+	case len(response.Messages) > 0:
+		message.MessageID = response.Messages[0].ID
+	default:
+		message.Error = string(body)
+	}
+
+	err := c.recorder.RecordSent(message)
+	if err != nil {
+		log.Printf("Error recording outbound message to %s: %v", to, err)
+	}
 }
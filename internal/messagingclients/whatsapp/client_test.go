@@ -1,11 +1,26 @@
 package whatsapp
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
+type fakeRecorder struct {
+	records []SentMessage
+}
+
+func (f *fakeRecorder) RecordSent(message SentMessage) error {
+	f.records = append(f.records, message)
+	return nil
+}
+
 func TestHelloMessage_Template_Success(t *testing.T) {
 	// Arrange
 	client := NewClient("552041023667800", "e6de5aff86bed1577c681e73edf30f7e", "https://graph.facebook.com/v16.0/", "")
@@ -52,3 +67,270 @@ func TestSendMessageDocument_Success(t *testing.T) {
 	}
 
 }
+
+func TestSendInteractiveList_Success(t *testing.T) {
+	var payload SendInteractiveRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/106189092448679/messages" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.1"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	sections := []ListSection{{Rows: []ListRow{{ID: "language:en", Title: "English"}}}}
+
+	_, err := client.SendInteractiveList("15550909792", "4917635163191", "Choose", "Languages", sections)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if payload.Type != MessageTypeInteractive || payload.Interactive.Type != InteractiveTypeList {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if payload.Interactive.Action.Sections[0].Rows[0].ID != "language:en" {
+		t.Errorf("unexpected rows: %+v", payload.Interactive.Action.Sections)
+	}
+}
+
+func TestSendLocation_Success(t *testing.T) {
+	var payload SendLocationRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.1"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	_, err := client.SendLocation("15550909792", "4917635163191", Location{Latitude: 40.4093, Longitude: 49.8671, Name: "Nizami branch", Address: "Nizami st. 1, Baku"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if payload.Type != MessageTypeLocation || payload.Location.Latitude != 40.4093 || payload.Location.Name != "Nizami branch" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestSendContacts_Success(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.1"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	_, err := client.SendContacts("15550909792", "4917635163191", []Contact{{
+		Name:   ContactName{FormattedName: "Reception", FirstName: "Reception"},
+		Phones: []ContactPhone{{Phone: "+994124040404", Type: "WORK"}},
+	}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	contacts, _ := payload["contacts"].([]interface{})
+	if payload["type"] != MessageTypeContacts || len(contacts) != 1 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	contact := contacts[0].(map[string]interface{})
+	if _, ok := contact["org"]; ok {
+		t.Errorf("expected no org in the contact card, got %+v", contact)
+	}
+	if contact["name"].(map[string]interface{})["formatted_name"] != "Reception" {
+		t.Errorf("unexpected contact card: %+v", contact)
+	}
+}
+
+func TestSendReaction_Success(t *testing.T) {
+	var payload SendReactionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.2"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	_, err := client.SendReaction("15550909792", "4917635163191", "wamid.1", "👍")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if payload.Type != MessageTypeReaction || payload.Reaction.MessageID != "wamid.1" || payload.Reaction.Emoji != "👍" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestMarkAsRead_Success(t *testing.T) {
+	var payloads []MarkAsReadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload MarkAsReadRequest
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		if payload.MessageID == "invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"Invalid parameter","type":"OAuthException","code":100}}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true}`)
+	}))
+	defer server.Close()
+
+	recorder := &fakeRecorder{}
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	client.SetRecorder(recorder)
+
+	err := client.MarkAsRead("15550909792", "wamid.1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	err = client.SendTypingIndicator("15550909792", "wamid.2")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	err = client.MarkAsRead("15550909792", "invalid")
+	if err == nil {
+		t.Errorf("expected an error marking an invalid message as read")
+	}
+
+	if len(payloads) != 3 || payloads[0].Status != StatusRead || payloads[0].MessageID != "wamid.1" || payloads[0].TypingIndicator != nil {
+		t.Fatalf("unexpected payloads: %+v", payloads)
+	}
+	if payloads[1].TypingIndicator == nil || payloads[1].TypingIndicator.Type != TypingIndicatorText {
+		t.Errorf("expected a typing indicator, got %+v", payloads[1])
+	}
+	if len(recorder.records) != 0 {
+		t.Errorf("expected read receipts not to be recorded, got %+v", recorder.records)
+	}
+}
+
+func TestSendMessageText_ReplyTo(t *testing.T) {
+	var payloads []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.2"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	_, err := client.SendMessageText("15550909792", "Hello", "4917635163191", ReplyTo("wamid.1"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	_, err = client.SendMessageText("15550909792", "Hello", "4917635163191")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %+v", payloads)
+	}
+	context, _ := payloads[0]["context"].(map[string]interface{})
+	if context["message_id"] != "wamid.1" {
+		t.Errorf("expected the reply to quote wamid.1, got %+v", payloads[0])
+	}
+	if _, ok := payloads[1]["context"]; ok {
+		t.Errorf("expected no context, got %+v", payloads[1])
+	}
+}
+
+func TestSendMessage_TemplateParameters(t *testing.T) {
+	var payload SendMessagePayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","messages":[{"id":"wamid.3"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	resp, err := client.SendMessage("15550909792", "4917635163191", "results_ready", "en", TemplateParameters("T.A", "19.04.2023"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].ID != "wamid.3" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	components := payload.Template.Components
+	if len(components) != 1 || components[0].Type != "body" || len(components[0].Parameters) != 2 || components[0].Parameters[1].Text != "19.04.2023" {
+		t.Errorf("expected the parameters in the body component, got %+v", components)
+	}
+}
+
+func TestSendMessageText_Recorded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload SendMessageText
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.To == "invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"Invalid parameter","type":"OAuthException","code":100}}`)
+			return
+		}
+		fmt.Fprint(w, `{"messaging_product":"whatsapp","contacts":[{"input":"4917635163191","wa_id":"4917635163191"}],"messages":[{"id":"wamid.1"}]}`)
+	}))
+	defer server.Close()
+
+	recorder := &fakeRecorder{}
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	client.SetRecorder(recorder)
+
+	_, err := client.SendMessageText("15550909792", "Hello", "4917635163191")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	_, err = client.SendMessageText("15550909792", "Hello", "invalid")
+	var rejected *ResponseError
+	if !errors.As(err, &rejected) || rejected.Code != 100 {
+		t.Fatalf("expected the rejection of the Graph API, got %v", err)
+	}
+
+	if len(recorder.records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", recorder.records)
+	}
+	sent, failed := recorder.records[0], recorder.records[1]
+	if sent.MessageID != "wamid.1" || sent.Error != "" || sent.Body != "Hello" || sent.Type != MessageTypeText {
+		t.Errorf("unexpected record: %+v", sent)
+	}
+	if failed.MessageID != "" || failed.Error != "whatsapp: 100: Invalid parameter" {
+		t.Errorf("unexpected record: %+v", failed)
+	}
+}
+
+func TestSendMessage_Rejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("case") {
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "Service Unavailable")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"Re-engagement message","type":"OAuthException","code":131047}}`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("552041023667800", "", server.URL+"/", "token")
+	_, err := client.SendMessage("15550909792", "4917635163191", "results_ready", "en")
+	var rejected *ResponseError
+	if !errors.As(err, &rejected) || rejected.Code != 131047 {
+		t.Errorf("expected the rejection of the template, got %v", err)
+	}
+
+	_, err = client.SendDocument("15550909792", "https://example.com/r.pdf", "4917635163191", "", true)
+	if !errors.As(err, &rejected) || rejected.Code != 131047 {
+		t.Errorf("expected the rejection of the document, got %v", err)
+	}
+
+	client = NewClient("552041023667800", "", server.URL+"/?case=unavailable&", "token")
+	_, err = client.SendMessageText("15550909792", "Hello", "4917635163191")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the status of the failed request, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package results
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotConfigured is returned by NotConfigured lookups
+var ErrNotConfigured = errors.New("results lookup not configured")
+
+// Result describes whether the analysis results of a patient are ready
+// and where the document can be fetched from.
+// The Date is zero when the source doesn't tell when the results were issued.
+type Result struct {
+	Ready       bool
+	DocumentURL string
+	Date        time.Time
+}
+
+// LocalStore looks up the documents saved on disk by the upload endpoint.
+type LocalStore struct {
+	Dir                 string
+	DocumentURLTemplate string
+}
+
+// NewLocalStore returns a LocalStore reading documents from dir.
+// The documentURLTemplate is formatted with the number and must point
+// to a public URL serving the document, e.g. https://host/api/v1/%s/document
+func NewLocalStore(dir, documentURLTemplate string) LocalStore {
+	return LocalStore{
+		Dir:                 dir,
+		DocumentURLTemplate: documentURLTemplate,
+	}
+}
+
+// Path returns the path of the document of the number
+func (s LocalStore) Path(number string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s.pdf", number))
+}
+
+func (s LocalStore) Lookup(number string) (Result, error) {
+	info, err := os.Stat(s.Path(number))
+	if os.IsNotExist(err) {
+		return Result{}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Ready: true, DocumentURL: formatURL(s.DocumentURLTemplate, number), Date: info.ModTime()}, nil
+}
+
+// HTTPLookup looks up the documents on a remote HTTP endpoint.
+// A 2xx response means the results are ready and a 404 that they are not,
+// any other status is an error.
+type HTTPLookup struct {
+	URLTemplate         string
+	DocumentURLTemplate string
+	AuthHeader          string
+	AuthValue           string
+	client              *http.Client
+}
+
+// NewHTTPLookup returns a HTTPLookup requesting the URL built from urlTemplate.
+// The lookup URL is authenticated, so the document sent to the patient is
+// built from documentURLTemplate, which must point to a public URL.
+// The authHeader is only sent when it is not empty.
+func NewHTTPLookup(urlTemplate, documentURLTemplate, authHeader, authValue string) HTTPLookup {
+	return HTTPLookup{
+		URLTemplate:         urlTemplate,
+		DocumentURLTemplate: documentURLTemplate,
+		AuthHeader:          authHeader,
+		AuthValue:           authValue,
+		client:              &http.Client{},
+	}
+}
+
+func (l HTTPLookup) Lookup(number string) (Result, error) {
+	url := formatURL(l.URLTemplate, number)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if l.AuthHeader != "" {
+		req.Header.Set(l.AuthHeader, l.AuthValue)
+	}
+
+	client := l.client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Result{}, nil
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return Result{}, fmt.Errorf("results lookup for %s failed with status %d", number, resp.StatusCode)
+	}
+
+	date, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Result{Ready: true, DocumentURL: formatURL(l.DocumentURLTemplate, number), Date: date}, nil
+}
+
+// NotConfigured fails every lookup, it is used when no results source is set.
+type NotConfigured struct{}
+
+func (NotConfigured) Lookup(number string) (Result, error) {
+	return Result{}, ErrNotConfigured
+}
+
+// Stub returns the configured results, it is meant to be used in tests.
+type Stub struct {
+	Results map[string]Result
+	Err     error
+}
+
+func (s Stub) Lookup(number string) (Result, error) {
+	if s.Err != nil {
+		return Result{}, s.Err
+	}
+
+	return s.Results[number], nil
+}
+
+func formatURL(template, number string) string {
+	if template == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(template, number)
+}
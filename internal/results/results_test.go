@@ -0,0 +1,101 @@
+package results
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStore_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "994503981865.pdf"), []byte("%PDF-1.4"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write document: %v", err)
+	}
+
+	store := NewLocalStore(dir, "https://example.com/api/v1/%s/document")
+
+	result, err := store.Lookup("994503981865")
+	if err != nil {
+		t.Fatalf("error looking up result: %v", err)
+	}
+	if !result.Ready {
+		t.Errorf("expected result to be ready")
+	}
+	if result.DocumentURL != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("unexpected document url: %s", result.DocumentURL)
+	}
+
+	result, err = store.Lookup("4917635163191")
+	if err != nil {
+		t.Fatalf("error looking up result: %v", err)
+	}
+	if result.Ready {
+		t.Errorf("expected result not to be ready")
+	}
+}
+
+func TestHTTPLookup_Lookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/results/994503981865":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	lookup := NewHTTPLookup(server.URL+"/results/%s", "https://example.com/api/v1/%s/document", "Authorization", "Bearer secret")
+
+	result, err := lookup.Lookup("994503981865")
+	if err != nil {
+		t.Fatalf("error looking up result: %v", err)
+	}
+	if !result.Ready || result.DocumentURL != "https://example.com/api/v1/994503981865/document" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	result, err = lookup.Lookup("4917635163191")
+	if err != nil {
+		t.Fatalf("error looking up result: %v", err)
+	}
+	if result.Ready {
+		t.Errorf("expected result not to be ready")
+	}
+}
+
+func TestHTTPLookup_LookupErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	lookup := NewHTTPLookup(server.URL+"/results/%s", "https://example.com/api/v1/%s/document", "Authorization", "Bearer secret")
+	if _, err := lookup.Lookup("994503981865"); err == nil {
+		t.Errorf("expected an error for a failing lookup")
+	}
+
+	lookup = NewHTTPLookup(server.URL+"/results/%s", "https://example.com/api/v1/%s/document", "Authorization", "Bearer wrong")
+	if _, err := lookup.Lookup("994503981865"); err == nil {
+		t.Errorf("expected an error for an unauthorized lookup")
+	}
+}
+
+func TestNotConfigured_Lookup(t *testing.T) {
+	_, err := NotConfigured{}.Lookup("994503981865")
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured, got %v", err)
+	}
+}
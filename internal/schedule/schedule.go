@@ -0,0 +1,236 @@
+package schedule
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+// The statuses of a job. A job is claimed as sending before its message is
+// sent, so a job is never fired twice. A claim whose outcome was never stored,
+// e.g. after a crash, expires to unknown: the message may have been sent, so
+// the job isn't retried.
+const (
+	StatusScheduled = "scheduled"
+	StatusSending   = "sending"
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+	StatusUnknown   = "unknown"
+)
+
+var (
+	ErrNotFound = errors.New("scheduled message not found")
+	// ErrNotScheduled is returned for cancelling or rescheduling a job which already fired
+	ErrNotScheduled = errors.New("message is no longer scheduled")
+)
+
+// Job sends a message at a time. The message is kept as JSON, the scheduler
+// only tells when it is due.
+type Job struct {
+	ID        string          `json:"id"`
+	To        string          `json:"to"`
+	Message   json.RawMessage `json:"message"`
+	SendAt    time.Time       `json:"send_at"`
+	Status    string          `json:"status"`
+	MessageID string          `json:"message_id,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	FiredAt   *time.Time      `json:"fired_at,omitempty"`
+}
+
+var migrations = []string{
+	`CREATE TABLE scheduled_messages (
+		id TEXT PRIMARY KEY,
+		recipient TEXT NOT NULL,
+		message TEXT NOT NULL,
+		send_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		message_id TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		fired_at TIMESTAMP
+	);
+	CREATE INDEX scheduled_messages_due ON scheduled_messages (status, send_at);`,
+	`ALTER TABLE scheduled_messages ADD COLUMN claimed_at TIMESTAMP;`,
+}
+
+// Repository stores the jobs in SQLite so they survive restarts
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository returns a Repository on the database, migrating its schema
+func NewRepository(db *sql.DB) (*Repository, error) {
+	err := storage.Migrate(db, "schedule", migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Create stores the job as scheduled, the ID and the creation time are set
+func (r *Repository) Create(job Job) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	job.ID = id
+	job.Status = StatusScheduled
+	job.SendAt = job.SendAt.UTC()
+	job.CreatedAt = time.Now().UTC()
+
+	_, err = r.db.Exec(`INSERT INTO scheduled_messages (id, recipient, message, send_at, status, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		job.ID, job.To, string(job.Message), job.SendAt, job.Status, job.CreatedAt)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+func (r *Repository) Get(id string) (Job, error) {
+	job, err := scanJob(r.db.QueryRow(`SELECT id, recipient, message, send_at, status, message_id, error, created_at, fired_at FROM scheduled_messages WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return job, err
+}
+
+// Due returns the scheduled jobs whose time came, the earliest first
+func (r *Repository) Due(now time.Time, limit int) ([]Job, error) {
+	return r.jobs(`SELECT id, recipient, message, send_at, status, message_id, error, created_at, fired_at FROM scheduled_messages
+		WHERE status = ? AND send_at <= ? ORDER BY send_at LIMIT ?`, StatusScheduled, now.UTC(), limit)
+}
+
+// List returns the jobs with the status, the earliest first
+func (r *Repository) List(status string, limit int) ([]Job, error) {
+	return r.jobs(`SELECT id, recipient, message, send_at, status, message_id, error, created_at, fired_at FROM scheduled_messages
+		WHERE status = ? ORDER BY send_at LIMIT ?`, status, limit)
+}
+
+func (r *Repository) jobs(query string, args ...interface{}) ([]Job, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Claim marks the scheduled job as sending, it returns false if the job
+// was cancelled or rescheduled since it was found due
+func (r *Repository) Claim(id string, now time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE scheduled_messages SET status = ?, claimed_at = ? WHERE id = ? AND status = ? AND send_at <= ?`,
+		StatusSending, time.Now().UTC(), id, StatusScheduled, now.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	claimed, err := res.RowsAffected()
+	return claimed > 0, err
+}
+
+// ExpireClaims moves the jobs claimed before the time and still sending
+// to unknown, and returns how many were moved
+func (r *Repository) ExpireClaims(before time.Time) (int64, error) {
+	res, err := r.db.Exec(`UPDATE scheduled_messages SET status = ?, error = ?
+		WHERE status = ? AND (claimed_at IS NULL OR claimed_at < ?)`,
+		StatusUnknown, "the send was interrupted, the message may have been sent", StatusSending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Finish stores the outcome of the fired job: its status, the ID of the sent message or the error
+func (r *Repository) Finish(id, status, messageID, errorText string, at time.Time) error {
+	_, err := r.db.Exec(`UPDATE scheduled_messages SET status = ?, message_id = ?, error = ?, fired_at = ? WHERE id = ?`,
+		status, messageID, errorText, at.UTC(), id)
+	return err
+}
+
+// Cancel cancels the scheduled job
+func (r *Repository) Cancel(id string) (Job, error) {
+	return r.update(id, `UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?`, StatusCancelled, id, StatusScheduled)
+}
+
+// Reschedule moves the scheduled job to another time
+func (r *Repository) Reschedule(id string, sendAt time.Time) (Job, error) {
+	return r.update(id, `UPDATE scheduled_messages SET send_at = ? WHERE id = ? AND status = ?`, sendAt.UTC(), id, StatusScheduled)
+}
+
+// update runs the update of a scheduled job and returns the updated job
+func (r *Repository) update(id, query string, args ...interface{}) (Job, error) {
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return Job{}, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job, err := r.Get(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if updated == 0 {
+		return Job{}, fmt.Errorf("%w: %s is %s", ErrNotScheduled, id, job.Status)
+	}
+
+	return job, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	var message string
+	var firedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.To, &message, &job.SendAt, &job.Status, &job.MessageID, &job.Error, &job.CreatedAt, &firedAt)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job.Message = json.RawMessage(message)
+	if firedAt.Valid {
+		job.FiredAt = &firedAt.Time
+	}
+
+	return job, nil
+}
+
+// newID returns a random job ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,133 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebrizetayi/messaging-integration-service/internal/storage"
+)
+
+func TestRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.db")
+	db, err := storage.Open(path)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	now := time.Date(2023, 4, 19, 10, 0, 0, 0, time.UTC)
+	message := json.RawMessage(`{"to":"994503981865","type":"text","text":"Please collect your originals"}`)
+	job, err := repository.Create(Job{To: "994503981865", Message: message, SendAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("error creating job: %v", err)
+	}
+	other, err := repository.Create(Job{To: "994503981866", Message: message, SendAt: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("error creating job: %v", err)
+	}
+
+	// The jobs survive a restart
+	db.Close()
+	db, err = storage.Open(path)
+	if err != nil {
+		t.Fatalf("error reopening database: %v", err)
+	}
+	defer db.Close()
+	repository, err = NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	due, err := repository.Due(now.Add(time.Hour), 10)
+	if err != nil || len(due) != 1 || due[0].ID != job.ID || string(due[0].Message) != string(message) {
+		t.Fatalf("expected the first job to be due, got %+v %v", due, err)
+	}
+
+	_, err = repository.Reschedule(job.ID, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("error rescheduling job: %v", err)
+	}
+	claimed, err := repository.Claim(job.ID, now.Add(time.Hour))
+	if err != nil || claimed {
+		t.Errorf("expected the rescheduled job not to be claimed, got %v %v", claimed, err)
+	}
+
+	claimed, err = repository.Claim(job.ID, now.Add(3*time.Hour))
+	if err != nil || !claimed {
+		t.Fatalf("expected the job to be claimed, got %v %v", claimed, err)
+	}
+	err = repository.Finish(job.ID, StatusSent, "wamid.1", "", now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("error finishing job: %v", err)
+	}
+
+	job, err = repository.Get(job.ID)
+	if err != nil || job.Status != StatusSent || job.MessageID != "wamid.1" || job.FiredAt == nil {
+		t.Errorf("unexpected sent job %+v %v", job, err)
+	}
+	_, err = repository.Cancel(job.ID)
+	if !errors.Is(err, ErrNotScheduled) {
+		t.Errorf("expected a sent job not to be cancelled, got %v", err)
+	}
+
+	other, err = repository.Cancel(other.ID)
+	if err != nil || other.Status != StatusCancelled {
+		t.Errorf("unexpected cancelled job %+v %v", other, err)
+	}
+	due, err = repository.Due(now.Add(24*time.Hour), 10)
+	if err != nil || len(due) != 0 {
+		t.Errorf("expected no due jobs, got %+v %v", due, err)
+	}
+
+	_, err = repository.Reschedule("unknown", now)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRepository_ExpireClaims(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "schedule.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+	repository, err := NewRepository(db)
+	if err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	now := time.Now()
+	job, err := repository.Create(Job{To: "994503981865", Message: json.RawMessage(`{}`), SendAt: now})
+	if err != nil {
+		t.Fatalf("error creating job: %v", err)
+	}
+	claimed, err := repository.Claim(job.ID, now)
+	if err != nil || !claimed {
+		t.Fatalf("expected the job to be claimed, got %v %v", claimed, err)
+	}
+
+	expired, err := repository.ExpireClaims(now.Add(-time.Hour))
+	if err != nil || expired != 0 {
+		t.Errorf("expected a recent claim not to expire, got %d %v", expired, err)
+	}
+	expired, err = repository.ExpireClaims(now.Add(time.Minute))
+	if err != nil || expired != 1 {
+		t.Fatalf("expected the claim to expire, got %d %v", expired, err)
+	}
+
+	unknown, err := repository.List(StatusUnknown, 10)
+	if err != nil || len(unknown) != 1 || unknown[0].ID != job.ID || unknown[0].Error == "" {
+		t.Errorf("expected the expired job to be unknown, got %+v %v", unknown, err)
+	}
+	due, err := repository.Due(now.Add(time.Hour), 10)
+	if err != nil || len(due) != 0 {
+		t.Errorf("expected the unknown job not to be due again, got %+v %v", due, err)
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	// Registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open opens the SQLite database at path, creating it if needed
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_foreign_keys=on", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, sharing one connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Migrate applies the migrations of the component which were not applied yet.
+// Migrations are identified by their index, so new migrations must only be appended.
+func Migrate(db *sql.DB, component string, migrations []string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		component TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		applied_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (component, version)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var applied int
+	err = db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE component = ?`, component).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	for version := applied; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[version])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %d of %s: %w", version+1, component, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO schema_migrations (component, version, applied_at) VALUES (?, ?, ?)`, component, version+1, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	migrations := []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY)`,
+	}
+	err = Migrate(db, "test", migrations)
+	if err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+
+	// Applying the migrations again only applies the new ones
+	migrations = append(migrations, `ALTER TABLE items ADD COLUMN name TEXT`)
+	err = Migrate(db, "test", migrations)
+	if err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+	err = Migrate(db, "test", migrations)
+	if err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO items (id, name) VALUES (1, 'name')`)
+	if err != nil {
+		t.Errorf("expected the name column to exist: %v", err)
+	}
+
+	var versions int
+	db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE component = 'test'`).Scan(&versions)
+	if versions != 2 {
+		t.Errorf("expected 2 applied migrations, got %d", versions)
+	}
+}